@@ -14,7 +14,11 @@
 
 package api
 
-import em "github.com/vansante/go-event-emitter"
+import (
+	"time"
+
+	em "github.com/vansante/go-event-emitter"
+)
 
 type IString interface {
 	String() string
@@ -48,6 +52,18 @@ type IRemoveRules interface {
 	RemoveRules(rules [][]string) error
 }
 
+type IUpdateRule interface {
+	UpdateRule(oldRule, newRule []string) error
+}
+
+type IUpdateRuleBool interface {
+	UpdateRule(oldRule, newRule []string) (bool, error)
+}
+
+type IUpdateRules interface {
+	UpdateRules(oldRules, newRules [][]string) error
+}
+
 type IAddListener interface {
 	AddListener(event em.EventType, handler em.HandleFunc) (listener *em.Listener)
 }
@@ -56,11 +72,27 @@ type IRemoveListener interface {
 	RemoveListener(event em.EventType, listener *em.Listener)
 }
 
+type IEmitEvent interface {
+	EmitEvent(event em.EventType, arguments ...interface{})
+}
+
 type IAddRemoveListener interface {
 	IAddListener
 	IRemoveListener
 }
 
+type IHasRule interface {
+	HasRule(rule []string) bool
+}
+
 type IRangeRules interface {
 	RangeRules(fn func(rule []string) bool)
 }
+
+type ISetExpiry interface {
+	SetExpiry(rule []string, at time.Time) error
+}
+
+type IGetExpiry interface {
+	GetExpiry(rule []string) (time.Time, bool)
+}
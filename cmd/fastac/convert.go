@@ -0,0 +1,72 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+
+	"github.com/abichinger/fastac/model"
+)
+
+// ruleList keeps the rules in the order of the source file
+type ruleList struct {
+	rules [][]string
+}
+
+func (l *ruleList) AddRule(rule []string) (bool, error) {
+	l.rules = append(l.rules, rule)
+	return true, nil
+}
+
+func (l *ruleList) RangeRules(fn func(rule []string) bool) {
+	for _, rule := range l.rules {
+		if !fn(rule) {
+			return
+		}
+	}
+}
+
+// runConvert converts a policy file, the formats are chosen by the file extensions.
+// The model is required for JSON and YAML documents, the columns of the documents are named after the policy definitions.
+//
+//  fastac convert -m model.conf policy.csv policy.json
+func runConvert(args []string, s *streams) (int, error) {
+	flags := newFlagSet("convert", s.err)
+	modelPath := flags.String("m", "", "path of the model CONF file, required for JSON and YAML")
+	if err := flags.Parse(args); err != nil {
+		return exitError, err
+	}
+	if flags.NArg() != 2 {
+		return exitError, errors.New("source and target file are required")
+	}
+
+	var m model.IModel
+	if *modelPath != "" {
+		loaded, err := model.NewModelFromFile(*modelPath)
+		if err != nil {
+			return exitError, err
+		}
+		m = loaded
+	}
+
+	rules := &ruleList{}
+	if err := newAdapter(flags.Arg(0), m).LoadPolicy(rules); err != nil {
+		return exitError, err
+	}
+	if err := newAdapter(flags.Arg(1), m).SavePolicy(rules); err != nil {
+		return exitError, err
+	}
+	return exitOK, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/abichinger/fastac"
+)
+
+// runEnforce decides a request, the exit code is 0 if the request is allowed and 1 if it is denied
+//
+//  fastac enforce -m model.conf -p policy.csv alice data1 read
+//  allow, decided by p, alice, data1, read
+func runEnforce(args []string, s *streams) (int, error) {
+	flags := newFlagSet("enforce", s.err)
+	pf := &policyFlags{}
+	pf.register(flags)
+	matcher := flags.String("matcher", "", "key or expression of the matcher (default: m)")
+	request := flags.String("request", "", "key of the request definition (default: r)")
+	if err := flags.Parse(args); err != nil {
+		return exitError, err
+	}
+	if flags.NArg() == 0 {
+		return exitError, errors.New("request values are missing")
+	}
+	e, err := pf.enforcer()
+	if err != nil {
+		return exitError, err
+	}
+
+	allow, err := decide(e, s.out, flags.Args(), fastac.SetMatcher(*matcher), fastac.SetRequestDef(*request))
+	if err != nil {
+		return exitError, err
+	}
+	if allow {
+		return exitOK, nil
+	}
+	return exitProblems, nil
+}
+
+// decide enforces the request and writes the decision and the deciding rule to w
+func decide(e *fastac.Enforcer, w io.Writer, values []string, options ...fastac.ContextOption) (bool, error) {
+	params := toInterfaces(values)
+	for _, option := range options {
+		params = append(params, option)
+	}
+	allow, rule, err := e.EnforceEx(params...)
+	if err != nil {
+		return false, err
+	}
+
+	decision := "deny"
+	if allow {
+		decision = "allow"
+	}
+	if len(rule) > 0 {
+		fmt.Fprintf(w, "%s, decided by %s\n", decision, formatRule(rule))
+	} else {
+		fmt.Fprintf(w, "%s, decided by the policy effect\n", decision)
+	}
+	return allow, nil
+}
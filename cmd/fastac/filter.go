@@ -0,0 +1,54 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/abichinger/fastac"
+)
+
+// runFilter prints the rules, which match the matcher. The matcher is a key or an expression, request values may follow the matcher.
+//
+//  fastac filter -m model.conf -p policy.csv 'g.user == "alice"'
+//  fastac filter -m model.conf -p policy.csv m alice data1 read
+func runFilter(args []string, s *streams) (int, error) {
+	flags := newFlagSet("filter", s.err)
+	pf := &policyFlags{}
+	pf.register(flags)
+	request := flags.String("request", "", "key of the request definition (default: r)")
+	if err := flags.Parse(args); err != nil {
+		return exitError, err
+	}
+	if flags.NArg() == 0 {
+		return exitError, errors.New("matcher is missing")
+	}
+	e, err := pf.enforcer()
+	if err != nil {
+		return exitError, err
+	}
+
+	params := append([]interface{}{fastac.SetMatcher(flags.Arg(0)), fastac.SetRequestDef(*request)}, toInterfaces(flags.Args()[1:])...)
+	rules, err := e.Filter(params...)
+	if err != nil {
+		return exitError, err
+	}
+	sortRules(rules)
+	for _, rule := range rules {
+		fmt.Fprintln(s.out, formatRule(rule))
+	}
+	return exitOK, nil
+}
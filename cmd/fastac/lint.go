@@ -0,0 +1,98 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/abichinger/fastac/lint"
+	"github.com/abichinger/fastac/model"
+)
+
+// lintReport contains the problems of the model, the role hierarchies by role definition key and the policy rules
+type lintReport struct {
+	Model []string            `json:"model"`
+	Roles map[string][]string `json:"roles"`
+	Rules []*lint.Finding     `json:"rules"`
+}
+
+func (r *lintReport) empty() bool {
+	return len(r.Model) == 0 && len(r.Roles) == 0 && len(r.Rules) == 0
+}
+
+// runLint validates the model, the rules are only checked if the model is valid
+//
+//  fastac lint -m model.conf -p policy.csv -format json
+func runLint(args []string, s *streams) (int, error) {
+	flags := newFlagSet("lint", s.err)
+	pf := &policyFlags{}
+	pf.register(flags)
+	format := flags.String("format", "text", "output format: text or json")
+	if err := flags.Parse(args); err != nil {
+		return exitError, err
+	}
+	if pf.model == "" {
+		return exitError, errors.New("flag -m is required")
+	}
+	if *format != "text" && *format != "json" {
+		return exitError, fmt.Errorf("unknown format %s", *format)
+	}
+
+	report := &lintReport{Model: []string{}, Roles: map[string][]string{}, Rules: []*lint.Finding{}}
+	for _, err := range model.ValidateFile(pf.model) {
+		report.Model = append(report.Model, err.Error())
+	}
+	if len(report.Model) == 0 && pf.policy != "" {
+		e, err := pf.enforcer()
+		if err != nil {
+			return exitError, err
+		}
+		for key, errs := range e.GetModel().ValidateRoles() {
+			for _, err := range errs {
+				report.Roles[key] = append(report.Roles[key], err.Error())
+			}
+		}
+		if report.Rules, err = lint.Lint(e.GetModel()); err != nil {
+			return exitError, err
+		}
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(s.out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return exitError, err
+		}
+	} else {
+		for _, msg := range report.Model {
+			fmt.Fprintf(s.out, "%s: %s\n", pf.model, msg)
+		}
+		for _, key := range sortedKeys(report.Roles) {
+			for _, msg := range report.Roles[key] {
+				fmt.Fprintf(s.out, "%s: %s: %s\n", pf.policy, key, msg)
+			}
+		}
+		for _, f := range report.Rules {
+			fmt.Fprintf(s.out, "%s: %s\n", pf.policy, f)
+		}
+	}
+
+	if report.empty() {
+		return exitOK, nil
+	}
+	return exitProblems, nil
+}
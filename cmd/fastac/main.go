@@ -0,0 +1,115 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command fastac queries and checks models and policies from the command line.
+// Policies are read by file extension as JSON (.json), YAML (.yaml, .yml) or CSV (everything else).
+//
+//  fastac enforce -m model.conf -p policy.csv alice data1 read
+//  fastac filter -m model.conf -p policy.csv 'p.sub == "alice"'
+//  fastac lint -m model.conf -p policy.csv
+//  fastac test -m model.conf -p policy.csv policy_tests.yaml
+//  fastac convert -m model.conf policy.csv policy.yaml
+//  fastac repl -m model.conf -p policy.csv
+//  fastac serve -m model.conf -p policy.csv -addr :8080
+//
+// The exit code is 0 on success, 1 if a request was denied or problems were found and 2 on invalid usage or errors.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	exitOK       = 0
+	exitProblems = 1
+	exitError    = 2
+)
+
+// streams are the standard streams of a command
+type streams struct {
+	in  io.Reader
+	out io.Writer
+	err io.Writer
+}
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string, s *streams) (int, error)
+}
+
+var commands = []*command{
+	{"enforce", "decide a request and explain the decision", runEnforce},
+	{"filter", "print the rules, which match a matcher", runFilter},
+	{"lint", "validate a model and the rules of a policy", runLint},
+	{"test", "check the decisions of a policy against a test file", runTest},
+	{"convert", "convert a policy between CSV, JSON and YAML", runConvert},
+	{"repl", "query a policy interactively", runRepl},
+	{"serve", "start the HTTP authorization server", runServe},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], &streams{os.Stdin, os.Stdout, os.Stderr}))
+}
+
+func run(args []string, s *streams) int {
+	if len(args) == 0 {
+		usage(s.err)
+		return exitError
+	}
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		code, err := cmd.run(args[1:], s)
+		if err != nil {
+			if err != flag.ErrHelp {
+				fmt.Fprintf(s.err, "fastac %s: %s\n", cmd.name, err)
+			}
+			return exitError
+		}
+		return code
+	}
+	usage(s.err)
+	return exitError
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: fastac <command> [flags]")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+// newFlagSet creates the flag set of a command, errors are returned by Parse
+func newFlagSet(name string, output io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet("fastac "+name, flag.ContinueOnError)
+	flags.SetOutput(output)
+	return flags
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
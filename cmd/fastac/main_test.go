@@ -0,0 +1,174 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func runCommand(args ...string) (int, string, string) {
+	return runWithInput("", args...)
+}
+
+func runWithInput(input string, args ...string) (int, string, string) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := run(args, &streams{strings.NewReader(input), stdout, stderr})
+	return code, stdout.String(), stderr.String()
+}
+
+func writeTempFile(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "fastac")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestRun(t *testing.T) {
+	code, _, stderr := runCommand()
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "usage: fastac <command> [flags]")
+
+	code, _, _ = runCommand("unknown")
+	assert.Equal(t, exitError, code)
+
+	code, _, stderr = runCommand("serve", "-addr", "127.0.0.1:0")
+	assert.Equal(t, exitError, code)
+	assert.Equal(t, "fastac serve: flag -m is required\n", stderr)
+}
+
+func TestLint(t *testing.T) {
+	code, stdout, _ := runCommand("lint", "-m", "../../examples/rbac_model.conf", "-p", "../../examples/rbac_policy.csv")
+	assert.Equal(t, exitOK, code)
+	assert.Empty(t, stdout)
+
+	policy := writeTempFile(t, "policy.csv", "p, admin, data1, read\np, alice, data1, read\ng, alice, admin\ng, bob, guest\n")
+	code, stdout, _ = runCommand("lint", "-m", "../../examples/rbac_model.conf", "-p", policy)
+	assert.Equal(t, exitProblems, code)
+	assert.Equal(t, policy+": g: error: role guest is not used by any policy and does not inherit other roles\n"+
+		policy+": p, alice, data1, read: duplicates p, admin, data1, read through roles\n", stdout)
+
+	code, stdout, _ = runCommand("lint", "-m", "../../examples/priority_model.conf", "-format", "json")
+	assert.Equal(t, exitProblems, code)
+	assert.JSONEq(t, `{"model": ["error: unsupported effect e = priority(p.eft)||deny"], "roles": {}, "rules": []}`, stdout)
+
+	code, _, stderr := runCommand("lint")
+	assert.Equal(t, exitError, code)
+	assert.Equal(t, "fastac lint: flag -m is required\n", stderr)
+}
+
+func TestEnforce(t *testing.T) {
+	args := []string{"enforce", "-m", "../../examples/rbac_with_deny_model.conf", "-p", "../../examples/rbac_with_deny_policy.csv"}
+
+	code, stdout, _ := runCommand(append(args, "alice", "data2", "read")...)
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "allow, decided by p, data2_admin, data2, read, allow\n", stdout)
+
+	code, stdout, _ = runCommand(append(args, "alice", "data2", "write")...)
+	assert.Equal(t, exitProblems, code)
+	assert.Equal(t, "deny, decided by p, alice, data2, write, deny\n", stdout)
+
+	code, stdout, _ = runCommand(append(args, "-matcher", "r.sub == p.sub && r.obj == p.obj && r.act == p.act", "alice", "data2", "read")...)
+	assert.Equal(t, exitProblems, code)
+	assert.Equal(t, "deny, decided by the policy effect\n", stdout)
+
+	code, _, stderr := runCommand(args...)
+	assert.Equal(t, exitError, code)
+	assert.Equal(t, "fastac enforce: request values are missing\n", stderr)
+
+	//a matcher without policy can not decide requests
+	code, _, stderr = runCommand(append(args, "-matcher", `g.user == "alice"`, "alice", "data2", "read")...)
+	assert.Equal(t, exitError, code)
+	assert.Equal(t, "fastac enforce: error: policy g not found\n", stderr)
+}
+
+func TestFilter(t *testing.T) {
+	args := []string{"filter", "-m", "../../examples/rbac_model.conf", "-p", "../../examples/rbac_policy.csv"}
+
+	code, stdout, _ := runCommand(append(args, `p.obj == "data2"`)...)
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "p, bob, data2, write\np, data2_admin, data2, read\np, data2_admin, data2, write\n", stdout)
+
+	code, stdout, _ = runCommand(append(args, "m", "alice", "data2", "read")...)
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "p, data2_admin, data2, read\n", stdout)
+}
+
+func TestConvert(t *testing.T) {
+	model := "../../examples/rbac_model.conf"
+	jsonPolicy := writeTempFile(t, "policy.json", "")
+	yamlPolicy := filepath.Join(filepath.Dir(jsonPolicy), "policy.yaml")
+	csvPolicy := filepath.Join(filepath.Dir(jsonPolicy), "policy.csv")
+
+	code, _, _ := runCommand("convert", "-m", model, "../../examples/rbac_policy.csv", jsonPolicy)
+	assert.Equal(t, exitOK, code)
+	data, _ := ioutil.ReadFile(jsonPolicy)
+	assert.Contains(t, string(data), `"sub": "alice"`)
+
+	code, _, _ = runCommand("convert", "-m", model, jsonPolicy, yamlPolicy)
+	assert.Equal(t, exitOK, code)
+	code, _, _ = runCommand("convert", "-m", model, yamlPolicy, csvPolicy)
+	assert.Equal(t, exitOK, code)
+	data, _ = ioutil.ReadFile(csvPolicy)
+	assert.Equal(t, "g, alice, data2_admin\np, alice, data1, read\np, bob, data2, write\np, data2_admin, data2, read\np, data2_admin, data2, write\n", string(data))
+
+	code, _, stderr := runCommand("convert", "../../examples/rbac_policy.csv", jsonPolicy)
+	assert.Equal(t, exitError, code)
+	assert.Equal(t, "fastac convert: error: policy p not found\n", stderr)
+}
+
+func TestRepl(t *testing.T) {
+	input := strings.Join([]string{
+		"enforce alice, data2, read",
+		"filter p.sub == \"bob\"",
+		"roles alice",
+		"users data2_admin",
+		"unknown",
+		"exit",
+		"rules",
+	}, "\n")
+	code, stdout, _ := runWithInput(input, "repl", "-m", "../../examples/rbac_model.conf", "-p", "../../examples/rbac_policy.csv")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, strings.Join([]string{
+		"> allow, decided by p, data2_admin, data2, read",
+		"> p, bob, data2, write",
+		"> data2_admin",
+		"> alice",
+		"> unknown command unknown, enter help for a list of commands",
+		"> ",
+	}, "\n"), stdout)
+}
+
+func TestPolicyTests(t *testing.T) {
+	args := []string{"test", "-m", "../../examples/rbac_with_deny_model.conf", "-p", "../../examples/rbac_with_deny_policy.csv"}
+	code, stdout, _ := runCommand(append(args, "-v", "../../examples/rbac_with_deny_tests.yaml")...)
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "ok   alice reads data1\nok   alice reads data2 as data2_admin\nok   alice must not write data2\nok   bob reads data1\n"+
+		"../../examples/rbac_with_deny_tests.yaml: 4 passed, 0 failed\n", stdout)
+
+	tests := writeTempFile(t, "tests.yaml", "tests:\n  - request: bob, data1, read\n    expect: allow\n")
+	code, stdout, _ = runCommand(append(args, tests)...)
+	assert.Equal(t, exitProblems, code)
+	assert.Equal(t, "FAIL #1 (bob, data1, read)\n- allow\n+ deny\n"+tests+": 0 passed, 1 failed\n", stdout)
+}
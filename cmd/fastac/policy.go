@@ -0,0 +1,129 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/abichinger/fastac"
+	"github.com/abichinger/fastac/model"
+	"github.com/abichinger/fastac/model/defs"
+	"github.com/abichinger/fastac/storage"
+	"github.com/abichinger/fastac/storage/adapter"
+	"github.com/abichinger/fastac/util"
+)
+
+// policyFlags are the flags of commands, which load a model and a policy
+type policyFlags struct {
+	model  string
+	policy string
+}
+
+func (f *policyFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&f.model, "m", "", "path of the model CONF file (required)")
+	flags.StringVar(&f.policy, "p", "", "path of the policy file (CSV, JSON or YAML)")
+}
+
+// enforcer loads the model and the policy, changes of the rules are not stored
+func (f *policyFlags) enforcer() (*fastac.Enforcer, error) {
+	if f.model == "" {
+		return nil, errors.New("flag -m is required")
+	}
+	m, err := model.NewModelFromFile(f.model)
+	if err != nil {
+		return nil, err
+	}
+	if f.policy == "" {
+		return fastac.NewEnforcer(m, nil)
+	}
+	e, err := fastac.NewEnforcer(m, newAdapter(f.policy, m), fastac.OptionStorage(false))
+	if err != nil {
+		return nil, err
+	}
+	if err := e.LoadPolicy(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// newAdapter returns the adapter of the policy file, the format is chosen by the file extension.
+// The columns of JSON and YAML documents are named after the definitions of m.
+func newAdapter(path string, m model.IModel) storage.Adapter {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return adapter.NewJSONAdapter(path, policyDefs(m)...)
+	case ".yaml", ".yml":
+		return adapter.NewYAMLAdapter(path, policyDefs(m)...)
+	}
+	return adapter.NewFileAdapter(path)
+}
+
+// policyDefs returns the policy definitions and the policy definitions of the role definitions of m
+func policyDefs(m model.IModel) []*defs.PolicyDef {
+	pDefs := []*defs.PolicyDef{}
+	if m == nil {
+		return pDefs
+	}
+	m.RangeDefs(model.P_SEC, func(def defs.IDef) bool {
+		if pDef, ok := def.(*defs.PolicyDef); ok {
+			pDefs = append(pDefs, pDef)
+		}
+		return true
+	})
+	m.RangeDefs(model.G_SEC, func(def defs.IDef) bool {
+		if rDef, ok := def.(*defs.RoleDef); ok {
+			pDefs = append(pDefs, rDef.GetPolicyDef())
+		}
+		return true
+	})
+	return pDefs
+}
+
+// parseValues splits comma separated values, values without commas are split at white space
+//
+//  alice, data1, read
+//  alice data1 read
+func parseValues(line string) []string {
+	if !strings.Contains(line, ",") {
+		return strings.Fields(line)
+	}
+	values := strings.Split(line, ",")
+	for i, value := range values {
+		values[i] = strings.TrimSpace(value)
+	}
+	return values
+}
+
+func toInterfaces(values []string) []interface{} {
+	res := make([]interface{}, len(values))
+	for i, value := range values {
+		res[i] = value
+	}
+	return res
+}
+
+func formatRule(rule []string) string {
+	return strings.Join(rule, ", ")
+}
+
+func sortRules(rules [][]string) {
+	sort.Slice(rules, func(i, j int) bool {
+		return util.Hash(rules[i]) < util.Hash(rules[j])
+	})
+}
@@ -0,0 +1,137 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/abichinger/fastac"
+)
+
+const replHelp = `commands:
+  enforce <values>         decide a request, e.g. enforce alice, data1, read
+  filter <matcher>         print the matching rules, e.g. filter p.sub == "alice"
+  roles <user> [domain]    print the roles of a user
+  users <role> [domain]    print the users of a role
+  rules                    print all rules
+  help                     print this help
+  exit                     quit the repl`
+
+// runRepl reads commands from stdin until exit is entered or stdin is closed
+//
+//  fastac repl -m model.conf -p policy.csv
+//  > enforce alice data1 read
+//  allow, decided by p, alice, data1, read
+func runRepl(args []string, s *streams) (int, error) {
+	flags := newFlagSet("repl", s.err)
+	pf := &policyFlags{}
+	pf.register(flags)
+	roleKey := flags.String("g", "g", "key of the role definition used by roles and users")
+	if err := flags.Parse(args); err != nil {
+		return exitError, err
+	}
+	e, err := pf.enforcer()
+	if err != nil {
+		return exitError, err
+	}
+
+	scanner := bufio.NewScanner(s.in)
+	fmt.Fprint(s.out, "> ")
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "exit" || line == "quit" {
+			return exitOK, nil
+		}
+		if err := evalLine(e, s.out, line, *roleKey); err != nil {
+			fmt.Fprintln(s.out, err)
+		}
+		fmt.Fprint(s.out, "> ")
+	}
+	fmt.Fprintln(s.out)
+	if err := scanner.Err(); err != nil {
+		return exitError, err
+	}
+	return exitOK, nil
+}
+
+func evalLine(e *fastac.Enforcer, w io.Writer, line, roleKey string) error {
+	name, rest := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		name, rest = line[:i], strings.TrimSpace(line[i+1:])
+	}
+
+	switch name {
+	case "":
+		return nil
+	case "help":
+		fmt.Fprintln(w, replHelp)
+		return nil
+	case "enforce":
+		values := parseValues(rest)
+		if len(values) == 0 {
+			return errors.New("request values are missing")
+		}
+		_, err := decide(e, w, values)
+		return err
+	case "filter":
+		if rest == "" {
+			return errors.New("matcher is missing")
+		}
+		rules, err := e.Filter(fastac.SetMatcher(rest))
+		if err != nil {
+			return err
+		}
+		sortRules(rules)
+		for _, rule := range rules {
+			fmt.Fprintln(w, formatRule(rule))
+		}
+		return nil
+	case "roles", "users":
+		values := parseValues(rest)
+		if len(values) == 0 {
+			return errors.New("name is missing")
+		}
+		rm, ok := e.GetModel().GetRoleManager(roleKey)
+		if !ok {
+			return fmt.Errorf("role definition %s not found", roleKey)
+		}
+		get := rm.GetRoles
+		if name == "users" {
+			get = rm.GetUsers
+		}
+		names, err := get(values[0], values[1:]...)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, strings.Join(names, ", "))
+		return nil
+	case "rules":
+		rules := [][]string{}
+		e.GetModel().RangeRules(func(rule []string) bool {
+			rules = append(rules, rule)
+			return true
+		})
+		sortRules(rules)
+		for _, rule := range rules {
+			fmt.Fprintln(w, formatRule(rule))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown command %s, enter help for a list of commands", name)
+}
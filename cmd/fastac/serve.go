@@ -0,0 +1,114 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/abichinger/fastac"
+	"github.com/abichinger/fastac/server"
+	"github.com/abichinger/fastac/str"
+)
+
+// tokenEnv is the environment variable, which holds the bearer token required by requests, which change the enforcer
+const tokenEnv = "FASTAC_TOKEN"
+
+// runServe starts the HTTP authorization server. The server is read-only, unless -write is set.
+// Rule changes are saved to the policy file. If FASTAC_TOKEN is set, changes require the header "Authorization: Bearer <token>".
+// SIGHUP reloads the model file, SIGINT and SIGTERM shut the server down gracefully.
+//
+//  fastac serve -m model.conf -p policy.csv -addr :8080
+//  FASTAC_TOKEN=secret fastac serve -m model.conf -p policy.csv -write
+func runServe(args []string, s *streams) (int, error) {
+	flags := newFlagSet("serve", s.err)
+	pf := &policyFlags{}
+	pf.register(flags)
+	addr := flags.String("addr", ":8080", "listen address")
+	write := flags.Bool("write", false, "enable POST, PUT and DELETE /rules and POST /reload")
+	if err := flags.Parse(args); err != nil {
+		return exitError, err
+	}
+	e, err := pf.enforcer()
+	if err != nil {
+		return exitError, err
+	}
+	if pf.policy != "" {
+		if err := e.SetOption(fastac.OptionAutosave(true)); err != nil {
+			return exitError, err
+		}
+		if err := e.SetOption(fastac.OptionStorage(true)); err != nil {
+			return exitError, err
+		}
+	}
+
+	options := []server.Option{server.OptionModelFile(pf.model), server.OptionReadOnly(!*write)}
+	if token := os.Getenv(tokenEnv); token != "" {
+		options = append(options, server.OptionAuthorize(bearerToken(token)))
+	}
+	handler := server.NewServer(e, options...)
+	srv := &http.Server{Addr: *addr, Handler: handler}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append(reloadSignals, shutdownSignals...)...)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			if isReloadSignal(sig) {
+				if err := handler.Reload(); err != nil {
+					fmt.Fprintf(s.err, "fastac serve: reload failed: %s\n", err)
+				}
+				continue
+			}
+			handler.SetReady(false)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			_ = srv.Shutdown(ctx)
+			cancel()
+			return
+		}
+	}()
+
+	fmt.Fprintf(s.out, "listening on %s\n", *addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return exitError, err
+	}
+	return exitOK, handler.Enforcer().Flush()
+}
+
+func isReloadSignal(sig os.Signal) bool {
+	for _, reload := range reloadSignals {
+		if sig == reload {
+			return true
+		}
+	}
+	return false
+}
+
+// bearerToken returns an authorization function, which accepts requests with the header "Authorization: Bearer <token>"
+func bearerToken(token string) func(r *http.Request) error {
+	expected := []byte("Bearer " + token)
+	return func(r *http.Request) error {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			return errors.New(str.ERR_INVALID_TOKEN)
+		}
+		return nil
+	}
+}
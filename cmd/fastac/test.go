@@ -0,0 +1,63 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/abichinger/fastac"
+)
+
+// runTest runs the policy tests of the test files, the exit code is 1 if a test failed
+//
+//  fastac test -m model.conf -p policy.csv policy_tests.yaml
+func runTest(args []string, s *streams) (int, error) {
+	flags := newFlagSet("test", s.err)
+	pf := &policyFlags{}
+	pf.register(flags)
+	verbose := flags.Bool("v", false, "print passed tests")
+	if err := flags.Parse(args); err != nil {
+		return exitError, err
+	}
+	if flags.NArg() == 0 {
+		return exitError, errors.New("test file is missing")
+	}
+	e, err := pf.enforcer()
+	if err != nil {
+		return exitError, err
+	}
+
+	code := exitOK
+	for _, path := range flags.Args() {
+		report, err := fastac.RunPolicyTests(e, path)
+		if err != nil {
+			return exitError, err
+		}
+		for _, result := range report.Results {
+			if !result.Passed() {
+				fmt.Fprintln(s.out, result.Diff())
+			} else if *verbose {
+				fmt.Fprintf(s.out, "ok   %s\n", result.Test.Name)
+			}
+		}
+		failed := len(report.Failed())
+		fmt.Fprintf(s.out, "%s: %d passed, %d failed\n", path, len(report.Results)-failed, failed)
+		if failed > 0 {
+			code = exitProblems
+		}
+	}
+	return code, nil
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/abichinger/fastac/model/defs"
 	e "github.com/abichinger/fastac/model/effector"
 	m "github.com/abichinger/fastac/model/matcher"
+	"github.com/abichinger/fastac/rbac"
 	"github.com/abichinger/fastac/str"
 )
 
@@ -84,12 +85,30 @@ func SetEffector(effector interface{}) ContextOption {
 	}
 }
 
+// ActivateRoles sets the activated roles of the role definition key for a request.
+// The g function only follows links through the activated roles and the session constraints (dsd) are checked.
+//
+//  e.Enforce("alice", "invoice", "approve", ActivateRoles("g", "approver"))
+func ActivateRoles(key string, roles ...string) ContextOption {
+	return func(ctx *Context) error {
+		if _, ok := ctx.model.GetRoleManager(key); !ok {
+			return fmt.Errorf(str.ERR_RM_NOT_FOUND, key)
+		}
+		if ctx.session == nil {
+			ctx.session = rbac.Session{}
+		}
+		ctx.session[key] = append(ctx.session[key], roles...)
+		return nil
+	}
+}
+
 type Context struct {
 	model model.IModel
 
 	rDef     *defs.RequestDef
 	matcher  m.IMatcher
 	effector e.IEffector
+	session  rbac.Session
 }
 
 func NewContext(model model.IModel, options ...ContextOption) (*Context, error) {
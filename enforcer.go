@@ -16,6 +16,8 @@ package fastac
 
 import (
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	log "github.com/abichinger/fastac/log"
@@ -32,6 +34,11 @@ type Enforcer struct {
 	model   m.IModel
 	adapter storage.Adapter
 	sc      *storage.StorageController
+
+	//mu synchronizes rule changes, e.g. by the reaper, with matching.
+	//Changes made directly through the model are not synchronized.
+	mu     sync.RWMutex
+	reaper *reaper
 }
 
 type Option func(*Enforcer) error
@@ -67,6 +74,25 @@ func OptionStorage(enable bool) Option {
 	}
 }
 
+// OptionFlushErrorPolicy sets how failed adapter operations are handled (default: storage.FlushRollback)
+// With FlushRollback, changes which could not be stored are reverted in the model.
+// With FlushKeep, the changes remain in the model and are sent to the adapter again with the next flush.
+func OptionFlushErrorPolicy(policy storage.FlushErrorPolicy) Option {
+	return func(e *Enforcer) error {
+		e.sc.SetErrorPolicy(policy)
+		return nil
+	}
+}
+
+// OptionRetry sets the number of retries for failed adapter operations (default: 0)
+// The delay between two attempts starts at backoff and is doubled after each attempt.
+func OptionRetry(retries int, backoff time.Duration) Option {
+	return func(e *Enforcer) error {
+		e.sc.SetRetry(retries, backoff)
+		return nil
+	}
+}
+
 // NewEnforcer creates a new Enforcer instance. An Enforcer is the main item of FastAC
 //
 // Without adapter and default options:
@@ -98,10 +124,11 @@ func NewEnforcer(model interface{}, adapter interface{}, options ...Option) (*En
 	var a3 storage.Adapter
 	switch a2 := adapter.(type) {
 	case string:
-		a3 := a.NewFileAdapter(a2)
-		if err := a3.LoadPolicy(e.model); err != nil {
+		fa := a.NewFileAdapter(a2)
+		if err := fa.LoadPolicy(e.model); err != nil {
 			return nil, err
 		}
+		a3 = fa
 	case storage.Adapter:
 		a3 = a2
 	default:
@@ -131,12 +158,17 @@ func (e *Enforcer) GetStorageController() *storage.StorageController {
 
 // SetAdapter sets the storage adapter
 func (e *Enforcer) SetAdapter(adapter storage.Adapter) {
+	old := e.sc
 	autosave := false
-	if e.sc != nil {
-		autosave = e.sc.AutosaveEnabled()
-		e.sc.Disable()
+	if old != nil {
+		autosave = old.AutosaveEnabled()
+		old.Disable()
 	}
 	e.sc = storage.NewStorageController(e.model, adapter, autosave)
+	if old != nil {
+		e.sc.SetErrorPolicy(old.ErrorPolicy())
+		e.sc.SetRetry(old.Retry())
+	}
 	e.adapter = adapter
 }
 
@@ -147,6 +179,9 @@ func (e *Enforcer) GetAdapter() storage.Adapter {
 // LoadPolicy loads all rules from the storage adapter into the model.
 // The model is not cleared before the loading process
 func (e *Enforcer) LoadPolicy() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
 	if e.sc.Enabled() {
 		e.sc.Disable()
 		defer e.sc.Enable()
@@ -156,6 +191,8 @@ func (e *Enforcer) LoadPolicy() error {
 
 //SavePolicy stores all rules from the model into the storage adapter.
 func (e *Enforcer) SavePolicy() error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	return e.adapter.SavePolicy(e.model)
 }
 
@@ -165,18 +202,82 @@ func (e *Enforcer) SavePolicy() error {
 //  e.AddRule("g", "alice", "group1")
 //  e.Flush()
 func (e *Enforcer) Flush() error {
-	return e.sc.Flush()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.flush()
+}
+
+func (e *Enforcer) flush() error {
+	return e.rollback(e.sc.Flush())
+}
+
+// rollback reverts the operations of a failed flush in the model, if the error policy is storage.FlushRollback
+func (e *Enforcer) rollback(err error) error {
+	var fErr *storage.FlushError
+	if !errors.As(err, &fErr) || e.sc.ErrorPolicy() != storage.FlushRollback {
+		return err
+	}
+
+	e.sc.Disable()
+	defer e.sc.Enable()
+	for i := len(fErr.Ops) - 1; i >= 0; i-- {
+		op := fErr.Ops[i]
+		switch op.Opc {
+		case storage.OpAdd:
+			_, _ = e.model.RemoveRule(op.Rule)
+		case storage.OpRemove:
+			_, _ = e.model.AddRule(op.Rule)
+		case storage.OpUpdate:
+			_, _ = e.model.UpdateRule(op.Rule, op.OldRule)
+		case storage.OpSetExpiry:
+			//the expiry is still enforced by the model, the reaper removes the rule from the adapter
+		}
+	}
+	return err
+}
+
+// batch calls fn with autosave disabled and flushes the changes afterwards
+func (e *Enforcer) batch(fn func() error) error {
+	if !e.sc.AutosaveEnabled() {
+		return fn()
+	}
+
+	e.sc.DisableAutosave()
+	err := fn()
+	e.sc.EnableAutosave()
+
+	if fErr := e.flush(); fErr != nil {
+		return fErr
+	}
+	return err
 }
 
 // AddRule adds a rule to the model
 // Returns false, if the rule was already present
+// If autosave is enabled and the adapter fails, a *storage.FlushError is returned
 //
 // Add policy rule:
 //  e.AddRule([]string{"p", "alice", "data1", "read"})
 // Add grouping rule:
 //  e.AddRule([]string{"g", "alice", "group1"})
 func (e *Enforcer) AddRule(rule []string) (bool, error) {
-	return e.model.AddRule(rule)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.addRule(rule)
+}
+
+func (e *Enforcer) addRule(rule []string) (bool, error) {
+	var added bool
+	err := e.batch(func() (err error) {
+		added, err = e.model.AddRule(rule)
+		return err
+	})
+	var fErr *storage.FlushError
+	if errors.As(err, &fErr) {
+		//with FlushKeep the change remains in the model
+		return added && e.sc.ErrorPolicy() == storage.FlushKeep, err
+	}
+	return added, err
 }
 
 // RemoveRule removes a rule from the model
@@ -187,45 +288,100 @@ func (e *Enforcer) AddRule(rule []string) (bool, error) {
 // Add grouping rule:
 //  e.RemoveRule([]string{"g", "alice", "group1"})
 func (e *Enforcer) RemoveRule(rule []string) (bool, error) {
-	return e.model.RemoveRule(rule)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.removeRule(rule)
+}
+
+func (e *Enforcer) removeRule(rule []string) (bool, error) {
+	var removed bool
+	err := e.batch(func() (err error) {
+		removed, err = e.model.RemoveRule(rule)
+		return err
+	})
+	var fErr *storage.FlushError
+	if errors.As(err, &fErr) {
+		//with FlushKeep the change remains in the model
+		return removed && e.sc.ErrorPolicy() == storage.FlushKeep, err
+	}
+	return removed, err
+}
+
+// UpdateRule replaces oldRule with newRule, both rules must belong to the same policy
+// Returns false, if oldRule was not present
+//
+//  e.UpdateRule([]string{"p", "alice", "data1", "read"}, []string{"p", "alice", "data1", "write"})
+func (e *Enforcer) UpdateRule(oldRule, newRule []string) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var updated bool
+	err := e.batch(func() (err error) {
+		updated, err = e.model.UpdateRule(oldRule, newRule)
+		return err
+	})
+	var fErr *storage.FlushError
+	if errors.As(err, &fErr) {
+		//with FlushKeep the change remains in the model
+		return updated && e.sc.ErrorPolicy() == storage.FlushKeep, err
+	}
+	return updated, err
+}
+
+// UpdateRules replaces each rule of oldRules with the rule of newRules at the same index
+func (e *Enforcer) UpdateRules(oldRules, newRules [][]string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.batch(func() error {
+		return e.model.UpdateRules(oldRules, newRules)
+	})
+}
+
+// UpdateFilteredRules replaces all rules, which match the request, with the result of fn.
+// If fn returns nil, the rule is left unchanged. The parameters are handled like the ones of Filter.
+//
+// Move all permissions of alice to bob:
+//  e.UpdateFilteredRules(func(rule []string) []string {
+//  	return []string{rule[0], "bob", rule[2], rule[3]}
+//  }, SetMatcher("p.sub == \"alice\""))
+func (e *Enforcer) UpdateFilteredRules(fn func(rule []string) []string, params ...interface{}) error {
+	ctx, rvals, err := e.splitParams(params...)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.batch(func() error {
+		return e.model.UpdateFilteredRules(ctx.matcher, ctx.rDef, rvals, fn)
+	})
 }
 
 // AddRules adds multiple rules to the model
 func (e *Enforcer) AddRules(rules [][]string) error {
-	if e.sc.AutosaveEnabled() {
-		e.sc.DisableAutosave()
-		defer func() {
-			e.sc.EnableAutosave()
-			if err := e.sc.Flush(); err != nil {
-				panic(err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.batch(func() error {
+		for _, rule := range rules {
+			if _, err := e.model.AddRule(rule); err != nil {
+				return err
 			}
-		}()
-	}
-	for _, rule := range rules {
-		if _, err := e.model.AddRule(rule); err != nil {
-			return err
 		}
-	}
-	return nil
+		return nil
+	})
 }
 
 // RemoveRules removes multiple rules from the model
 func (e *Enforcer) RemoveRules(rules [][]string) error {
-	if e.sc.AutosaveEnabled() {
-		e.sc.DisableAutosave()
-		defer func() {
-			e.sc.EnableAutosave()
-			if err := e.sc.Flush(); err != nil {
-				panic(err)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.batch(func() error {
+		for _, rule := range rules {
+			if _, err := e.model.RemoveRule(rule); err != nil {
+				return err
 			}
-		}()
-	}
-	for _, rule := range rules {
-		if _, err := e.model.RemoveRule(rule); err != nil {
-			return err
 		}
-	}
-	return nil
+		return nil
+	})
 }
 
 func (e *Enforcer) splitParams(params ...interface{}) (ctx *Context, request []interface{}, err error) {
@@ -256,10 +412,28 @@ func (e *Enforcer) Enforce(params ...interface{}) (bool, error) {
 }
 
 func (e *Enforcer) EnforceWithContext(ctx *Context, rvals ...interface{}) (bool, error) {
+	b, _, err := e.EnforceExWithContext(ctx, rvals...)
+	return b, err
+}
+
+// EnforceEx works like Enforce, but returns the rule, which decided the request, as well.
+// The rule is empty, if the decision was not made by a rule, e.g. no rule matched the request.
+//
+//  allow, rule, _ := e.EnforceEx("alice", "data1", "read")
+//  //true [p alice data1 read]
+func (e *Enforcer) EnforceEx(params ...interface{}) (bool, []string, error) {
+	ctx, rvals, err := e.splitParams(params...)
+	if err != nil {
+		return false, nil, err
+	}
+	return e.EnforceExWithContext(ctx, rvals...)
+}
+
+func (e *Enforcer) EnforceExWithContext(ctx *Context, rvals ...interface{}) (bool, []string, error) {
 	start := time.Now()
-	b, err := e.enforce(ctx, rvals)
+	b, rule, err := e.enforce(ctx, rvals)
 	if err != nil {
-		return b, err
+		return b, rule, err
 	}
 	logger := log.Logger().WithField("duration", time.Since(start))
 	if b {
@@ -268,7 +442,7 @@ func (e *Enforcer) EnforceWithContext(ctx *Context, rvals ...interface{}) (bool,
 		logger.Infof("Enforce: %v => deny", rvals)
 	}
 
-	return b, err
+	return b, rule, err
 }
 
 // Filter will fetch all rules which match the given request
@@ -304,16 +478,24 @@ func (e *Enforcer) RangeMatches(params []interface{}, fn func(rule []string) boo
 	return e.RangeMatchesWithContext(ctx, rvals, fn)
 }
 
+// RangeMatchesWithContext calls fn for every rule, which matches the request. Expired rules are skipped, but not removed.
 func (e *Enforcer) RangeMatchesWithContext(ctx *Context, rvals []interface{}, fn func(rule []string) bool) error {
-	return e.model.RangeMatches(ctx.matcher, ctx.rDef, rvals, fn)
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.model.RangeMatchesWithSession(ctx.matcher, ctx.rDef, rvals, ctx.session, fn)
 }
 
-func (e *Enforcer) enforce(ctx *Context, rvals []interface{}) (bool, error) {
+func (e *Enforcer) enforce(ctx *Context, rvals []interface{}) (bool, []string, error) {
+	//matchers, which only reference role definitions, can not decide requests
 	def, _ := e.model.GetDef(m.P_SEC, ctx.matcher.GetPolicyKey())
-	pDef := def.(*defs.PolicyDef)
+	pDef, ok := def.(*defs.PolicyDef)
+	if !ok {
+		return false, []string{}, fmt.Errorf(str.ERR_POLICY_NOT_FOUND, ctx.matcher.GetPolicyKey())
+	}
 	res := eft.Indeterminate
 	effects := []types.Effect{}
 	matches := [][]string{}
+	match := []string{}
 
 	var eftErr error = nil
 	err := e.RangeMatchesWithContext(ctx, rvals, func(rule []string) bool {
@@ -322,7 +504,7 @@ func (e *Enforcer) enforce(ctx *Context, rvals []interface{}) (bool, error) {
 		effects = append(effects, effect)
 		matches = append(matches, rule)
 
-		res, _, eftErr = ctx.effector.MergeEffects(effects, matches, false)
+		res, match, eftErr = ctx.effector.MergeEffects(effects, matches, false)
 
 		if eftErr != nil || res != eft.Indeterminate {
 			return false
@@ -330,17 +512,17 @@ func (e *Enforcer) enforce(ctx *Context, rvals []interface{}) (bool, error) {
 		return true
 	})
 	if err != nil {
-		return false, err
+		return false, []string{}, err
 	}
 	if eftErr != nil {
-		return false, err
+		return false, []string{}, eftErr
 	}
 
 	if res == eft.Indeterminate {
-		res, _, _ = ctx.effector.MergeEffects(effects, matches, true)
+		res, match, _ = ctx.effector.MergeEffects(effects, matches, true)
 	}
 
-	return res == eft.Allow, nil
+	return res == eft.Allow, match, nil
 }
 
 func (e *Enforcer) SetModel(model m.IModel) {
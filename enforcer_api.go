@@ -15,6 +15,8 @@
 package fastac
 
 import (
+	"time"
+
 	"github.com/abichinger/fastac/model"
 	"github.com/abichinger/fastac/storage"
 )
@@ -33,12 +35,27 @@ type IEnforcer interface {
 	AddRules(rules [][]string) error
 	RemoveRule(rule []string) (bool, error)
 	RemoveRules(rules [][]string) error
+	UpdateRule(oldRule, newRule []string) (bool, error)
+	UpdateRules(oldRules, newRules [][]string) error
+	UpdateFilteredRules(fn func(rule []string) []string, params ...interface{}) error
+	Transaction(fn func(tx *Tx) error) error
+	AddRuleWithExpiry(rule []string, expires time.Time) (bool, error)
+	RemoveExpired() error
+	StartReaper(interval time.Duration)
+	StopReaper()
+
+	Snapshot() *model.Snapshot
+	Restore(s *model.Snapshot) error
 
 	LoadPolicy() error
 	SavePolicy() error
+	DiffAdapter() (added, removed [][]string, err error)
+	Sync(direction SyncDirection) error
 
 	Enforce(params ...interface{}) (bool, error)
 	EnforceWithContext(ctx *Context, rvals ...interface{}) (bool, error)
+	EnforceEx(params ...interface{}) (bool, []string, error)
+	EnforceExWithContext(ctx *Context, rvals ...interface{}) (bool, []string, error)
 
 	Filter(params ...interface{}) ([][]string, error)
 	FilterWithContext(ctx *Context, rvals ...interface{}) ([][]string, error)
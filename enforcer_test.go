@@ -1,18 +1,25 @@
 package fastac
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/abichinger/fastac/log"
+	"github.com/abichinger/fastac/model"
 	"github.com/abichinger/fastac/model/defs"
+	"github.com/abichinger/fastac/storage"
 	"github.com/abichinger/fastac/storage/adapter"
 	"github.com/abichinger/fastac/util"
 	"github.com/sirupsen/logrus"
 	logrustest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
+	em "github.com/vansante/go-event-emitter"
 )
 
 func TestAdapterInterface(t *testing.T) {
@@ -251,6 +258,81 @@ func TestEnforce(t *testing.T) {
 	}
 }
 
+func TestEffectorError(t *testing.T) {
+	e, err := NewEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	assert.NoError(t, err)
+
+	allow, err := e.Enforce(SetEffector("unknown"), "alice", "data1", "read")
+	assert.False(t, allow)
+	assert.EqualError(t, err, "unsupported effect")
+}
+
+func TestEnforceEx(t *testing.T) {
+	e, err := NewEnforcer("examples/rbac_with_deny_model.conf", "examples/rbac_with_deny_policy.csv")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		request  []interface{}
+		allow    bool
+		expected []string
+	}{
+		{[]interface{}{"alice", "data1", "read"}, true, []string{"p", "alice", "data1", "read", "allow"}},
+		{[]interface{}{"alice", "data2", "read"}, true, []string{"p", "data2_admin", "data2", "read", "allow"}},
+		{[]interface{}{"alice", "data2", "write"}, false, []string{"p", "alice", "data2", "write", "deny"}},
+		{[]interface{}{"bob", "data1", "read"}, false, []string{}},
+	}
+	for _, test := range tests {
+		allow, rule, err := e.EnforceEx(test.request...)
+		assert.NoError(t, err)
+		assert.Equal(t, test.allow, allow, test.request)
+		assert.Equal(t, test.expected, rule, test.request)
+	}
+}
+
+func TestRunPolicyTests(t *testing.T) {
+	e, err := NewEnforcer("examples/rbac_with_deny_model.conf", "examples/rbac_with_deny_policy.csv")
+	assert.NoError(t, err)
+
+	report, err := RunPolicyTests(e, "examples/rbac_with_deny_tests.yaml")
+	assert.NoError(t, err)
+	assert.Len(t, report.Results, 4)
+	assert.Empty(t, report.Failed())
+	assert.Equal(t, "4 passed, 0 failed", report.String())
+
+	dir, err := ioutil.TempDir("", "fastac")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := dir + "/tests.yaml"
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`
+tests:
+  - name: alice writes data2
+    request: alice, data2, write
+    expect: allow
+    rule: p, data2_admin, data2, write, allow
+  - request: bob, data2, write
+    expect: allow
+    rule: p, bob, data2, write
+  - request: [bob, data1, read]
+    expect: deny
+`), 0644))
+
+	report, err = RunPolicyTests(e, path)
+	assert.NoError(t, err)
+	assert.Equal(t, `FAIL alice writes data2 (alice, data2, write)
+- allow
++ deny
+- rule: p, data2_admin, data2, write, allow
++ rule: p, alice, data2, write, deny
+FAIL #2 (bob, data2, write)
+- rule: p, bob, data2, write
++ rule: p, bob, data2, write, allow
+1 passed, 2 failed`, report.String())
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("tests:\n  - request: alice, data1, read\n    expect: maybe\n"), 0644))
+	_, err = RunPolicyTests(e, path)
+	assert.EqualError(t, err, "error: invalid expectation maybe of test #1, expected allow or deny")
+}
+
 func TestFilter(t *testing.T) {
 
 	mDef := defs.NewMatcherDef("m5", "p.act == r5.action")
@@ -386,3 +468,496 @@ func TestEnforceLog(t *testing.T) {
 
 	t.Log(logMsg)
 }
+
+type failingAdapter struct {
+	adapter.NoopAdapter
+	fail bool
+}
+
+func (a *failingAdapter) AddRule(rule []string) error {
+	if a.fail {
+		return errors.New("adapter unavailable")
+	}
+	return nil
+}
+
+func (a *failingAdapter) RemoveRule(rule []string) error {
+	return a.AddRule(rule)
+}
+
+func getRules(e *Enforcer) []string {
+	rules := [][]string{}
+	e.GetModel().RangeRules(func(rule []string) bool {
+		rules = append(rules, rule)
+		return true
+	})
+	return util.Join2D(rules, ",")
+}
+
+func TestFlushError(t *testing.T) {
+	tests := []struct {
+		policy   storage.FlushErrorPolicy
+		modified bool
+		expected []string
+		pending  int
+	}{
+		{storage.FlushRollback, false, []string{"p,alice,data1,read", "p,bob,data2,write"}, 0},
+		{storage.FlushKeep, true, []string{"p,alice,data1,read", "p,carol,data1,read", "p,dave,data1,read", "p,eve,data1,read"}, 4},
+	}
+
+	for _, test := range tests {
+		a := &failingAdapter{}
+		e, err := NewEnforcer("examples/basic_model.conf", a, OptionAutosave(true), OptionFlushErrorPolicy(test.policy))
+		assert.NoError(t, err)
+		_ = e.AddRules([][]string{{"p", "alice", "data1", "read"}, {"p", "bob", "data2", "write"}})
+
+		a.fail = true
+		added, err := e.AddRule([]string{"p", "carol", "data1", "read"})
+		assert.Equal(t, test.modified, added)
+		assert.Error(t, err)
+
+		removed, err := e.RemoveRule([]string{"p", "bob", "data2", "write"})
+		assert.Equal(t, test.modified, removed)
+		assert.Error(t, err)
+
+		err = e.AddRules([][]string{{"p", "dave", "data1", "read"}, {"p", "eve", "data1", "read"}})
+		var fErr *storage.FlushError
+		assert.True(t, errors.As(err, &fErr))
+
+		assert.ElementsMatch(t, test.expected, getRules(e))
+		assert.Len(t, e.GetStorageController().Pending(), test.pending)
+
+		a.fail = false
+		assert.NoError(t, e.Flush())
+		assert.ElementsMatch(t, test.expected, getRules(e))
+	}
+}
+
+func TestFlushErrorNotSticky(t *testing.T) {
+	a := &failingAdapter{}
+	e, err := NewEnforcer("examples/basic_model.conf", a, OptionAutosave(true))
+	assert.NoError(t, err)
+
+	//the failed autosave of a direct model change must not be returned by later calls
+	a.fail = true
+	_, _ = e.GetModel().AddRule([]string{"p", "alice", "data1", "read"})
+	a.fail = false
+	added, err := e.AddRule([]string{"p", "bob", "data1", "read"})
+	assert.True(t, added)
+	assert.NoError(t, err)
+
+	a.fail = true
+	removed, err := e.RemoveRule([]string{"p", "bob", "data1", "read"})
+	assert.False(t, removed)
+	assert.Error(t, err)
+	a.fail = false
+	updated, err := e.UpdateRule([]string{"p", "bob", "data1", "read"}, []string{"p", "bob", "data1", "write"})
+	assert.True(t, updated)
+	assert.NoError(t, err)
+}
+
+type txAdapter struct {
+	adapter.NoopAdapter
+	fail    bool
+	added   [][]string
+	removed [][]string
+}
+
+func (a *txAdapter) ApplyChanges(added, removed [][]string) error {
+	if a.fail {
+		return errors.New("adapter unavailable")
+	}
+	a.added = append(a.added, added...)
+	a.removed = append(a.removed, removed...)
+	return nil
+}
+
+func TestTransaction(t *testing.T) {
+	initial := []string{"p,alice,data1,read", "p,bob,data2,write"}
+	txErr := errors.New("abort")
+
+	tests := []struct {
+		name     string
+		fail     bool
+		fnErr    error
+		expected []string
+		added    []string
+		removed  []string
+	}{
+		{"commit", false, nil, []string{"p,alice,data1,read", "p,carol,data1,read"}, []string{"p,carol,data1,read"}, []string{"p,bob,data2,write"}},
+		{"callback error", false, txErr, initial, []string{}, []string{}},
+		{"adapter error", true, nil, initial, []string{}, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := &txAdapter{}
+			e, err := NewEnforcer("examples/basic_model.conf", a, OptionAutosave(true))
+			assert.NoError(t, err)
+			assert.NoError(t, e.AddRules([][]string{{"p", "alice", "data1", "read"}, {"p", "bob", "data2", "write"}}))
+
+			events := []string{}
+			for _, evt := range []em.EventType{model.RULE_ADDED, model.RULE_REMOVED} {
+				evt := evt
+				e.GetModel().AddListener(evt, func(arguments ...interface{}) {
+					events = append(events, string(evt)+":"+strings.Join(arguments[0].([]string), ","))
+				})
+			}
+
+			a.fail = test.fail
+			err = e.Transaction(func(tx *Tx) error {
+				_, _ = tx.AddRule([]string{"p", "dave", "data1", "read"})
+				_, _ = tx.RemoveRule([]string{"p", "bob", "data2", "write"})
+				_ = tx.AddRules([][]string{{"p", "carol", "data1", "read"}})
+				_, _ = tx.RemoveRule([]string{"p", "dave", "data1", "read"})
+
+				allow, _ := e.Enforce("carol", "data1", "read")
+				assert.True(t, allow)
+				return test.fnErr
+			})
+
+			assert.ElementsMatch(t, test.expected, getRules(e))
+			assert.ElementsMatch(t, test.added, util.Join2D(a.added, ","))
+			assert.ElementsMatch(t, test.removed, util.Join2D(a.removed, ","))
+
+			if test.fnErr != nil || test.fail {
+				assert.Error(t, err)
+				assert.Empty(t, events)
+				allow, _ := e.Enforce("carol", "data1", "read")
+				assert.False(t, allow)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"rule_removed:p,bob,data2,write", "rule_added:p,carol,data1,read"}, events)
+			assert.Empty(t, e.GetStorageController().Pending())
+		})
+	}
+}
+
+func TestTransactionUpdateRule(t *testing.T) {
+	e, err := NewEnforcer("examples/rbac_model.conf", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, e.AddRules([][]string{
+		{"p", "alice", "data1", "read"},
+		{"p", "alice", "data1", "write"},
+		{"g", "alice", "admin"},
+		{"g", "alice", "user"},
+	}))
+	expected := getRules(e)
+
+	err = e.Transaction(func(tx *Tx) error {
+		//the new rules are already present
+		updated, err := tx.UpdateRule([]string{"p", "alice", "data1", "read"}, []string{"p", "alice", "data1", "write"})
+		assert.False(t, updated)
+		assert.NoError(t, err)
+		updated, err = tx.UpdateRule([]string{"g", "alice", "admin"}, []string{"g", "alice", "user"})
+		assert.False(t, updated)
+		return err
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, expected, getRules(e))
+
+	err = e.Transaction(func(tx *Tx) error {
+		updated, err := tx.UpdateRule([]string{"g", "alice", "admin"}, []string{"g", "alice", "guest"})
+		assert.True(t, updated)
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, getRules(e), "g,alice,guest")
+	assert.NotContains(t, getRules(e), "g,alice,admin")
+}
+
+func TestTransactionWithoutAutosave(t *testing.T) {
+	e, err := NewEnforcer("examples/basic_model.conf", &txAdapter{})
+	assert.NoError(t, err)
+
+	err = e.Transaction(func(tx *Tx) error {
+		return tx.AddRules([][]string{{"p", "alice", "data1", "read"}, {"p", "bob", "data2", "write"}})
+	})
+	assert.NoError(t, err)
+	assert.Len(t, e.GetStorageController().Pending(), 2)
+}
+
+type updateAdapter struct {
+	adapter.NoopAdapter
+	updates []string
+}
+
+func (a *updateAdapter) UpdateRule(oldRule, newRule []string) error {
+	return a.UpdateRules([][]string{oldRule}, [][]string{newRule})
+}
+
+func (a *updateAdapter) UpdateRules(oldRules, newRules [][]string) error {
+	for i := range oldRules {
+		a.updates = append(a.updates, strings.Join(oldRules[i], ",")+"->"+strings.Join(newRules[i], ","))
+	}
+	return nil
+}
+
+func TestUpdateRule(t *testing.T) {
+	a := &updateAdapter{}
+	e, err := NewEnforcer("examples/rbac_model.conf", a, OptionAutosave(true))
+	assert.NoError(t, err)
+	assert.NoError(t, e.AddRules([][]string{
+		{"p", "alice", "data1", "read"},
+		{"p", "data2_admin", "data2", "read"},
+		{"p", "data2_admin", "data2", "write"},
+		{"g", "alice", "data2_admin"},
+	}))
+
+	updated, err := e.UpdateRule([]string{"p", "alice", "data1", "read"}, []string{"p", "alice", "data1", "write"})
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	updated, err = e.UpdateRule([]string{"p", "alice", "data1", "read"}, []string{"p", "alice", "data1", "write"})
+	assert.NoError(t, err)
+	assert.False(t, updated)
+	_, err = e.UpdateRule([]string{"p", "alice", "data1", "write"}, []string{"g", "alice", "data1"})
+	assert.Error(t, err)
+
+	updated, err = e.UpdateRule([]string{"g", "alice", "data2_admin"}, []string{"g", "bob", "data2_admin"})
+	assert.NoError(t, err)
+	assert.True(t, updated)
+
+	assert.NoError(t, e.UpdateFilteredRules(func(rule []string) []string {
+		return []string{rule[0], rule[1], "data3", rule[3]}
+	}, SetMatcher("p.obj == \"data2\"")))
+	assert.Error(t, e.UpdateRules([][]string{{"p", "alice", "data1", "write"}}, [][]string{}))
+
+	allow, _ := e.Enforce("alice", "data1", "write")
+	assert.True(t, allow)
+	allow, _ = e.Enforce("alice", "data3", "read")
+	assert.False(t, allow)
+	allow, _ = e.Enforce("bob", "data3", "write")
+	assert.True(t, allow)
+
+	assert.ElementsMatch(t, []string{
+		"p,alice,data1,read->p,alice,data1,write",
+		"g,alice,data2_admin->g,bob,data2_admin",
+		"p,data2_admin,data2,read->p,data2_admin,data3,read",
+		"p,data2_admin,data2,write->p,data2_admin,data3,write",
+	}, a.updates)
+}
+
+func TestRestore(t *testing.T) {
+	a := &txAdapter{}
+	e, err := NewEnforcer("examples/rbac_model.conf", a, OptionAutosave(true))
+	assert.NoError(t, err)
+	assert.NoError(t, e.AddRules([][]string{{"p", "admin", "data1", "read"}, {"g", "alice", "admin"}}))
+	s := e.Snapshot()
+
+	_, _ = e.RemoveRule([]string{"g", "alice", "admin"})
+	_, _ = e.AddRule([]string{"g", "mallory", "admin"})
+	_, _ = e.AddRule([]string{"p", "mallory", "data2", "write"})
+
+	//storing the change set fails, the model remains unchanged
+	a.fail = true
+	assert.Error(t, e.Restore(s))
+	allow, _ := e.Enforce("mallory", "data1", "read")
+	assert.True(t, allow)
+
+	a.fail = false
+	a.added, a.removed = nil, nil
+	assert.NoError(t, e.Restore(s))
+	assert.ElementsMatch(t, []string{"p,admin,data1,read", "g,alice,admin"}, getRules(e))
+	assert.Equal(t, []string{"g,alice,admin"}, util.Join2D(a.added, ","))
+	assert.Equal(t, []string{"g,mallory,admin", "p,mallory,data2,write"}, util.Join2D(a.removed, ","))
+
+	allow, _ = e.Enforce("alice", "data1", "read")
+	assert.True(t, allow)
+	allow, _ = e.Enforce("mallory", "data1", "read")
+	assert.False(t, allow)
+}
+
+func TestSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastac")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := dir + "/policy.csv"
+	assert.NoError(t, ioutil.WriteFile(path, []byte("p, alice, data1, read\ng, bob, admin\n"), 0644))
+	fa := adapter.NewFileAdapter(path)
+	stored := func() []string {
+		rs := adapter.NewRuleSet()
+		assert.NoError(t, fa.LoadPolicy(rs))
+		return util.Join2D(rs.Rules(), ",")
+	}
+
+	e, err := NewEnforcer("examples/rbac_model.conf", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, e.AddRules([][]string{{"p", "alice", "data1", "read"}, {"p", "admin", "data2", "write"}}))
+	e.SetAdapter(fa)
+
+	added, removed, err := e.DiffAdapter()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"g,bob,admin"}, util.Join2D(added, ","))
+	assert.Equal(t, []string{"p,admin,data2,write"}, util.Join2D(removed, ","))
+
+	assert.NoError(t, e.Sync(SyncFromAdapter))
+	assert.ElementsMatch(t, []string{"p,alice,data1,read", "g,bob,admin"}, getRules(e))
+	assert.ElementsMatch(t, []string{"p,alice,data1,read", "g,bob,admin"}, stored())
+	assert.Empty(t, e.GetStorageController().Pending())
+
+	_, _ = e.AddRule([]string{"p", "admin", "data2", "write"})
+	_, _ = e.RemoveRule([]string{"p", "alice", "data1", "read"})
+	assert.NoError(t, e.Sync(SyncToAdapter))
+	assert.ElementsMatch(t, []string{"p,admin,data2,write", "g,bob,admin"}, stored())
+	assert.Empty(t, e.GetStorageController().Pending())
+
+	added, removed, err = e.DiffAdapter()
+	assert.NoError(t, err)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+
+	assert.Error(t, e.Sync(SyncDirection(2)))
+}
+
+func TestRuleExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastac")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := dir + "/policy.csv"
+	assert.NoError(t, ioutil.WriteFile(path, []byte("p, admin, data1, read\n"), 0644))
+
+	e, err := NewEnforcer("examples/rbac_model.conf", path, OptionAutosave(true))
+	assert.NoError(t, err)
+
+	_, err = e.AddRuleWithExpiry([]string{"g", "bob", "admin"}, time.Now().Add(-time.Second))
+	assert.Error(t, err)
+	added, err := e.AddRuleWithExpiry([]string{"g", "bob", "admin"}, time.Now().Add(20*time.Millisecond))
+	assert.NoError(t, err)
+	assert.True(t, added)
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	_, err = e.AddRuleWithExpiry([]string{"g", "alice", "admin"}, expires)
+	assert.NoError(t, err)
+
+	//the expiry is stored with the rule
+	e2, err := NewEnforcer("examples/rbac_model.conf", path)
+	assert.NoError(t, err)
+	at, ok := e2.GetModel().GetExpiry([]string{"g", "alice", "admin"})
+	assert.True(t, ok)
+	assert.True(t, expires.Equal(at))
+
+	//expired rules are not matched, but only removed by the reaper
+	time.Sleep(30 * time.Millisecond)
+	allow, _ := e.Enforce("bob", "data1", "read")
+	assert.False(t, allow)
+	allow, _ = e.Enforce("alice", "data1", "read")
+	assert.True(t, allow)
+	assert.Contains(t, getRules(e), "g,bob,admin")
+
+	//the expiry is moved to the updated rule
+	_, err = e.UpdateRule([]string{"g", "alice", "admin"}, []string{"g", "carol", "admin"})
+	assert.NoError(t, err)
+	_, ok = e.GetModel().GetExpiry([]string{"g", "alice", "admin"})
+	assert.False(t, ok)
+	at, _ = e.GetModel().GetExpiry([]string{"g", "carol", "admin"})
+	assert.True(t, expires.Equal(at))
+
+	e.StartReaper(5 * time.Millisecond)
+	defer e.StopReaper()
+	assert.Eventually(t, func() bool {
+		data, _ := ioutil.ReadFile(path)
+		return !strings.Contains(string(data), "bob")
+	}, time.Second, 5*time.Millisecond)
+
+	e.StopReaper()
+	assert.ElementsMatch(t, []string{"p,admin,data1,read", "g,carol,admin"}, getRules(e))
+	data, _ := ioutil.ReadFile(path)
+	assert.Contains(t, string(data), "#expires, "+expires.UTC().Format(time.RFC3339)+", g, carol, admin")
+}
+
+func TestRuleExpiryWithoutAutosave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastac")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := dir + "/policy.csv"
+	assert.NoError(t, ioutil.WriteFile(path, []byte("p, admin, data1, read\n"), 0644))
+
+	e, err := NewEnforcer("examples/rbac_model.conf", path, OptionAutosave(false))
+	assert.NoError(t, err)
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	//the expiry is queued with the rule and discarded with it
+	_, err = e.AddRuleWithExpiry([]string{"g", "bob", "admin"}, expires)
+	assert.NoError(t, err)
+	data, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "p, admin, data1, read\n", string(data))
+	assert.Equal(t, []storage.Operation{
+		{Opc: storage.OpAdd, Rule: []string{"g", "bob", "admin"}},
+		{Opc: storage.OpSetExpiry, Rule: []string{"g", "bob", "admin"}, Expires: expires},
+	}, e.sc.Pending())
+	e.sc.Discard()
+
+	_, err = e.AddRuleWithExpiry([]string{"g", "alice", "admin"}, expires)
+	assert.NoError(t, err)
+	assert.NoError(t, e.Flush())
+	data, _ = ioutil.ReadFile(path)
+	assert.NotContains(t, string(data), "bob")
+
+	e2, err := NewEnforcer("examples/rbac_model.conf", path)
+	assert.NoError(t, err)
+	at, ok := e2.GetModel().GetExpiry([]string{"g", "alice", "admin"})
+	assert.True(t, ok)
+	assert.True(t, expires.Equal(at))
+}
+
+func TestReaperConcurrentChanges(t *testing.T) {
+	e, err := NewEnforcer("examples/rbac_model.conf", &failingAdapter{}, OptionAutosave(true))
+	assert.NoError(t, err)
+	e.StartReaper(time.Millisecond)
+	defer e.StopReaper()
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			_, err := e.AddRuleWithExpiry([]string{"g", fmt.Sprintf("user%d", i), "admin"}, time.Now().Add(time.Millisecond))
+			assert.NoError(t, err)
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			rule := []string{"p", fmt.Sprintf("user%d", i), "data1", "read"}
+			_, _ = e.AddRule(rule)
+			_, _ = e.Enforce(fmt.Sprintf("user%d", i), "data1", "read")
+			_, _ = e.UpdateRule(rule, []string{"p", fmt.Sprintf("user%d", i), "data1", "write"})
+			_ = e.Transaction(func(tx *Tx) error {
+				_, err := tx.AddRule([]string{"p", fmt.Sprintf("user%d", i), "data2", "read"})
+				return err
+			})
+		}
+	}()
+	wg.Wait()
+
+	//all expired links are removed, the other rules are kept
+	assert.Eventually(t, func() bool {
+		return e.Snapshot().Len() == 100
+	}, time.Second, time.Millisecond)
+}
+
+func TestExpiredRulesReadOnly(t *testing.T) {
+	a := &failingAdapter{}
+	e, err := NewEnforcer("examples/rbac_model.conf", a, OptionAutosave(true))
+	assert.NoError(t, err)
+	assert.NoError(t, e.AddRules([][]string{{"p", "admin", "data1", "read"}, {"p", "bob", "data2", "read"}}))
+	_, err = e.AddRuleWithExpiry([]string{"g", "alice", "admin"}, time.Now().Add(10*time.Millisecond))
+	assert.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+
+	//matching does not remove expired rules, so a failing adapter does not affect decisions
+	a.fail = true
+	allow, err := e.Enforce("bob", "data2", "read")
+	assert.NoError(t, err)
+	assert.True(t, allow)
+	allow, err = e.Enforce("alice", "data1", "read")
+	assert.NoError(t, err)
+	assert.False(t, allow)
+
+	assert.Error(t, e.RemoveExpired())
+	assert.Contains(t, getRules(e), "g,alice,admin")
+}
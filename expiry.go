@@ -0,0 +1,143 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fastac
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/abichinger/fastac/storage"
+	"github.com/abichinger/fastac/str"
+)
+
+type reaper struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OptionReaper starts a background reaper, which removes expired rules every interval
+func OptionReaper(interval time.Duration) Option {
+	return func(e *Enforcer) error {
+		e.StartReaper(interval)
+		return nil
+	}
+}
+
+// AddRuleWithExpiry adds a rule, which is removed automatically after the expiry time.
+// If the rule is already present, only the expiry time is updated.
+// Expired rules are not matched anymore, even if the reaper did not remove them yet.
+// Adapters implementing storage.ExpiryAdapter store the expiry time, otherwise it is lost after reloading the rules.
+// With autosave disabled, the expiry time is stored by the Flush, which stores the rule.
+//
+// Grant temporary access:
+//  e.AddRuleWithExpiry([]string{"g", "alice", "oncall"}, time.Now().Add(8*time.Hour))
+func (e *Enforcer) AddRuleWithExpiry(rule []string, expires time.Time) (bool, error) {
+	if !expires.After(time.Now()) {
+		return false, fmt.Errorf(str.ERR_EXPIRY_IN_PAST, expires.Format(time.RFC3339))
+	}
+
+	//the reaper must not remove rules, while they are added
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var added bool
+	err := e.batch(func() (err error) {
+		if added, err = e.model.AddRule(rule); err != nil {
+			return err
+		}
+		if err = e.model.SetExpiry(rule, expires); err != nil {
+			return err
+		}
+		//the expiry is queued behind the rule, so it is not stored before the rule
+		e.sc.SetExpiry(rule, expires)
+		return nil
+	})
+	var fErr *storage.FlushError
+	if !errors.As(err, &fErr) {
+		return added, err
+	}
+	if e.sc.ErrorPolicy() == storage.FlushKeep {
+		return added, err
+	}
+	for _, op := range fErr.Ops {
+		//a rule without stored expiry would become permanent after reloading
+		if op.Opc == storage.OpSetExpiry && added {
+			_, _ = e.removeRule(rule)
+			break
+		}
+	}
+	return false, err
+}
+
+// RemoveExpired removes all expired rules from the model.
+// The removals are sent to the storage adapter like any other change.
+// If the adapter fails and the rules are restored, they are removed again with the next call.
+func (e *Enforcer) RemoveExpired() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	if next, ok := e.model.NextExpiry(); !ok || next.After(now) {
+		return nil
+	}
+
+	var removed [][]string
+	err := e.batch(func() error {
+		var err error
+		removed, err = e.model.RemoveExpired(now)
+		return err
+	})
+	if err != nil {
+		for _, rule := range removed {
+			_ = e.model.SetExpiry(rule, now)
+		}
+	}
+	return err
+}
+
+// StartReaper starts a goroutine, which calls RemoveExpired every interval.
+// A running reaper is stopped first. Rule changes made through the Enforcer wait for the reaper,
+// changes made directly through the model are not synchronized with it.
+func (e *Enforcer) StartReaper(interval time.Duration) {
+	e.StopReaper()
+
+	r := &reaper{make(chan struct{}), make(chan struct{})}
+	e.reaper = r
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				//failed removals are reported by the StorageController and retried with the next tick
+				_ = e.RemoveExpired()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopReaper stops the background reaper and waits until it has finished
+func (e *Enforcer) StopReaper() {
+	if e.reaper == nil {
+		return
+	}
+	close(e.reaper.stop)
+	<-e.reaper.done
+	e.reaper = nil
+}
@@ -0,0 +1,239 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lint finds policy rules, which can never change a decision.
+//
+// Every rule is turned into a representative request by assigning the rule values to the request arguments of the same name,
+// e.g. the rule "p, alice, data/*, read" becomes the request "alice, data/*, read".
+// A rule covers another rule, if it matches the representative request of the other rule.
+// Rules, which do not match their own representative request, are not analysed.
+//
+//  findings, _ := lint.Lint(e.GetModel())
+//  for _, f := range findings {
+//  	fmt.Println(f)
+//  }
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/abichinger/fastac/model"
+	"github.com/abichinger/fastac/model/defs"
+	"github.com/abichinger/fastac/model/eft"
+	"github.com/abichinger/fastac/model/types"
+	"github.com/abichinger/fastac/str"
+	"github.com/abichinger/fastac/util"
+)
+
+type Kind string
+
+const (
+	// KindDuplicate is a rule, which is granted again through the roles of its subject
+	KindDuplicate Kind = "duplicate"
+	// KindShadowed is a rule, which is covered by a broader rule with the same effect
+	KindShadowed Kind = "shadowed"
+	// KindOverridden is an allow rule, which is covered by a deny rule
+	KindOverridden Kind = "overridden"
+	// KindUnusedDeny is a deny rule, which does not overlap any allow rule
+	KindUnusedDeny Kind = "unused_deny"
+	// KindNoEffect is a rule, which is ignored by the policy effect
+	KindNoEffect Kind = "no_effect"
+)
+
+// Finding references a rule, which can never change a decision
+type Finding struct {
+	Kind Kind     `json:"kind"`
+	Rule []string `json:"rule"`
+	// By is the rule, which covers Rule
+	By []string `json:"by,omitempty"`
+}
+
+func (f *Finding) String() string {
+	rule := strings.Join(f.Rule, ", ")
+	by := strings.Join(f.By, ", ")
+	switch f.Kind {
+	case KindDuplicate:
+		return fmt.Sprintf("%s: duplicates %s through roles", rule, by)
+	case KindShadowed:
+		return fmt.Sprintf("%s: shadowed by %s", rule, by)
+	case KindOverridden:
+		return fmt.Sprintf("%s: overridden by %s", rule, by)
+	case KindUnusedDeny:
+		return fmt.Sprintf("%s: deny rule does not overlap any allow rule", rule)
+	}
+	return fmt.Sprintf("%s: ignored by the policy effect", rule)
+}
+
+type linter struct {
+	requestKey string
+	matcherKey string
+	effectKey  string
+}
+
+type Option func(*linter)
+
+// OptionKeys sets the keys of the request definition, matcher and policy effect (default: r, m, e)
+func OptionKeys(requestKey, matcherKey, effectKey string) Option {
+	return func(l *linter) {
+		l.requestKey = requestKey
+		l.matcherKey = matcherKey
+		l.effectKey = effectKey
+	}
+}
+
+type lintRule struct {
+	rule    []string
+	effect  types.Effect
+	request []interface{}
+	// covered contains the indices of the rules, which match the representative request
+	covered map[int]bool
+}
+
+// Lint returns the findings sorted by rule, every rule is reported at most once
+func Lint(m model.IModel, options ...Option) ([]*Finding, error) {
+	l := &linter{requestKey: "r", matcherKey: "m", effectKey: "e"}
+	for _, option := range options {
+		option(l)
+	}
+
+	rDef, ok := m.GetRequestDef(l.requestKey)
+	if !ok {
+		return nil, fmt.Errorf(str.ERR_REQUESTDEF_NOT_FOUND, l.requestKey)
+	}
+	matcher, ok := m.GetMatcher(l.matcherKey)
+	if !ok {
+		return nil, fmt.Errorf(str.ERR_MATCHER_NOT_FOUND, l.matcherKey)
+	}
+	def, _ := m.GetDef(model.P_SEC, matcher.GetPolicyKey())
+	pDef, ok := def.(*defs.PolicyDef)
+	if !ok {
+		return nil, fmt.Errorf(str.ERR_POLICY_NOT_FOUND, matcher.GetPolicyKey())
+	}
+	def, _ = m.GetDef(model.E_SEC, l.effectKey)
+	eDef, ok := def.(*defs.EffectDef)
+	if !ok {
+		return nil, fmt.Errorf(str.ERR_EFFECTOR_NOT_FOUND, l.effectKey)
+	}
+
+	rules := []*lintRule{}
+	p, _ := m.GetPolicy(pDef.GetKey())
+	p.Range(func(rule []string) bool {
+		rule = append([]string{pDef.GetKey()}, rule...)
+		rules = append(rules, &lintRule{
+			rule:    rule,
+			effect:  pDef.GetEft(rule),
+			request: representative(rDef, pDef, rule),
+			covered: map[int]bool{},
+		})
+		return true
+	})
+	sort.Slice(rules, func(i, j int) bool {
+		return util.Hash(rules[i].rule) < util.Hash(rules[j].rule)
+	})
+	index := map[string]int{}
+	for i, r := range rules {
+		index[util.Hash(r.rule)] = i
+	}
+
+	for _, r := range rules {
+		if r.request == nil {
+			continue
+		}
+		err := m.RangeMatches(matcher, rDef, r.request, func(rule []string) bool {
+			if i, ok := index[util.Hash(rule)]; ok {
+				r.covered[i] = true
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	findings := []*Finding{}
+	for i := range rules {
+		if f := l.check(eDef.Expr(), rules, i); f != nil {
+			findings = append(findings, f)
+		}
+	}
+	return findings, nil
+}
+
+// check returns the finding of rules[i] or nil
+func (l *linter) check(effect string, rules []*lintRule, i int) *Finding {
+	r := rules[i]
+	switch {
+	case effect == eft.SOME_ALLOW && r.effect == eft.Deny,
+		effect == eft.NO_DENY && r.effect == eft.Allow:
+		return &Finding{Kind: KindNoEffect, Rule: r.rule}
+	}
+	if !r.covered[i] {
+		return nil
+	}
+
+	if effect == eft.SOME_ALLOW_NO_DENY && r.effect == eft.Allow {
+		for _, j := range sortedKeys(r.covered) {
+			if rules[j].effect == eft.Deny {
+				return &Finding{Kind: KindOverridden, Rule: r.rule, By: rules[j].rule}
+			}
+		}
+	}
+
+	for _, j := range sortedKeys(r.covered) {
+		other := rules[j]
+		// other has to be strictly broader
+		if j == i || other.effect != r.effect || other.covered[i] {
+			continue
+		}
+		kind := KindShadowed
+		if util.Hash(r.rule[2:]) == util.Hash(other.rule[2:]) {
+			kind = KindDuplicate
+		}
+		return &Finding{Kind: kind, Rule: r.rule, By: other.rule}
+	}
+
+	if effect == eft.SOME_ALLOW_NO_DENY && r.effect == eft.Deny {
+		for j, other := range rules {
+			if other.effect == eft.Allow && (r.covered[j] || other.covered[i]) {
+				return nil
+			}
+		}
+		return &Finding{Kind: KindUnusedDeny, Rule: r.rule}
+	}
+	return nil
+}
+
+// representative returns the request, which contains the values of rule or nil, if a request argument is not part of the policy definition
+func representative(rDef *defs.RequestDef, pDef *defs.PolicyDef, rule []string) []interface{} {
+	request := []interface{}{}
+	for _, arg := range rDef.GetArgs() {
+		value, err := pDef.GetParameter(rule, pDef.GetKey()+"_"+arg)
+		if err != nil {
+			return nil
+		}
+		request = append(request, value)
+	}
+	return request
+}
+
+func sortedKeys(set map[int]bool) []int {
+	keys := make([]int, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lint
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/abichinger/fastac/model"
+	"github.com/stretchr/testify/assert"
+)
+
+const lintModel = `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act, eft
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = %s
+
+[matchers]
+m = g(r.sub, p.sub) && pathMatch(r.obj, p.obj) && r.act == p.act
+`
+
+func newLintModel(t *testing.T, effect string, rules [][]string) *model.Model {
+	m := model.NewModel()
+	assert.NoError(t, m.LoadModelFromText(strings.Replace(lintModel, "%s", effect, 1)))
+	for _, rule := range rules {
+		_, err := m.AddRule(rule)
+		assert.NoError(t, err)
+	}
+	return m
+}
+
+func TestLint(t *testing.T) {
+	m := newLintModel(t, "some(where (p.eft == allow)) && !some(where (p.eft == deny))", [][]string{
+		{"p", "admin", "data1", "read", "allow"},
+		{"p", "alice", "data1", "read", "allow"},
+		{"p", "bob", "/files/*", "read", "allow"},
+		{"p", "bob", "/files/a", "read", "allow"},
+		{"p", "bob", "/files/secret", "read", "deny"},
+		{"p", "carol", "/docs/a", "read", "allow"},
+		{"p", "carol", "/docs/*", "read", "deny"},
+		{"p", "dave", "/tmp/*", "write", "deny"},
+		{"g", "alice", "admin"},
+	})
+
+	findings, err := Lint(m)
+	assert.NoError(t, err)
+	res := []string{}
+	for _, f := range findings {
+		res = append(res, f.String())
+	}
+	assert.Equal(t, []string{
+		"p, alice, data1, read, allow: duplicates p, admin, data1, read, allow through roles",
+		"p, bob, /files/a, read, allow: shadowed by p, bob, /files/*, read, allow",
+		"p, carol, /docs/a, read, allow: overridden by p, carol, /docs/*, read, deny",
+		"p, dave, /tmp/*, write, deny: deny rule does not overlap any allow rule",
+	}, res)
+	assert.Equal(t, KindDuplicate, findings[0].Kind)
+
+	m = newLintModel(t, "some(where (p.eft == allow))", [][]string{
+		{"p", "alice", "data1", "read", "allow"},
+		{"p", "alice", "data1", "read", "deny"},
+	})
+	findings, err = Lint(m)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Finding{{Kind: KindNoEffect, Rule: []string{"p", "alice", "data1", "read", "deny"}}}, findings)
+
+	_, err = Lint(m, OptionKeys("r", "m2", "e"))
+	assert.EqualError(t, err, "error: matcher m2 not found")
+}
@@ -0,0 +1,352 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mining proposes roles for users with identical or similar permissions.
+// A permission is a policy rule without its subject, e.g. the rule "p, alice, data1, read" grants alice the permission "data1, read".
+//
+//  proposal, _ := mining.Mine(e.GetModel(), mining.OptionSimilarity(0.8))
+//  if err := mining.Verify(e, proposal); err == nil {
+//  	_ = mining.Apply(e, proposal)
+//  }
+package mining
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/abichinger/fastac"
+	"github.com/abichinger/fastac/api"
+	"github.com/abichinger/fastac/model"
+	"github.com/abichinger/fastac/model/defs"
+	"github.com/abichinger/fastac/str"
+	"github.com/abichinger/fastac/util"
+)
+
+// Role is a proposed role, the users are assigned to the role and the role is granted the permissions
+type Role struct {
+	Name        string
+	Users       []string
+	Permissions [][]string
+}
+
+// Proposal contains the proposed roles and the rule changes, which introduce the roles
+type Proposal struct {
+	Roles []Role
+	// Add contains the rules of the roles and the user assignments, e.g. {"p", "role1", "data1", "read"} and {"g", "alice", "role1"}
+	Add [][]string
+	// Remove contains the direct rules of the users, which are replaced by the roles
+	Remove [][]string
+}
+
+// Savings returns the number of rules saved by the proposal
+func (p *Proposal) Savings() int {
+	return len(p.Remove) - len(p.Add)
+}
+
+type miner struct {
+	policyKey  string
+	roleKey    string
+	similarity float64
+	minUsers   int
+	rolePrefix string
+}
+
+type Option func(*miner)
+
+// OptionKeys sets the keys of the policy definition and role definition (default: p, g)
+func OptionKeys(policyKey, roleKey string) Option {
+	return func(m *miner) {
+		m.policyKey = policyKey
+		m.roleKey = roleKey
+	}
+}
+
+// OptionSimilarity sets the minimal Jaccard similarity of the permissions of two users in one role (default: 1)
+// With a similarity < 1, a role contains the common permissions of its users, the remaining permissions stay direct rules.
+func OptionSimilarity(similarity float64) Option {
+	return func(m *miner) {
+		m.similarity = similarity
+	}
+}
+
+// OptionMinUsers sets the minimal number of users of a role (default: 2)
+func OptionMinUsers(n int) Option {
+	return func(m *miner) {
+		m.minUsers = n
+	}
+}
+
+// OptionRolePrefix sets the prefix of the proposed role names (default: role), the roles are numbered starting with 1
+func OptionRolePrefix(prefix string) Option {
+	return func(m *miner) {
+		m.rolePrefix = prefix
+	}
+}
+
+// permissions is a set of permissions by hash
+type permissions map[string][]string
+
+func (p permissions) intersect(other permissions) permissions {
+	res := permissions{}
+	for key, perm := range p {
+		if _, ok := other[key]; ok {
+			res[key] = perm
+		}
+	}
+	return res
+}
+
+func (p permissions) similarity(other permissions) float64 {
+	common := len(p.intersect(other))
+	if common == 0 {
+		return 0
+	}
+	return float64(common) / float64(len(p)+len(other)-common)
+}
+
+func (p permissions) sorted() [][]string {
+	keys := make([]string, 0, len(p))
+	for key := range p {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	res := make([][]string, len(keys))
+	for i, key := range keys {
+		res[i] = p[key]
+	}
+	return res
+}
+
+type cluster struct {
+	users []string
+	core  permissions
+}
+
+// savings returns the number of rules saved by a role for the cluster
+func (c *cluster) savings() int {
+	return len(c.users)*len(c.core) - len(c.core) - len(c.users)
+}
+
+// Mine proposes roles for the direct policy rules of users. Subjects, which are already roles, are not considered.
+// Only role definitions without domains are supported.
+func Mine(m model.IModel, options ...Option) (*Proposal, error) {
+	miner := &miner{policyKey: "p", roleKey: "g", similarity: 1, minUsers: 2, rolePrefix: "role"}
+	for _, option := range options {
+		option(miner)
+	}
+
+	def, ok := m.GetDef(model.G_SEC, miner.roleKey)
+	if rDef, isRoleDef := def.(*defs.RoleDef); !ok || !isRoleDef || rDef.NArgs() != 2 {
+		return nil, fmt.Errorf(str.ERR_MINING_ROLE_DEF, miner.roleKey)
+	}
+
+	names := map[string]bool{}
+	roles := map[string]bool{}
+	perms := map[string]permissions{}
+	m.RangeRules(func(rule []string) bool {
+		switch rule[0] {
+		case miner.roleKey:
+			names[rule[1]] = true
+			names[rule[2]] = true
+			roles[rule[2]] = true
+		case miner.policyKey:
+			if len(rule) < 3 {
+				return true
+			}
+			names[rule[1]] = true
+			if perms[rule[1]] == nil {
+				perms[rule[1]] = permissions{}
+			}
+			perm := append([]string{}, rule[2:]...)
+			perms[rule[1]][util.Hash(perm)] = perm
+		}
+		return true
+	})
+
+	users := []string{}
+	for user := range perms {
+		if !roles[user] {
+			users = append(users, user)
+		}
+	}
+	sort.Strings(users)
+
+	clusters := miner.cluster(users, perms)
+
+	proposal := &Proposal{Roles: []Role{}, Add: [][]string{}, Remove: [][]string{}}
+	index := 0
+	for _, c := range clusters {
+		if len(c.users) < miner.minUsers || c.savings() <= 0 {
+			continue
+		}
+		var name string
+		for name == "" || names[name] {
+			index++
+			name = fmt.Sprintf("%s%d", miner.rolePrefix, index)
+		}
+		names[name] = true
+
+		role := Role{Name: name, Users: c.users, Permissions: c.core.sorted()}
+		proposal.Roles = append(proposal.Roles, role)
+		for _, perm := range role.Permissions {
+			proposal.Add = append(proposal.Add, append([]string{miner.policyKey, name}, perm...))
+		}
+		for _, user := range role.Users {
+			proposal.Add = append(proposal.Add, []string{miner.roleKey, user, name})
+			for _, perm := range role.Permissions {
+				proposal.Remove = append(proposal.Remove, append([]string{miner.policyKey, user}, perm...))
+			}
+		}
+	}
+	return proposal, nil
+}
+
+// cluster groups users with identical permissions first, the groups are merged greedily if their permissions are similar
+func (miner *miner) cluster(users []string, perms map[string]permissions) []*cluster {
+	groups := []*cluster{}
+	groupIndex := map[string]*cluster{}
+	for _, user := range users {
+		keys := make([]string, 0, len(perms[user]))
+		for key := range perms[user] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		key := strings.Join(keys, ",")
+		if g, ok := groupIndex[key]; ok {
+			g.users = append(g.users, user)
+		} else {
+			g = &cluster{users: []string{user}, core: perms[user]}
+			groupIndex[key] = g
+			groups = append(groups, g)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].users) > len(groups[j].users)
+	})
+	if miner.similarity >= 1 {
+		return groups
+	}
+
+	clusters := []*cluster{}
+	for _, g := range groups {
+		var best *cluster
+		bestSimilarity := 0.0
+		for _, c := range clusters {
+			if s := c.core.similarity(g.core); s >= miner.similarity && s > bestSimilarity {
+				best, bestSimilarity = c, s
+			}
+		}
+		if best == nil {
+			clusters = append(clusters, &cluster{users: append([]string{}, g.users...), core: g.core})
+			continue
+		}
+		best.users = append(best.users, g.users...)
+		best.core = best.core.intersect(g.core)
+	}
+	for _, c := range clusters {
+		sort.Strings(c.users)
+	}
+	return clusters
+}
+
+// Verify checks, that the proposal does not change any decision of the enforcer.
+// The requests are built from all users and roles combined with all permissions, the permissions are truncated to the length of the request definition r.
+// A copy of the model is used, custom functions of the model are not copied.
+func Verify(e *fastac.Enforcer, proposal *Proposal) error {
+	text, ok := e.GetModel().(api.IString)
+	if !ok {
+		return errors.New(str.ERR_INVALID_MODEL)
+	}
+	m := model.NewModel()
+	if err := m.LoadModelFromText(text.String()); err != nil {
+		return err
+	}
+	proposed, err := fastac.NewEnforcer(m, nil)
+	if err != nil {
+		return err
+	}
+
+	removed := map[string]bool{}
+	for _, rule := range proposal.Remove {
+		removed[util.Hash(rule)] = true
+	}
+	rules := [][]string{}
+	subjects := map[string]bool{}
+	perms := permissions{}
+	e.GetModel().RangeRules(func(rule []string) bool {
+		if !removed[util.Hash(rule)] {
+			rules = append(rules, rule)
+		}
+		subjects[rule[1]] = true
+		if len(rule) > 2 {
+			if _, isPolicy := e.GetModel().GetDef(model.P_SEC, rule[0]); isPolicy {
+				perm := append([]string{}, rule[2:]...)
+				perms[util.Hash(perm)] = perm
+			}
+		}
+		return true
+	})
+	if err := proposed.AddRules(append(rules, proposal.Add...)); err != nil {
+		return err
+	}
+
+	rDef, ok := e.GetModel().GetRequestDef("r")
+	if !ok {
+		return fmt.Errorf(str.ERR_REQUESTDEF_NOT_FOUND, "r")
+	}
+	n := len(rDef.GetArgs()) - 1
+
+	names := make([]string, 0, len(subjects))
+	for name := range subjects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, perm := range perms.sorted() {
+			if len(perm) < n {
+				continue
+			}
+			values := append([]string{name}, perm[:n]...)
+			request := make([]interface{}, len(values))
+			for i, value := range values {
+				request[i] = value
+			}
+			expected, err := e.Enforce(request...)
+			if err != nil {
+				return err
+			}
+			actual, err := proposed.Enforce(request...)
+			if err != nil {
+				return err
+			}
+			if expected != actual {
+				return fmt.Errorf(str.ERR_DECISION_CHANGED, strings.Join(values, ", "), expected, actual)
+			}
+		}
+	}
+	return nil
+}
+
+// Apply applies the rule changes of the proposal in a single transaction
+func Apply(e *fastac.Enforcer, proposal *Proposal) error {
+	return e.Transaction(func(tx *fastac.Tx) error {
+		if err := tx.RemoveRules(proposal.Remove); err != nil {
+			return err
+		}
+		return tx.AddRules(proposal.Add)
+	})
+}
@@ -0,0 +1,91 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mining
+
+import (
+	"testing"
+
+	"github.com/abichinger/fastac"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestEnforcer(t *testing.T) *fastac.Enforcer {
+	e, err := fastac.NewEnforcer("../examples/rbac_model.conf", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, e.AddRules([][]string{
+		// identical permissions
+		{"p", "alice", "data1", "read"}, {"p", "alice", "data1", "write"}, {"p", "alice", "data2", "read"},
+		{"p", "bob", "data1", "read"}, {"p", "bob", "data1", "write"}, {"p", "bob", "data2", "read"},
+		{"p", "carol", "data1", "read"}, {"p", "carol", "data1", "write"}, {"p", "carol", "data2", "read"},
+		// similar permissions
+		{"p", "dave", "data1", "read"}, {"p", "dave", "data1", "write"}, {"p", "dave", "data2", "read"}, {"p", "dave", "data3", "read"},
+		// distinct permissions
+		{"p", "eve", "data4", "read"},
+		// existing role
+		{"p", "role1", "data5", "read"}, {"p", "role1", "data5", "write"},
+		{"g", "frank", "role1"},
+	}))
+	return e
+}
+
+func TestMine(t *testing.T) {
+	e := newTestEnforcer(t)
+
+	proposal, err := Mine(e.GetModel())
+	assert.NoError(t, err)
+	assert.Equal(t, []Role{{
+		Name:        "role2",
+		Users:       []string{"alice", "bob", "carol"},
+		Permissions: [][]string{{"data1", "read"}, {"data1", "write"}, {"data2", "read"}},
+	}}, proposal.Roles)
+	assert.Equal(t, 3, proposal.Savings())
+	assert.NoError(t, Verify(e, proposal))
+
+	proposal, err = Mine(e.GetModel(), OptionSimilarity(0.7), OptionRolePrefix("team_"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Role{{
+		Name:        "team_1",
+		Users:       []string{"alice", "bob", "carol", "dave"},
+		Permissions: [][]string{{"data1", "read"}, {"data1", "write"}, {"data2", "read"}},
+	}}, proposal.Roles)
+	assert.Contains(t, proposal.Remove, []string{"p", "dave", "data1", "write"})
+	assert.NotContains(t, proposal.Remove, []string{"p", "dave", "data3", "read"})
+	assert.NoError(t, Verify(e, proposal))
+
+	assert.NoError(t, Apply(e, proposal))
+	for _, sub := range []string{"alice", "bob", "carol", "dave"} {
+		allow, _ := e.Enforce(sub, "data1", "write")
+		assert.True(t, allow, sub)
+	}
+	allow, _ := e.Enforce("dave", "data3", "read")
+	assert.True(t, allow)
+	allow, _ = e.Enforce("alice", "data3", "read")
+	assert.False(t, allow)
+
+	proposal, _ = Mine(e.GetModel())
+	assert.Empty(t, proposal.Roles)
+}
+
+func TestVerify(t *testing.T) {
+	e := newTestEnforcer(t)
+
+	proposal, _ := Mine(e.GetModel())
+	proposal.Add = proposal.Add[1:]
+	assert.EqualError(t, Verify(e, proposal), "error: the decision of request alice, data1, read changed from true to false")
+
+	e, _ = fastac.NewEnforcer("../examples/rbac_with_domains_model.conf", nil)
+	_, err := Mine(e.GetModel())
+	assert.EqualError(t, err, "error: role mining requires a role definition g with two arguments")
+}
@@ -22,6 +22,7 @@ import (
 
 	"github.com/abichinger/fastac/model/eft"
 	"github.com/abichinger/fastac/model/types"
+	"github.com/abichinger/fastac/str"
 )
 
 const DefaultSep = ","
@@ -40,6 +41,8 @@ type PolicyDef struct {
 	key      string
 	args     []string
 	argIndex map[string]int
+	//number of trailing columns, which can be omitted
+	optional int
 }
 
 func NewPolicyDef(key, arguments string) *PolicyDef {
@@ -92,6 +95,10 @@ func (def *PolicyDef) GetParameter(rule []string, name string) (string, error) {
 		index++
 	}
 	if index >= len(rule) {
+		//omitted optional columns are empty
+		if index >= len(def.args)-def.optional {
+			return "", nil
+		}
 		return "", errors.New("rule has not enough values")
 	}
 	return rule[index], nil
@@ -135,6 +142,10 @@ func NewRequestDef(key, arguments string) *RequestDef {
 	return def
 }
 
+func (def *RequestDef) GetArgs() []string {
+	return def.args
+}
+
 func (def *RequestDef) Has(name string) bool {
 	_, ok := def.argIndex[name]
 	return ok
@@ -195,14 +206,27 @@ func (def *EffectDef) String() string {
 	return fmt.Sprintf("%s = %s", def.key, def.expr)
 }
 
+// default column names of grouping rules
+const DefaultRoleArgs = "user, role, domain"
+
 type RoleDef struct {
-	key   string
-	nargs int
+	key    string
+	nargs  int
+	params []string
 }
 
+// NewRoleDef creates a role definition. Link parameters are enclosed in parentheses after the role arguments.
+// The parameters can be named, to access them in matchers (g.valid_from).
+//
+//  g = _, _, (valid_from, valid_until)
 func NewRoleDef(key, arguments string) *RoleDef {
 	def := &RoleDef{}
 	def.key = key
+	arguments = strings.ReplaceAll(arguments, " ", "")
+	if i := strings.Index(arguments, "("); i >= 0 {
+		def.params = strings.Split(strings.Trim(arguments[i:], "()"), DefaultSep)
+		arguments = strings.TrimSuffix(arguments[:i], DefaultSep)
+	}
 	def.nargs = len(strings.Split(arguments, DefaultSep))
 	return def
 }
@@ -215,10 +239,179 @@ func (def *RoleDef) NArgs() int {
 	return def.nargs
 }
 
+// NParams returns the number of link parameters
+func (def *RoleDef) NParams() int {
+	return len(def.params)
+}
+
+// GetPolicyDef returns the policy definition of the grouping rules, which is used by matchers.
+// Unnamed link parameters are called param1, param2, ... and are optional.
+func (def *RoleDef) GetPolicyDef() *PolicyDef {
+	if len(def.params) == 0 {
+		return NewPolicyDef(def.key, DefaultRoleArgs)
+	}
+	args := []string{"user", "role"}
+	for i := 2; i < def.nargs; i++ {
+		if i == 2 {
+			args = append(args, "domain")
+		} else {
+			args = append(args, fmt.Sprintf("domain%d", i-1))
+		}
+	}
+	for i, param := range def.params {
+		if param == DefaultRoleParty {
+			param = fmt.Sprintf("param%d", i+1)
+		}
+		args = append(args, param)
+	}
+	pDef := NewPolicyDef(def.key, strings.Join(args, DefaultSep))
+	pDef.optional = len(def.params)
+	return pDef
+}
+
 func (def *RoleDef) String() string {
 	args := make([]string, def.nargs)
 	for i := 0; i < def.nargs; i++ {
 		args[i] = DefaultRoleParty
 	}
+	if len(def.params) > 0 {
+		args = append(args, "("+strings.Join(def.params, DefaultSep)+")")
+	}
 	return fmt.Sprintf("%s = %s", def.key, strings.Join(args, DefaultSep))
 }
+
+const (
+	RoleOptionMaxDepth        = "max_depth"
+	RoleOptionRoleMatcher     = "role_matcher"
+	RoleOptionDomainMatcher   = "domain_matcher"
+	RoleOptionRejectCycles    = "reject_cycles"
+	RoleOptionClosureIndex    = "closure_index"
+	RoleOptionDomainHierarchy = "domain_hierarchy"
+)
+
+// RoleOptionDef configures the role manager of a role definition.
+// The key consists of the role definition key and the option name.
+//
+//  g.max_depth = 5
+//  g.role_matcher = pathMatch
+//  g.domain_matcher = regexMatch
+//  g.reject_cycles = true
+//  g.closure_index = true
+//  g.domain_hierarchy = g2
+type RoleOptionDef struct {
+	key     string
+	roleKey string
+	option  string
+	value   string
+}
+
+func NewRoleOptionDef(key, value string) (*RoleOptionDef, error) {
+	i := strings.Index(key, ".")
+	if i < 0 {
+		return nil, fmt.Errorf(str.ERR_UNKNOWN_ROLE_OPTION, key)
+	}
+	def := &RoleOptionDef{
+		key:     key,
+		roleKey: key[:i],
+		option:  key[i+1:],
+		value:   strings.TrimSpace(value),
+	}
+	switch def.option {
+	case RoleOptionMaxDepth, RoleOptionRoleMatcher, RoleOptionDomainMatcher, RoleOptionRejectCycles, RoleOptionClosureIndex, RoleOptionDomainHierarchy:
+		return def, nil
+	default:
+		return nil, fmt.Errorf(str.ERR_UNKNOWN_ROLE_OPTION, key)
+	}
+}
+
+func (def *RoleOptionDef) GetKey() string {
+	return def.key
+}
+
+// GetRoleKey returns the key of the role definition
+func (def *RoleOptionDef) GetRoleKey() string {
+	return def.roleKey
+}
+
+func (def *RoleOptionDef) Option() string {
+	return def.option
+}
+
+func (def *RoleOptionDef) Value() string {
+	return def.value
+}
+
+func (def *RoleOptionDef) String() string {
+	return fmt.Sprintf("%s = %s", def.key, def.value)
+}
+
+const (
+	ConstraintSoD      = "sod"
+	ConstraintDSD      = "dsd"
+	ConstraintMaxUsers = "max_users"
+)
+
+// ConstraintDef restricts the grouping rules of a role definition.
+// The first argument is the key of the role definition.
+//
+//  c = sod(g, approver, requester)
+//  c2 = max_users(g, admin, 3)
+//  c3 = dsd(g, approver, auditor)
+type ConstraintDef struct {
+	key     string
+	kind    string
+	roleKey string
+	args    []string
+}
+
+func NewConstraintDef(key, value string) (*ConstraintDef, error) {
+	expr := strings.ReplaceAll(value, " ", "")
+	open := strings.Index(expr, "(")
+	if open <= 0 || !strings.HasSuffix(expr, ")") {
+		return nil, fmt.Errorf(str.ERR_INVALID_CONSTRAINT, key, value)
+	}
+	args := strings.Split(expr[open+1:len(expr)-1], DefaultSep)
+
+	def := &ConstraintDef{
+		key:     key,
+		kind:    expr[:open],
+		roleKey: args[0],
+		args:    args[1:],
+	}
+
+	valid := false
+	switch def.kind {
+	case ConstraintSoD, ConstraintDSD:
+		valid = len(def.args) >= 2
+	case ConstraintMaxUsers:
+		valid = len(def.args) == 2
+	}
+	if !valid || def.roleKey == "" {
+		return nil, fmt.Errorf(str.ERR_INVALID_CONSTRAINT, key, value)
+	}
+	return def, nil
+}
+
+func (def *ConstraintDef) GetKey() string {
+	return def.key
+}
+
+// Kind returns the type of the constraint (sod, dsd, max_users)
+func (def *ConstraintDef) Kind() string {
+	return def.kind
+}
+
+// GetRoleKey returns the key of the constrained role definition
+func (def *ConstraintDef) GetRoleKey() string {
+	return def.roleKey
+}
+
+// Args returns the arguments following the role definition key
+func (def *ConstraintDef) Args() []string {
+	return def.args
+}
+
+func (def *ConstraintDef) String() string {
+	args := append([]string{def.roleKey}, def.args...)
+	return fmt.Sprintf("%s = %s(%s)", def.key, def.kind, strings.Join(args, DefaultSep+" "))
+}
@@ -149,6 +149,8 @@ func TestToString(t *testing.T) {
 		{"p2", "sub, obj, act, eft"},
 		{"g", "_,_"},
 		{"g", "_,_,_"},
+		{"g2", "_,_,(_,_)"},
+		{"g3", "_,_,_,(valid_from,valid_until)"},
 		{"e", eft.SOME_ALLOW},
 		{"m", "true"},
 		{"m2", "p.obj == r.obj && p.sub == r.sub"},
@@ -377,3 +379,44 @@ func TestMatcherDef(t *testing.T) {
 
 	}
 }
+
+func TestRoleDef(t *testing.T) {
+	def := NewRoleDef("g", "_, _, _, (valid_from, _)")
+	assert.Equal(t, 3, def.NArgs())
+	assert.Equal(t, 2, def.NParams())
+
+	pDef := def.GetPolicyDef()
+	assert.Equal(t, []string{"user", "role", "domain", "valid_from", "param2"}, pDef.GetArgs())
+
+	value, err := pDef.GetParameter([]string{"alice", "admin", "domain1", "2022"}, "g_valid_from")
+	assert.NoError(t, err)
+	assert.Equal(t, "2022", value)
+	value, err = pDef.GetParameter([]string{"alice", "admin", "domain1"}, "g_param2")
+	assert.NoError(t, err)
+	assert.Equal(t, "", value)
+	_, err = pDef.GetParameter([]string{"alice", "admin"}, "g_domain")
+	assert.Error(t, err)
+}
+
+func TestConstraintDef(t *testing.T) {
+	def, err := NewConstraintDef("c", "sod(g, approver, requester)")
+	assert.NoError(t, err)
+	assert.Equal(t, ConstraintSoD, def.Kind())
+	assert.Equal(t, "g", def.GetRoleKey())
+	assert.Equal(t, []string{"approver", "requester"}, def.Args())
+	assert.Equal(t, "c = sod(g, approver, requester)", def.String())
+
+	def, err = NewConstraintDef("c2", "max_users(g2, admin, 3)")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin", "3"}, def.Args())
+
+	def, err = NewConstraintDef("c3", "dsd(g, approver, requester)")
+	assert.NoError(t, err)
+	assert.Equal(t, ConstraintDSD, def.Kind())
+
+	invalid := []string{"sod(g, approver)", "dsd(g, approver)", "max_users(g, admin)", "unique(g, admin)", "sod g, a, b", "(g, a, b)"}
+	for _, value := range invalid {
+		_, err = NewConstraintDef("c", value)
+		assert.Error(t, err, value)
+	}
+}
@@ -201,6 +201,11 @@ func (def *MatcherDef) GetKey() string {
 	return def.key
 }
 
+// Expr returns the expression of the matcher as defined in the model
+func (def *MatcherDef) Expr() string {
+	return def.expr
+}
+
 func (def *MatcherDef) GetPolicyArgs() []string {
 	return def.root.RecursivePolicyArgs()
 }
@@ -0,0 +1,237 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/abichinger/fastac/rbac"
+	"github.com/abichinger/fastac/str"
+	"github.com/abichinger/fastac/util"
+	"github.com/abichinger/govaluate"
+)
+
+type expiryEntry struct {
+	key  string
+	rule []string
+	at   time.Time
+}
+
+// expiryQueue is a min-heap of expiry entries, the entry which expires first is at index 0.
+// Entries are not removed, when the expiry of a rule changes. Outdated entries are skipped instead.
+type expiryQueue []*expiryEntry
+
+func (q expiryQueue) Len() int            { return len(q) }
+func (q expiryQueue) Less(i, j int) bool  { return q[i].at.Before(q[j].at) }
+func (q expiryQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x interface{}) { *q = append(*q, x.(*expiryEntry)) }
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	entry := old[n-1]
+	*q = old[:n-1]
+	return entry
+}
+
+// expiries holds the expiry times of rules, the entry at the top of the queue is always valid.
+// The expiries are guarded by their own lock, so the reaper and the matching can access them concurrently.
+type expiries struct {
+	mu    sync.RWMutex
+	times map[string]time.Time
+	queue expiryQueue
+}
+
+func newExpiries() *expiries {
+	return &expiries{times: make(map[string]time.Time)}
+}
+
+// valid returns true, if entry reflects the current expiry of its rule
+func (ex *expiries) valid(entry *expiryEntry) bool {
+	at, ok := ex.times[entry.key]
+	return ok && at.Equal(entry.at)
+}
+
+// prune removes outdated entries from the top of the queue, the caller must hold the write lock
+func (ex *expiries) prune() {
+	for len(ex.queue) > 0 && !ex.valid(ex.queue[0]) {
+		heap.Pop(&ex.queue)
+	}
+}
+
+func (ex *expiries) set(key string, rule []string, at time.Time) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	ex.times[key] = at
+	heap.Push(&ex.queue, &expiryEntry{key, append([]string{}, rule...), at})
+	ex.prune()
+}
+
+func (ex *expiries) get(key string) (time.Time, bool) {
+	ex.mu.RLock()
+	defer ex.mu.RUnlock()
+	at, ok := ex.times[key]
+	return at, ok
+}
+
+func (ex *expiries) clear(keys ...string) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	for _, key := range keys {
+		delete(ex.times, key)
+	}
+	ex.prune()
+}
+
+// next returns the entry, which expires next
+func (ex *expiries) next() (*expiryEntry, bool) {
+	ex.mu.RLock()
+	defer ex.mu.RUnlock()
+	if len(ex.queue) == 0 {
+		return nil, false
+	}
+	return ex.queue[0], true
+}
+
+// pop removes the entry, which expires next, if it expired before or at now
+func (ex *expiries) pop(now time.Time) (*expiryEntry, bool) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	if len(ex.queue) == 0 || ex.queue[0].at.After(now) {
+		return nil, false
+	}
+	entry := heap.Pop(&ex.queue).(*expiryEntry)
+	delete(ex.times, entry.key)
+	ex.prune()
+	return entry, true
+}
+
+// expired returns the rules, which expired before or at now, indexed by their hash.
+// Only the expired part of the heap is visited.
+func (ex *expiries) expired(now time.Time) map[string][]string {
+	ex.mu.RLock()
+	defer ex.mu.RUnlock()
+	if len(ex.queue) == 0 || ex.queue[0].at.After(now) {
+		return nil
+	}
+
+	res := map[string][]string{}
+	stack := []int{0}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i >= len(ex.queue) || ex.queue[i].at.After(now) {
+			continue
+		}
+		if entry := ex.queue[i]; ex.valid(entry) {
+			res[entry.key] = entry.rule
+		}
+		stack = append(stack, 2*i+1, 2*i+2)
+	}
+	return res
+}
+
+// SetExpiry sets the time, after which rule is removed from the model.
+// Expired rules are not matched anymore, even if they were not removed yet.
+// The expiry is discarded, if the rule is removed earlier, and it is moved to the new rule by UpdateRule.
+// A zero time removes the expiry. Adapters implementing storage.ExpiryAdapter store the expiry next to the rule.
+func (m *Model) SetExpiry(rule []string, at time.Time) error {
+	if len(rule) == 0 {
+		return fmt.Errorf(str.ERR_POLICY_NOT_FOUND, "")
+	}
+	if _, ok := m.GetPolicy(rule[0]); !ok {
+		return fmt.Errorf(str.ERR_POLICY_NOT_FOUND, rule[0])
+	}
+	if at.IsZero() {
+		m.ClearExpiry(rule)
+		return nil
+	}
+	m.expiries.set(util.Hash(rule), rule, at)
+	return nil
+}
+
+// GetExpiry returns the expiry time of rule, false is returned if the rule does not expire
+func (m *Model) GetExpiry(rule []string) (time.Time, bool) {
+	return m.expiries.get(util.Hash(rule))
+}
+
+// ClearExpiry removes the expiry time of rule, the rule is kept permanently
+func (m *Model) ClearExpiry(rule []string) {
+	m.expiries.clear(util.Hash(rule))
+}
+
+// clearExpiries removes the expiry times of all rules of a policy
+func (m *Model) clearExpiries(pKey string) {
+	m.expiries.mu.RLock()
+	keys := []string{}
+	for _, entry := range m.expiries.queue {
+		if entry.rule[0] == pKey {
+			keys = append(keys, entry.key)
+		}
+	}
+	m.expiries.mu.RUnlock()
+	m.expiries.clear(keys...)
+}
+
+// NextExpiry returns the earliest expiry time of all rules
+func (m *Model) NextExpiry() (time.Time, bool) {
+	entry, ok := m.expiries.next()
+	if !ok {
+		return time.Time{}, false
+	}
+	return entry.at, true
+}
+
+// gFunction wraps the g function of a role definition, links of expired rules are not followed
+func (m *Model) gFunction(key string, rm rbac.IRoleManager, g govaluate.ExpressionFunction) govaluate.ExpressionFunction {
+	return func(args ...interface{}) (interface{}, error) {
+		res, err := g(args...)
+		if res != true || err != nil {
+			return res, err
+		}
+		excluded := [][]string{}
+		for _, rule := range m.expiries.expired(time.Now()) {
+			if rule[0] == key {
+				excluded = append(excluded, rule[1:])
+			}
+		}
+		if len(excluded) == 0 {
+			return res, err
+		}
+		return rbac.HasLinkExcluding(rm, excluded, args...)
+	}
+}
+
+// RemoveExpired removes all rules, which expired before or at now.
+// RULE_REMOVED is emitted for every removed rule.
+func (m *Model) RemoveExpired(now time.Time) ([][]string, error) {
+	removed := [][]string{}
+	for {
+		entry, ok := m.expiries.pop(now)
+		if !ok {
+			return removed, nil
+		}
+
+		ok, err := m.RemoveRule(entry.rule)
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed = append(removed, entry.rule)
+		}
+	}
+}
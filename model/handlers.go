@@ -1,10 +1,16 @@
 package model
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/abichinger/fastac/model/defs"
 	"github.com/abichinger/fastac/model/effector"
 	"github.com/abichinger/fastac/model/policy"
 	"github.com/abichinger/fastac/rbac"
+	"github.com/abichinger/fastac/str"
+	"github.com/abichinger/fastac/util"
 )
 
 func addPolicyDef(m *Model, key string, arguments string) error {
@@ -32,24 +38,235 @@ func removeMatcherDef(m *Model, key string) error {
 	return nil
 }
 
+// default maximum depth of role inheritance
+const defaultMaxDepth = 10
+
 func addRoleDef(m *Model, key, arguments string) error {
+	if strings.Contains(key, ".") {
+		return addRoleOption(m, key, arguments)
+	}
+
 	def := defs.NewRoleDef(key, arguments)
 	m.defs[G_SEC][key] = def
-	var rm rbac.IRoleManager
+	return setupRoleManager(m, key, newRoleManager(m, def))
+}
+
+// newRoleManager creates the default role manager of a role definition, links of a registered provider are included
+func newRoleManager(m *Model, def *defs.RoleDef) rbac.IRoleManager {
+	var rm rbac.IDefaultRoleManager
 	if def.NArgs() == 2 {
-		rm = rbac.NewRoleManager(10)
+		rm = rbac.NewRoleManager(defaultMaxDepth)
 	} else {
-		rm = rbac.NewDomainManager(10)
+		rm = rbac.NewDomainManager(defaultMaxDepth)
+	}
+	if provider, ok := m.providers[def.GetKey()]; ok {
+		rm = rbac.NewProviderRoleManager(provider.provider, rm, defaultMaxDepth, provider.ttl)
 	}
+	if def.NParams() > 0 {
+		return rbac.NewConditionalRoleManager(rm, def.NArgs()-2, defaultMaxDepth, rbac.TimeWindow)
+	}
+	return rm
+}
+
+// setupRoleManager registers rm for the role definition key and applies the options and constraints of the role definition
+func setupRoleManager(m *Model, key string, rm rbac.IRoleManager) error {
 	m.rpMap[key] = rbac.NewRolePolicy(rm)
-	m.fm.SetFunction(key, rbac.GenerateGFunction(rm))
-	return nil
+	m.fm.SetFunction(key, m.gFunction(key, rm, rbac.GenerateGFunction(rm)))
+
+	//apply options, which are declared before the role definition, in the order of their keys
+	var err error
+	m.RangeDefs(G_SEC, func(d defs.IDef) bool {
+		if option, ok := d.(*defs.RoleOptionDef); ok && option.GetRoleKey() == key {
+			err = applyRoleOption(m, rm, option.Option(), option.Value())
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := updateDomainHierarchies(m, key); err != nil {
+		return err
+	}
+	return applyConstraints(m, key)
 }
 
 func removeRoleDef(m *Model, key string) error {
+	if def, ok := m.defs[G_SEC][key].(*defs.RoleOptionDef); ok {
+		delete(m.defs[G_SEC], key)
+		if rp, ok := m.rpMap[def.GetRoleKey()]; ok {
+			return applyRoleOption(m, rp.GetRoleManager(), def.Option(), "")
+		}
+		return nil
+	}
+
 	delete(m.defs[G_SEC], key)
 	delete(m.rpMap, key)
 	m.fm.RemoveFunction(key)
+	return updateDomainHierarchies(m, key)
+}
+
+func addRoleOption(m *Model, key, value string) error {
+	def, err := defs.NewRoleOptionDef(key, value)
+	if err != nil {
+		return err
+	}
+	if rp, ok := m.rpMap[def.GetRoleKey()]; ok {
+		if err := applyRoleOption(m, rp.GetRoleManager(), def.Option(), def.Value()); err != nil {
+			return err
+		}
+	}
+	m.defs[G_SEC][key] = def
+	return nil
+}
+
+// applyRoleOption configures a role manager, an empty value restores the default
+func applyRoleOption(m *Model, rm rbac.IRoleManager, option, value string) error {
+	if option == defs.RoleOptionDomainHierarchy {
+		return applyDomainHierarchy(m, rm, value)
+	}
+
+	drm, ok := rm.(rbac.IDefaultRoleManager)
+	if !ok {
+		return fmt.Errorf(str.ERR_INVALID_ROLE_OPTION, value, option)
+	}
+
+	switch option {
+	case defs.RoleOptionMaxDepth:
+		depth := defaultMaxDepth
+		if value != "" {
+			var err error
+			if depth, err = strconv.Atoi(value); err != nil || depth <= 0 {
+				return fmt.Errorf(str.ERR_INVALID_ROLE_OPTION, value, option)
+			}
+		}
+		drm.SetMaxHierarchyLevel(depth)
+	case defs.RoleOptionRoleMatcher, defs.RoleOptionDomainMatcher:
+		var matcher util.IMatcher
+		if value != "" {
+			if matcher, ok = rbac.GetRoleMatcher(value); !ok {
+				return fmt.Errorf(str.ERR_ROLE_MATCHER, value)
+			}
+		}
+		if option == defs.RoleOptionRoleMatcher {
+			drm.SetMatcher(matcher)
+		} else {
+			drm.SetDomainMatcher(matcher)
+		}
+	case defs.RoleOptionRejectCycles, defs.RoleOptionClosureIndex:
+		enabled := false
+		if value != "" {
+			var err error
+			if enabled, err = strconv.ParseBool(value); err != nil {
+				return fmt.Errorf(str.ERR_INVALID_ROLE_OPTION, value, option)
+			}
+		}
+		if option == defs.RoleOptionRejectCycles {
+			drm.SetRejectCycles(enabled)
+		} else {
+			drm.SetClosureIndex(enabled)
+		}
+	default:
+		return fmt.Errorf(str.ERR_UNKNOWN_ROLE_OPTION, option)
+	}
+	return nil
+}
+
+// applyDomainHierarchy sets the role manager of the role definition hierarchyKey as domain hierarchy of rm.
+// If the role definition does not exist yet, the hierarchy is set as soon as it is added.
+func applyDomainHierarchy(m *Model, rm rbac.IRoleManager, hierarchyKey string) error {
+	dhm, ok := rm.(rbac.IDomainHierarchyManager)
+	if !ok {
+		return fmt.Errorf(str.ERR_INVALID_ROLE_OPTION, hierarchyKey, defs.RoleOptionDomainHierarchy)
+	}
+	var hierarchy rbac.IRoleManager
+	if rp, ok := m.rpMap[hierarchyKey]; ok {
+		hierarchy = rp.GetRoleManager()
+	}
+	dhm.SetDomainHierarchy(hierarchy)
+	return nil
+}
+
+// updateDomainHierarchies updates the role managers, which use the role definition key as domain hierarchy
+func updateDomainHierarchies(m *Model, key string) error {
+	var err error
+	m.RangeDefs(G_SEC, func(d defs.IDef) bool {
+		option, ok := d.(*defs.RoleOptionDef)
+		if !ok || option.Option() != defs.RoleOptionDomainHierarchy || option.Value() != key {
+			return true
+		}
+		if rp, ok := m.rpMap[option.GetRoleKey()]; ok {
+			err = applyDomainHierarchy(m, rp.GetRoleManager(), key)
+		}
+		return err == nil
+	})
+	return err
+}
+
+func addConstraintDef(m *Model, key, value string) error {
+	def, err := defs.NewConstraintDef(key, value)
+	if err != nil {
+		return err
+	}
+	c, err := newConstraint(def)
+	if err != nil {
+		return err
+	}
+	if old, ok := m.defs[C_SEC][key].(*defs.ConstraintDef); ok {
+		if rp, ok := m.rpMap[old.GetRoleKey()]; ok {
+			rp.RemoveConstraint(key)
+		}
+	}
+	if rp, ok := m.rpMap[def.GetRoleKey()]; ok {
+		rp.SetConstraint(key, c)
+	}
+	m.defs[C_SEC][key] = def
+	return nil
+}
+
+func removeConstraintDef(m *Model, key string) error {
+	if def, ok := m.defs[C_SEC][key].(*defs.ConstraintDef); ok {
+		if rp, ok := m.rpMap[def.GetRoleKey()]; ok {
+			rp.RemoveConstraint(key)
+		}
+	}
+	delete(m.defs[C_SEC], key)
+	return nil
+}
+
+func newConstraint(def *defs.ConstraintDef) (rbac.Constraint, error) {
+	args := def.Args()
+	switch def.Kind() {
+	case defs.ConstraintSoD:
+		return rbac.NewSeparationOfDuty(def.GetKey(), args...), nil
+	case defs.ConstraintDSD:
+		return rbac.NewDynamicSeparationOfDuty(def.GetKey(), args...), nil
+	case defs.ConstraintMaxUsers:
+		max, err := strconv.Atoi(args[1])
+		if err != nil || max < 0 {
+			return nil, fmt.Errorf(str.ERR_INVALID_CONSTRAINT, def.GetKey(), def.String())
+		}
+		return rbac.NewRoleCardinality(def.GetKey(), args[0], max), nil
+	}
+	return nil, fmt.Errorf(str.ERR_INVALID_CONSTRAINT, def.GetKey(), def.String())
+}
+
+// applyConstraints adds the constraints, which were declared before the role definition key
+func applyConstraints(m *Model, key string) error {
+	rp, ok := m.rpMap[key]
+	if !ok {
+		return nil
+	}
+	for _, d := range m.defs[C_SEC] {
+		def := d.(*defs.ConstraintDef)
+		if def.GetRoleKey() != key {
+			continue
+		}
+		c, err := newConstraint(def)
+		if err != nil {
+			return err
+		}
+		rp.SetConstraint(def.GetKey(), c)
+	}
 	return nil
 }
 
@@ -104,6 +104,13 @@ func NewMatcher(pDef *defs.PolicyDef, policy p.IPolicy, exprRoot *defs.MatcherSt
 		m.removeRule(rule)
 	})
 
+	policy.AddListener(p.EVT_RULE_UPDATED, func(arguments ...interface{}) {
+		oldRule := arguments[0].([]string)
+		newRule := arguments[1].([]string)
+		m.removeRule(oldRule)
+		m.addRule(newRule)
+	})
+
 	policy.AddListener(p.EVT_CLEARED, func(arguments ...interface{}) {
 		m.root = NewMatcherNode([]string{""})
 	})
@@ -221,8 +228,12 @@ func (m *Matcher) rangeMatchesHelper(exprNode *defs.MatcherStage, node *MatcherN
 
 func (m *Matcher) RangeMatches(rDef defs.RequestDef, rvals []interface{}, fMap fm.FunctionMap, fn func(rule []string) bool) error {
 	params := NewMatchParameters(*m.pDef, nil, rDef, rvals)
-	fMap.SetFunction("eval", generateEvalFunction(fMap, params))
-	functions := fMap.GetFunctions()
+	//eval depends on the request, the shared function map is copied to allow concurrent calls
+	functions := make(map[string]govaluate.ExpressionFunction, len(fMap.GetFunctions()))
+	for name, function := range fMap.GetFunctions() {
+		functions[name] = function
+	}
+	functions["eval"] = generateEvalFunction(functions, params)
 
 	_, err := m.rangeMatchesHelper(m.exprRoot, m.root, params, functions, fn)
 	if err != nil {
@@ -240,9 +251,7 @@ func eval(expression string, functions map[string]govaluate.ExpressionFunction,
 	return expr.Eval(parameters)
 }
 
-func generateEvalFunction(fMap fm.FunctionMap, parameters *MatchParameters) govaluate.ExpressionFunction {
-	functions := fMap.GetFunctions()
-
+func generateEvalFunction(functions map[string]govaluate.ExpressionFunction, parameters *MatchParameters) govaluate.ExpressionFunction {
 	return func(args ...interface{}) (interface{}, error) {
 		if err := util.ValidateVariadicArgs(1, args...); err != nil {
 			return false, fmt.Errorf("%s: %s", "eval", err)
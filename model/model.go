@@ -17,6 +17,7 @@ package model
 import (
 	"fmt"
 	"sort"
+	"time"
 
 	"github.com/abichinger/fastac/model/defs"
 	"github.com/abichinger/fastac/model/effector"
@@ -26,6 +27,7 @@ import (
 	"github.com/abichinger/fastac/model/policy"
 	"github.com/abichinger/fastac/rbac"
 	"github.com/abichinger/fastac/str"
+	"github.com/abichinger/fastac/util"
 	"github.com/abichinger/govaluate"
 	"github.com/go-ini/ini"
 	em "github.com/vansante/go-event-emitter"
@@ -34,6 +36,7 @@ import (
 const (
 	RULE_ADDED   = "rule_added"
 	RULE_REMOVED = "rule_removed"
+	RULE_UPDATED = "rule_updated"
 )
 
 const (
@@ -42,6 +45,7 @@ const (
 	G_SEC = 'g'
 	M_SEC = 'm'
 	E_SEC = 'e'
+	C_SEC = 'c'
 )
 
 type SectionDef struct {
@@ -67,6 +71,7 @@ var sections = []*SectionDef{
 	NewSectionDef("role_definition", G_SEC, addRoleDef, removeRoleDef),
 	NewSectionDef("policy_effect", E_SEC, addEffectDef, removeEffectDef),
 	NewSectionDef("matchers", M_SEC, addMatcherDef, removeMatcherDef),
+	NewSectionDef("constraint_definition", C_SEC, addConstraintDef, removeConstraintDef),
 }
 
 type Model struct {
@@ -77,11 +82,21 @@ type Model struct {
 	rpMap map[string]*rbac.RolePolicy
 	eMap  map[string]effector.IEffector
 
+	providers map[string]*roleProvider
+
+	//sections of the last loaded CONF, which are not part of the model
+	unknownSections []string
+
 	secDefs    map[byte]*SectionDef
 	secNameMap map[string]byte
 
 	fm *fm.FunctionMap
 	*em.Emitter
+
+	//version of the latest snapshot
+	version uint64
+
+	expiries *expiries
 }
 
 func NewModel() *Model {
@@ -91,6 +106,7 @@ func NewModel() *Model {
 	m.mMap = make(map[string]matcher.IMatcher)
 	m.rpMap = make(map[string]*rbac.RolePolicy)
 	m.eMap = make(map[string]effector.IEffector)
+	m.providers = make(map[string]*roleProvider)
 
 	m.secDefs = make(map[byte]*SectionDef)
 	m.secNameMap = make(map[string]byte)
@@ -103,6 +119,7 @@ func NewModel() *Model {
 	}
 
 	m.Emitter = em.NewEmitter(false)
+	m.expiries = newExpiries()
 
 	return m
 }
@@ -146,21 +163,35 @@ func (m *Model) LoadModelFromText(text string) error {
 }
 
 func (m *Model) loadModelFromConfig(cfg *ini.File) error {
+	if errs := m.loadDefs(cfg, true); len(errs) > 0 {
+		return errs[0]
+	}
+	return m.BuildMatchers()
+}
+
+// loadDefs sets the definitions of cfg, unknown sections are ignored and reported by Validate
+func (m *Model) loadDefs(cfg *ini.File, stopOnError bool) []error {
+	errs := []error{}
+	m.unknownSections = []string{}
 	for _, sec := range cfg.Sections() {
 		secKey, ok := m.getSecKeyByName(sec.Name())
 		if !ok {
-			continue //ignore unknown section
+			if sec.Name() != ini.DefaultSection || len(sec.Keys()) > 0 {
+				m.unknownSections = append(m.unknownSections, sec.Name())
+			}
+			continue
 		}
 
 		for _, key := range sec.Keys() {
-			err := m.SetDef(secKey, key.Name(), key.String())
-			if err != nil {
-				return err
+			if err := m.SetDef(secKey, key.Name(), key.String()); err != nil {
+				errs = append(errs, err)
+				if stopOnError {
+					return errs
+				}
 			}
 		}
 	}
-
-	return m.BuildMatchers()
+	return errs
 }
 
 func (m *Model) SetDef(sec byte, key string, value string) error {
@@ -182,6 +213,21 @@ func (m *Model) GetDef(sec byte, key string) (defs.IDef, bool) {
 	return def, ok
 }
 
+// RangeDefs calls fn for every definition of sec sorted by key
+func (m *Model) RangeDefs(sec byte, fn func(def defs.IDef) bool) {
+	secMap := m.defs[sec]
+	keys := make([]string, 0, len(secMap))
+	for key := range secMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !fn(secMap[key]) {
+			return
+		}
+	}
+}
+
 func (m *Model) RemoveDef(sec byte, key string) error {
 	secDef, ok := m.getSecDefByKey(sec)
 	if !ok {
@@ -232,7 +278,11 @@ func (m *Model) BuildMatcherFromDef(mDef *defs.MatcherDef) (matcher.IMatcher, er
 		}
 		pDef = def.(*defs.PolicyDef)
 	case G_SEC:
-		pDef = defs.NewPolicyDef(pKey, "user, role, domain")
+		if def, ok := m.defs[G_SEC][pKey]; ok {
+			pDef = def.(*defs.RoleDef).GetPolicyDef()
+		} else {
+			pDef = defs.NewPolicyDef(pKey, defs.DefaultRoleArgs)
+		}
 	default:
 		return nil, fmt.Errorf(str.ERR_POLICY_NOT_FOUND, pKey)
 	}
@@ -278,11 +328,65 @@ func (m *Model) RemoveRule(rule []string) (bool, error) {
 		return false, fmt.Errorf(str.ERR_POLICY_NOT_FOUND, key)
 	}
 	if removed {
+		m.ClearExpiry(rule)
 		m.Emitter.EmitEvent(RULE_REMOVED, rule)
 	}
 	return removed, err
 }
 
+// UpdateRule replaces oldRule with newRule, both rules must belong to the same policy
+// Returns false, if oldRule was not present
+func (m *Model) UpdateRule(oldRule, newRule []string) (bool, error) {
+	key := oldRule[0]
+	if len(newRule) == 0 || newRule[0] != key {
+		return false, fmt.Errorf(str.ERR_UPDATE_KEY_MISMATCH, oldRule, newRule)
+	}
+	p, ok := m.GetPolicy(key)
+	if !ok {
+		return false, fmt.Errorf(str.ERR_POLICY_NOT_FOUND, key)
+	}
+	updated, err := p.UpdateRule(oldRule[1:], newRule[1:])
+	if updated {
+		if at, ok := m.GetExpiry(oldRule); ok {
+			m.ClearExpiry(oldRule)
+			m.expiries.set(util.Hash(newRule), newRule, at)
+		}
+		m.Emitter.EmitEvent(RULE_UPDATED, oldRule, newRule)
+	}
+	return updated, err
+}
+
+// UpdateRules replaces each rule of oldRules with the rule of newRules at the same index
+func (m *Model) UpdateRules(oldRules, newRules [][]string) error {
+	if len(oldRules) != len(newRules) {
+		return fmt.Errorf(str.ERR_UPDATE_LENGTH, len(oldRules), len(newRules))
+	}
+	for i := range oldRules {
+		if _, err := m.UpdateRule(oldRules[i], newRules[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateFilteredRules replaces all rules, which match the request, with the result of fn.
+// The rules passed to fn include the policy key. If fn returns nil, the rule is left unchanged.
+func (m *Model) UpdateFilteredRules(matcher matcher.IMatcher, rDef *defs.RequestDef, rvals []interface{}, fn func(rule []string) []string) error {
+	oldRules := [][]string{}
+	newRules := [][]string{}
+	err := m.RangeMatches(matcher, rDef, rvals, func(rule []string) bool {
+		if newRule := fn(rule); newRule != nil {
+			oldRules = append(oldRules, rule)
+			newRules = append(newRules, newRule)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return m.UpdateRules(oldRules, newRules)
+}
+
 func (m *Model) addPolicyRule(key string, rule []string) (bool, error) {
 	policy, ok := m.pMap[key]
 	if !ok {
@@ -328,12 +432,58 @@ func (m *Model) SetPolicy(key string, policy policy.IPolicy) {
 
 func (m *Model) GetRoleManager(key string) (rbac.IRoleManager, bool) {
 	rp, ok := m.rpMap[key]
-	return rp.GetRoleManager(), ok
+	if !ok {
+		return nil, false
+	}
+	return rp.GetRoleManager(), true
 }
 
 func (m *Model) SetRoleManager(key string, rm rbac.IRoleManager) {
 	m.rpMap[key] = rbac.NewRolePolicy(rm)
-	m.fm.SetFunction(key, rbac.GenerateGFunction(rm))
+	m.fm.SetFunction(key, m.gFunction(key, rm, rbac.GenerateGFunction(rm)))
+}
+
+type roleProvider struct {
+	provider rbac.RoleProvider
+	ttl      time.Duration
+}
+
+// SetRoleProvider adds the links of an external role provider to the role definition key, the answers of the provider are cached for ttl.
+// The local rules of the role definition are kept, links of the provider are never stored by adapters.
+// The role manager is wrapped in place, so its listeners and the matchers of the role definition stay valid.
+//
+//  m.SetRoleProvider("g", directory.NewProvider(conn, directory.Config{BaseDN: "dc=example,dc=org"}), time.Minute)
+func (m *Model) SetRoleProvider(key string, provider rbac.RoleProvider, ttl time.Duration) error {
+	if rp, ok := m.rpMap[key]; ok {
+		if !rp.SetRoleProvider(provider, ttl) {
+			return fmt.Errorf(str.ERR_NO_PROVIDER_SUPPORT, key)
+		}
+		rm := rp.GetRoleManager()
+		m.fm.SetFunction(key, m.gFunction(key, rm, rbac.GenerateGFunction(rm)))
+		if err := updateDomainHierarchies(m, key); err != nil {
+			return err
+		}
+	}
+	m.providers[key] = &roleProvider{provider: provider, ttl: ttl}
+	return nil
+}
+
+// SetLinkCondition sets the condition of a role definition with link parameters, the default condition is rbac.TimeWindow
+//
+//  m.SetLinkCondition("g", func(params []string, args ...interface{}) (bool, error) {
+//  	return len(args) > 0 && params[0] == args[0], nil
+//  })
+func (m *Model) SetLinkCondition(key string, condition rbac.LinkCondition) error {
+	rm, ok := m.GetRoleManager(key)
+	if !ok {
+		return fmt.Errorf(str.ERR_RM_NOT_FOUND, key)
+	}
+	crm, ok := rm.(*rbac.ConditionalRoleManager)
+	if !ok {
+		return fmt.Errorf(str.ERR_NOT_CONDITIONAL, key)
+	}
+	crm.SetCondition(condition)
+	return nil
 }
 
 func (m *Model) GetMatcher(key string) (matcher.IMatcher, bool) {
@@ -346,8 +496,8 @@ func (m *Model) SetMatcher(key string, matcher matcher.IMatcher) {
 }
 
 func (m *Model) GetRequestDef(key string) (*defs.RequestDef, bool) {
-	def, ok := m.defs[R_SEC][key]
-	return def.(*defs.RequestDef), ok
+	def, ok := m.defs[R_SEC][key].(*defs.RequestDef)
+	return def, ok
 }
 
 func (m *Model) SetRequestDef(key string, def *defs.RequestDef) {
@@ -363,13 +513,44 @@ func (m *Model) SetEffector(key string, effector e.IEffector) {
 	m.eMap[key] = effector
 }
 
+// RangeMatches calls fn for every rule, which matches the request. Expired rules are skipped.
 func (m *Model) RangeMatches(matcher matcher.IMatcher, rDef *defs.RequestDef, rvals []interface{}, fn func(rule []string) bool) error {
+	return m.rangeMatches(matcher, rDef, rvals, m.fm, fn)
+}
+
+func (m *Model) rangeMatches(matcher matcher.IMatcher, rDef *defs.RequestDef, rvals []interface{}, functions *fm.FunctionMap, fn func(rule []string) bool) error {
 	policyKey := []string{matcher.GetPolicyKey()}
-	return matcher.RangeMatches(*rDef, rvals, *m.fm, func(rule []string) bool {
-		return fn(append(policyKey, rule...))
+	expired := m.expiries.expired(time.Now())
+	return matcher.RangeMatches(*rDef, rvals, *functions, func(rule []string) bool {
+		rule = append(policyKey, rule...)
+		if _, ok := expired[util.Hash(rule)]; ok {
+			return true
+		}
+		return fn(rule)
 	})
 }
 
+// RangeMatchesWithSession works like RangeMatches, but the g functions only follow links through the activated roles of the session
+func (m *Model) RangeMatchesWithSession(matcher matcher.IMatcher, rDef *defs.RequestDef, rvals []interface{}, session rbac.Session, fn func(rule []string) bool) error {
+	if len(session) == 0 {
+		return m.RangeMatches(matcher, rDef, rvals, fn)
+	}
+
+	functions := fm.NewFunctionMap()
+	for name, function := range m.fm.GetFunctions() {
+		functions.SetFunction(name, function)
+	}
+	for key, roles := range session {
+		rp, ok := m.rpMap[key]
+		if !ok {
+			return fmt.Errorf(str.ERR_RM_NOT_FOUND, key)
+		}
+		functions.SetFunction(key, m.gFunction(key, rp.GetRoleManager(), rbac.GenerateSessionGFunction(rp, roles)))
+	}
+
+	return m.rangeMatches(matcher, rDef, rvals, functions, fn)
+}
+
 func (m *Model) SetFunction(name string, function govaluate.ExpressionFunction) {
 	m.fm.SetFunction(name, function)
 }
@@ -423,5 +604,6 @@ func (m *Model) ClearPolicy(pKey string) error {
 	if !ok {
 		return fmt.Errorf(str.ERR_POLICY_NOT_FOUND, pKey)
 	}
+	m.clearExpiries(pKey)
 	return p.Clear()
 }
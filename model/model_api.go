@@ -15,6 +15,8 @@
 package model
 
 import (
+	"time"
+
 	"github.com/abichinger/fastac/api"
 	"github.com/abichinger/fastac/model/defs"
 	e "github.com/abichinger/fastac/model/effector"
@@ -28,15 +30,22 @@ import (
 type IModel interface {
 	api.IAddRuleBool
 	api.IRemoveRuleBool
+	api.IUpdateRuleBool
 	api.IRangeRules
 	api.IAddRemoveListener
+	api.IEmitEvent
 
 	GetDef(sec byte, key string) (defs.IDef, bool)
+	RangeDefs(sec byte, fn func(def defs.IDef) bool)
 	SetDef(sec byte, key string, value string) error
 	RemoveDef(sec byte, key string) error
 
 	GetRoleManager(key string) (rbac.IRoleManager, bool)
 	SetRoleManager(key string, rm rbac.IRoleManager)
+	SetRoleProvider(key string, provider rbac.RoleProvider, ttl time.Duration) error
+	SetLinkCondition(key string, condition rbac.LinkCondition) error
+	Validate() []error
+	ValidateRoles() map[string][]error
 
 	GetPolicy(key string) (p.IPolicy, bool)
 	SetPolicy(key string, policy p.IPolicy)
@@ -58,6 +67,18 @@ type IModel interface {
 	BuildMatcherFromDef(mDef *defs.MatcherDef) (matcher.IMatcher, error)
 
 	RangeMatches(matcher matcher.IMatcher, rDef *defs.RequestDef, rvals []interface{}, fn func(rule []string) bool) error
+	RangeMatchesWithSession(matcher matcher.IMatcher, rDef *defs.RequestDef, rvals []interface{}, session rbac.Session, fn func(rule []string) bool) error
+
+	UpdateRules(oldRules, newRules [][]string) error
+	UpdateFilteredRules(matcher matcher.IMatcher, rDef *defs.RequestDef, rvals []interface{}, fn func(rule []string) []string) error
+
+	Snapshot() *Snapshot
+
+	SetExpiry(rule []string, at time.Time) error
+	GetExpiry(rule []string) (time.Time, bool)
+	ClearExpiry(rule []string)
+	NextExpiry() (time.Time, bool)
+	RemoveExpired(now time.Time) ([][]string, error)
 
 	String() string
 }
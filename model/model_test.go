@@ -18,14 +18,17 @@ import (
 	"io/ioutil"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/abichinger/fastac/model/defs"
+	"github.com/abichinger/fastac/rbac"
 	"github.com/abichinger/fastac/util"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestToString(t *testing.T) {
 
-	models := []string{"../examples/basic_model.conf", "../examples/rbac_model.conf", "../examples/multiple_policy_definitions_model.conf"}
+	models := []string{"../examples/basic_model.conf", "../examples/rbac_model.conf", "../examples/multiple_policy_definitions_model.conf", "../examples/rbac_with_pattern_options_model.conf", "../examples/rbac_with_time_model.conf", "../examples/rbac_with_domain_hierarchy_model.conf", "../examples/rbac_with_constraints_model.conf"}
 
 	minify := func(s string) string {
 		s = strings.ReplaceAll(s, " ", "")
@@ -89,3 +92,179 @@ func TestRangeRules(t *testing.T) {
 
 	assert.ElementsMatch(t, util.Join2D(rules, ","), util.Join2D(actualRules, ","))
 }
+
+func TestSnapshot(t *testing.T) {
+	m, err := NewModelFromFile("../examples/rbac_model.conf")
+	assert.NoError(t, err)
+
+	_, _ = m.AddRule([]string{"p", "alice", "data1", "read"})
+	_, _ = m.AddRule([]string{"g", "alice", "admin"})
+	s1 := m.Snapshot()
+
+	_, _ = m.RemoveRule([]string{"g", "alice", "admin"})
+	_, _ = m.AddRule([]string{"p", "bob", "data2", "write"})
+	s2 := m.Snapshot()
+
+	assert.Equal(t, uint64(1), s1.Version())
+	assert.Equal(t, uint64(2), s2.Version())
+	assert.Equal(t, []string{"g,alice,admin", "p,alice,data1,read"}, util.Join2D(s1.Rules(), ","))
+	assert.True(t, s2.Has([]string{"p", "bob", "data2", "write"}))
+
+	//snapshots are not affected by later changes
+	_, _ = m.RemoveRule([]string{"p", "alice", "data1", "read"})
+	assert.Equal(t, 2, s1.Len())
+
+	added, removed := s1.Diff(s2)
+	assert.Equal(t, []string{"p,bob,data2,write"}, util.Join2D(added, ","))
+	assert.Equal(t, []string{"g,alice,admin"}, util.Join2D(removed, ","))
+
+	added, removed = Diff(m, s1)
+	assert.Equal(t, []string{"g,alice,admin", "p,alice,data1,read"}, util.Join2D(added, ","))
+	assert.Equal(t, []string{"p,bob,data2,write"}, util.Join2D(removed, ","))
+}
+
+func TestExpiry(t *testing.T) {
+	m, err := NewModelFromFile("../examples/rbac_model.conf")
+	assert.NoError(t, err)
+
+	removedEvents := [][]string{}
+	m.AddListener(RULE_REMOVED, func(arguments ...interface{}) {
+		removedEvents = append(removedEvents, arguments[0].([]string))
+	})
+
+	now := time.Now()
+	rules := [][]string{
+		{"p", "alice", "data1", "read"},
+		{"p", "bob", "data1", "read"},
+		{"g", "alice", "admin"},
+		{"g", "bob", "admin"},
+	}
+	for i, rule := range rules {
+		_, _ = m.AddRule(rule)
+		assert.NoError(t, m.SetExpiry(rule, now.Add(time.Duration(i)*time.Hour)))
+	}
+	assert.Error(t, m.SetExpiry([]string{"p2", "alice"}, now))
+
+	//the expiry of a removed rule is discarded
+	_, _ = m.RemoveRule([]string{"p", "bob", "data1", "read"})
+	_, ok := m.GetExpiry([]string{"p", "bob", "data1", "read"})
+	assert.False(t, ok)
+
+	//a later expiry replaces the earlier one
+	assert.NoError(t, m.SetExpiry([]string{"p", "alice", "data1", "read"}, now.Add(4*time.Hour)))
+	next, ok := m.NextExpiry()
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(2*time.Hour), next)
+
+	removedEvents = [][]string{}
+	removed, err := m.RemoveExpired(now.Add(3 * time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"g,alice,admin", "g,bob,admin"}, util.Join2D(removed, ","))
+	assert.Equal(t, removed, removedEvents)
+
+	next, _ = m.NextExpiry()
+	assert.Equal(t, now.Add(4*time.Hour), next)
+	m.ClearExpiry([]string{"p", "alice", "data1", "read"})
+	_, ok = m.NextExpiry()
+	assert.False(t, ok)
+}
+
+func TestValidateRoles(t *testing.T) {
+	m, err := NewModelFromFile("../examples/rbac_model.conf")
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	rules := [][]string{
+		{"p", "admin", "data1", "read"},
+		{"g", "alice", "admin"},
+		{"g", "admin", "staff"},
+		{"g", "staff", "admin"},
+		{"g", "bob", "guest"},
+	}
+	for _, rule := range rules {
+		_, _ = m.AddRule(rule)
+	}
+
+	assert.Equal(t, map[string][]error{
+		"g": {
+			&rbac.CycleError{Cycle: []string{"admin", "staff", "admin"}},
+			&rbac.DanglingRoleError{Role: "guest"},
+		},
+	}, m.ValidateRoles())
+
+	_, _ = m.RemoveRule([]string{"g", "staff", "admin"})
+	assert.NoError(t, m.SetDef('g', "g.reject_cycles", "true"))
+	_, err = m.AddRule([]string{"g", "staff", "alice"})
+	assert.IsType(t, &rbac.CycleError{}, err)
+	assert.Error(t, m.SetDef('g', "g.reject_cycles", "maybe"))
+}
+
+func TestValidate(t *testing.T) {
+	m, err := NewModelFromFile("../examples/rbac_with_domains_model.conf")
+	assert.NoError(t, err)
+	assert.Empty(t, m.Validate())
+	_, ok := m.GetRequestDef("r2")
+	assert.False(t, ok)
+	keys := []string{}
+	m.RangeDefs(P_SEC, func(def defs.IDef) bool {
+		keys = append(keys, def.GetKey())
+		return true
+	})
+	assert.Equal(t, []string{"p"}, keys)
+
+	errs := ValidateText(`
+[request_definition]
+r = sub, obj
+
+[policy_definition]
+p = sub, obj, act
+p2 = sub, obj
+
+[role_definition]
+g = _, _, _
+
+[policy_effect]
+e = priority(p.eft) || deny
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p2.obj && r.act == p.act && p.dom == "domain1"
+m2 = unknown(r.sub, p.sub)
+
+[unknown_section]
+x = 1
+`)
+	res := []string{}
+	for _, err := range errs {
+		res = append(res, err.Error())
+	}
+	assert.Equal(t, []string{
+		"error: unknown section unknown_section",
+		"error: unsupported effect e = priority(p.eft)||deny",
+		"error: matcher m references undefined argument p.dom",
+		"error: matcher m references multiple policies p, p2",
+		"error: matcher m references undefined argument r.act",
+		"error: matcher m calls g with 2 arguments, but g = _,_,_ requires 3",
+		"error: invalid matcher m2: Undefined function unknown",
+	}, res)
+
+	errs = ValidateText(`
+[role_definition]
+g = _, _, (valid_until)
+
+[matchers]
+m = g(r.sub, p.sub, r.dom)
+`)
+	res = []string{}
+	for _, err := range errs {
+		res = append(res, err.Error())
+	}
+	assert.Equal(t, []string{
+		"error: missing section request_definition",
+		"error: missing section policy_definition",
+		"error: missing section policy_effect",
+		"error: matcher m references undefined argument p.sub",
+		"error: matcher m references undefined argument r.sub",
+		"error: matcher m references undefined argument r.dom",
+	}, res)
+}
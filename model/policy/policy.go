@@ -56,6 +56,29 @@ func (p *Policy) RemoveRule(rule []string) (bool, error) {
 	return true, nil
 }
 
+// UpdateRule replaces oldRule with newRule
+// Returns false, if oldRule was not present or newRule is already present
+func (p *Policy) UpdateRule(oldRule, newRule []string) (bool, error) {
+	key := util.Hash(oldRule)
+	if _, ok := p.ruleMap[key]; !ok {
+		return false, nil
+	}
+	newKey := util.Hash(newRule)
+	if _, ok := p.ruleMap[newKey]; ok {
+		return false, nil
+	}
+	delete(p.ruleMap, key)
+	p.ruleMap[newKey] = newRule
+	p.Emitter.EmitEvent(EVT_RULE_UPDATED, oldRule, newRule)
+	return true, nil
+}
+
+// HasRule returns true, if rule is present
+func (p *Policy) HasRule(rule []string) bool {
+	_, ok := p.ruleMap[util.Hash(rule)]
+	return ok
+}
+
 func (p *Policy) Range(fn func(rule []string) bool) {
 	for _, r := range p.ruleMap {
 		if !fn(r) {
@@ -9,12 +9,15 @@ import (
 const (
 	EVT_RULE_ADDED   em.EventType = "rule_added"
 	EVT_RULE_REMOVED em.EventType = "rule_removed"
+	EVT_RULE_UPDATED em.EventType = "rule_updated"
 	EVT_CLEARED      em.EventType = "cleared"
 )
 
 type IPolicy interface {
 	api.IAddRuleBool
 	api.IRemoveRuleBool
+	api.IUpdateRuleBool
+	api.IHasRule
 	api.IAddRemoveListener
 	api.IClear
 
@@ -49,3 +49,27 @@ func TestGetDistinct(t *testing.T) {
 	assert.ElementsMatch(t, util.Join2D(objects, ""), []string{"data1", "data2"})
 	assert.ElementsMatch(t, util.Join2D(actions, ""), []string{"read", "write"})
 }
+
+func TestUpdateRule(t *testing.T) {
+	def := defs.NewPolicyDef("p", "sub, obj, act")
+	p := NewPolicy(def)
+	loadTestPolicy(t, p, [][]string{
+		{"alice", "data1", "read"},
+		{"bob", "data2", "write"},
+	})
+
+	updated, _ := p.UpdateRule([]string{"alice", "data1", "read"}, []string{"alice", "data1", "write"})
+	assert.True(t, updated)
+	updated, _ = p.UpdateRule([]string{"alice", "data1", "read"}, []string{"alice", "data1", "write"})
+	assert.False(t, updated)
+	//newRule is already present
+	updated, _ = p.UpdateRule([]string{"bob", "data2", "write"}, []string{"alice", "data1", "write"})
+	assert.False(t, updated)
+
+	rules := [][]string{}
+	p.Range(func(rule []string) bool {
+		rules = append(rules, rule)
+		return true
+	})
+	assert.ElementsMatch(t, []string{"alice,data1,write", "bob,data2,write"}, util.Join2D(rules, ","))
+}
@@ -0,0 +1,142 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"sort"
+	"time"
+
+	"github.com/abichinger/fastac/api"
+	"github.com/abichinger/fastac/util"
+)
+
+// Snapshot is an immutable copy of all rules of a model (policy rules and grouping rules).
+// Snapshots of the same model are numbered consecutively, starting with version 1.
+type Snapshot struct {
+	version uint64
+	created time.Time
+	rules   map[string][]string
+	keys    []string
+}
+
+// Snapshot copies the current rules of the model into a new Snapshot
+//
+//  s := m.Snapshot()
+//  //...
+//  added, removed := m.Snapshot().Diff(s)
+func (m *Model) Snapshot() *Snapshot {
+	m.version++
+	s := &Snapshot{
+		version: m.version,
+		created: time.Now(),
+		rules:   make(map[string][]string),
+	}
+	m.RangeRules(func(rule []string) bool {
+		key := util.Hash(rule)
+		s.rules[key] = append([]string{}, rule...)
+		s.keys = append(s.keys, key)
+		return true
+	})
+	sort.Strings(s.keys)
+	return s
+}
+
+// Version returns the version number of the snapshot
+func (s *Snapshot) Version() uint64 {
+	return s.version
+}
+
+// Created returns the time, when the snapshot was taken
+func (s *Snapshot) Created() time.Time {
+	return s.created
+}
+
+// Len returns the number of rules
+func (s *Snapshot) Len() int {
+	return len(s.keys)
+}
+
+// Has returns true, if the snapshot contains rule
+func (s *Snapshot) Has(rule []string) bool {
+	_, ok := s.rules[util.Hash(rule)]
+	return ok
+}
+
+// RangeRules calls fn for every rule of the snapshot in a stable order.
+// A snapshot can be passed to Adapter.SavePolicy to store a previous version.
+func (s *Snapshot) RangeRules(fn func(rule []string) bool) {
+	for _, key := range s.keys {
+		if !fn(append([]string{}, s.rules[key]...)) {
+			break
+		}
+	}
+}
+
+// Rules returns a copy of all rules
+func (s *Snapshot) Rules() [][]string {
+	rules := make([][]string, 0, len(s.keys))
+	s.RangeRules(func(rule []string) bool {
+		rules = append(rules, rule)
+		return true
+	})
+	return rules
+}
+
+// Diff returns the changes, which turn s into target.
+// added contains the rules, which are only present in target and removed the rules, which are only present in s.
+func (s *Snapshot) Diff(target *Snapshot) (added, removed [][]string) {
+	return Diff(s, target)
+}
+
+// Diff returns the changes, which turn the rules of from into the rules of to.
+// Models and snapshots can be compared in any combination.
+//
+//  added, removed := Diff(m, snapshot)
+func Diff(from, to api.IRangeRules) (added, removed [][]string) {
+	fromSet := ruleSet(from)
+	toSet := ruleSet(to)
+
+	added, removed = [][]string{}, [][]string{}
+	for key, rule := range toSet {
+		if _, ok := fromSet[key]; !ok {
+			added = append(added, rule)
+		}
+	}
+	for key, rule := range fromSet {
+		if _, ok := toSet[key]; !ok {
+			removed = append(removed, rule)
+		}
+	}
+
+	//sort rules to get a stable output
+	sortRules(added)
+	sortRules(removed)
+	return added, removed
+}
+
+func ruleSet(rules api.IRangeRules) map[string][]string {
+	set := make(map[string][]string)
+	rules.RangeRules(func(rule []string) bool {
+		set[util.Hash(rule)] = append([]string{}, rule...)
+		return true
+	})
+	return set
+}
+
+func sortRules(rules [][]string) {
+	sort.Slice(rules, func(i, j int) bool {
+		return util.Hash(rules[i]) < util.Hash(rules[j])
+	})
+}
@@ -0,0 +1,265 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/abichinger/fastac/model/defs"
+	"github.com/abichinger/fastac/model/eft"
+	"github.com/abichinger/fastac/rbac"
+	"github.com/abichinger/fastac/str"
+	"github.com/abichinger/govaluate"
+	"github.com/go-ini/ini"
+)
+
+// ValidateRoles checks the role hierarchies and returns the errors by role definition key.
+// Role managers implementing rbac.IDefaultRoleManager report cycles (*rbac.CycleError) and
+// links exceeding the max hierarchy level (*rbac.DepthError).
+// A role is dangling (*rbac.DanglingRoleError), if it does not inherit other roles and is not used by any rule.
+// Violations of constraints (*rbac.SoDError, *rbac.CardinalityError) are reported as well, e.g. for rules,
+// which were loaded before the constraint was defined.
+//
+//  for key, errs := range m.ValidateRoles() {
+//  	fmt.Println(key, errs)
+//  }
+func (m *Model) ValidateRoles() map[string][]error {
+	used := map[string]bool{}
+	for _, p := range m.pMap {
+		p.Range(func(rule []string) bool {
+			for _, value := range rule {
+				used[value] = true
+			}
+			return true
+		})
+	}
+	for _, rp := range m.rpMap {
+		rp.Range(func(rule []string) bool {
+			used[rule[0]] = true
+			return true
+		})
+	}
+
+	res := map[string][]error{}
+	for key, rp := range m.rpMap {
+		errs := []error{}
+		if drm, ok := rp.GetRoleManager().(rbac.IDefaultRoleManager); ok {
+			errs = append(errs, drm.Validate()...)
+		}
+		errs = append(errs, rp.ValidateConstraints()...)
+
+		dangling := map[string]bool{}
+		rp.Range(func(rule []string) bool {
+			if !used[rule[1]] {
+				dangling[rule[1]] = true
+			}
+			return true
+		})
+		roles := make([]string, 0, len(dangling))
+		for role := range dangling {
+			roles = append(roles, role)
+		}
+		sort.Strings(roles)
+		for _, role := range roles {
+			errs = append(errs, &rbac.DanglingRoleError{Role: role})
+		}
+
+		if len(errs) > 0 {
+			res[key] = errs
+		}
+	}
+	return res
+}
+
+// Validate checks the definitions of the model, which are otherwise only reported at enforce time or not at all:
+// unknown and missing sections, unsupported effects, matchers with unknown functions or undefined arguments,
+// matchers referencing multiple policies and role functions called with the wrong number of arguments.
+//
+//  for _, err := range m.Validate() {
+//  	fmt.Println(err)
+//  }
+func (m *Model) Validate() []error {
+	errs := []error{}
+	for _, name := range m.unknownSections {
+		errs = append(errs, fmt.Errorf(str.ERR_UNKNOWN_SECTION, name))
+	}
+	for _, sec := range []byte{R_SEC, P_SEC, E_SEC, M_SEC} {
+		if len(m.defs[sec]) == 0 {
+			errs = append(errs, fmt.Errorf(str.ERR_MISSING_SECTION, m.secDefs[sec].name))
+		}
+	}
+
+	for _, key := range sortedDefKeys(m.defs[E_SEC]) {
+		eDef := m.defs[E_SEC][key].(*defs.EffectDef)
+		switch eDef.Expr() {
+		case eft.SOME_ALLOW, eft.NO_DENY, eft.SOME_ALLOW_NO_DENY:
+		default:
+			errs = append(errs, fmt.Errorf(str.ERR_UNSUPPORTED_EFFECT, key, eDef.Expr()))
+		}
+	}
+
+	for _, key := range sortedDefKeys(m.defs[M_SEC]) {
+		errs = append(errs, m.validateMatcher(m.defs[M_SEC][key].(*defs.MatcherDef))...)
+	}
+	return errs
+}
+
+// ValidateFile loads the model CONF file and returns all problems of the model.
+// Unlike LoadModel, it does not stop at the first invalid definition. Custom functions are reported as unknown functions.
+func ValidateFile(path string) []error {
+	cfg, err := ini.Load(path)
+	if err != nil {
+		return []error{err}
+	}
+	return validateConfig(cfg)
+}
+
+// ValidateText works like ValidateFile, but loads the model from the text
+func ValidateText(text string) []error {
+	cfg, err := ini.Load([]byte(text))
+	if err != nil {
+		return []error{err}
+	}
+	return validateConfig(cfg)
+}
+
+func validateConfig(cfg *ini.File) []error {
+	m := NewModel()
+	errs := m.loadDefs(cfg, false)
+	return append(errs, m.Validate()...)
+}
+
+func (m *Model) validateMatcher(mDef *defs.MatcherDef) []error {
+	key := mDef.GetKey()
+	def := defs.NewMatcherDef(key, mDef.Expr())
+	if err := def.Build(m.fm.GetFunctions()); err != nil {
+		return []error{fmt.Errorf(str.ERR_INVALID_MATCHER, key, err)}
+	}
+
+	errs := []error{}
+	reported := map[string]bool{}
+	undefined := func(arg string) {
+		if !reported[arg] {
+			reported[arg] = true
+			errs = append(errs, fmt.Errorf(str.ERR_UNDEFINED_ARG, key, strings.Replace(arg, "_", ".", 1)))
+		}
+	}
+
+	policyKeys := []string{}
+	for _, arg := range def.GetPolicyArgs() {
+		pKey := strings.SplitN(arg, "_", 2)[0]
+		if !containsString(policyKeys, pKey) {
+			policyKeys = append(policyKeys, pKey)
+		}
+		if pDef := m.matcherPolicyDef(pKey); pDef == nil || !pDef.Has(arg) {
+			undefined(arg)
+		}
+	}
+	if len(policyKeys) > 1 {
+		errs = append(errs, fmt.Errorf(str.ERR_MIXED_POLICY_KEYS, key, strings.Join(policyKeys, ", ")))
+	}
+
+	for _, arg := range def.GetRequestArgs() {
+		rDef, ok := m.GetRequestDef(strings.SplitN(arg, "_", 2)[0])
+		if !ok || !rDef.Has(arg) {
+			undefined(arg)
+		}
+	}
+
+	expr := defs.ArgReg.ReplaceAllString(mDef.Expr(), "${1}_${3}")
+	parsedExpr, err := govaluate.NewEvaluableExpressionWithFunctions(expr, m.fm.GetFunctions())
+	if err != nil {
+		return append(errs, fmt.Errorf(str.ERR_INVALID_MATCHER, key, err))
+	}
+	tokens := parsedExpr.Tokens()
+	for i, token := range tokens {
+		if token.Kind != govaluate.FUNCTION {
+			continue
+		}
+		name, _ := token.Value2.(string)
+		rDef, ok := m.defs[G_SEC][name].(*defs.RoleDef)
+		if !ok {
+			continue
+		}
+		n := countArgs(tokens[i+1:])
+		switch {
+		case rDef.NParams() == 0 && n != rDef.NArgs():
+			errs = append(errs, fmt.Errorf(str.ERR_ROLE_ARITY, key, name, n, rDef.String(), fmt.Sprint(rDef.NArgs())))
+		case n < rDef.NArgs():
+			errs = append(errs, fmt.Errorf(str.ERR_ROLE_ARITY, key, name, n, rDef.String(), fmt.Sprintf("at least %d", rDef.NArgs())))
+		}
+	}
+	return errs
+}
+
+// matcherPolicyDef returns the policy definition, which is used by matchers for the policy key or nil
+func (m *Model) matcherPolicyDef(pKey string) *defs.PolicyDef {
+	switch pKey[0] {
+	case P_SEC:
+		pDef, _ := m.defs[P_SEC][pKey].(*defs.PolicyDef)
+		return pDef
+	case G_SEC:
+		if rDef, ok := m.defs[G_SEC][pKey].(*defs.RoleDef); ok {
+			return rDef.GetPolicyDef()
+		}
+		return defs.NewPolicyDef(pKey, defs.DefaultRoleArgs)
+	}
+	return nil
+}
+
+// countArgs returns the number of arguments of a function call, tokens start with the opening bracket of the call
+func countArgs(tokens []govaluate.ExpressionToken) int {
+	depth := 0
+	n := 0
+	for i, token := range tokens {
+		switch token.Kind {
+		case govaluate.CLAUSE:
+			depth++
+		case govaluate.CLAUSE_CLOSE:
+			depth--
+			if depth == 0 {
+				if i == 1 {
+					return 0
+				}
+				return n + 1
+			}
+		case govaluate.SEPARATOR:
+			if depth == 1 {
+				n++
+			}
+		}
+	}
+	return n + 1
+}
+
+func sortedDefKeys(defMap map[string]defs.IDef) []string {
+	keys := make([]string, 0, len(defMap))
+	for key := range defMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -16,10 +16,14 @@ package fastac
 
 import (
 	"testing"
+	"time"
 
+	"github.com/abichinger/fastac/model"
 	"github.com/abichinger/fastac/model/fm"
 	"github.com/abichinger/fastac/rbac"
+	"github.com/abichinger/fastac/rbac/directory"
 	"github.com/abichinger/fastac/util"
+	"github.com/stretchr/testify/assert"
 )
 
 func testEnforce(t *testing.T, e *Enforcer, sub interface{}, obj interface{}, act string, res bool) {
@@ -173,6 +177,239 @@ func TestRBACModelWithDomains(t *testing.T) {
 	testDomainEnforce(t, e, "bob", "domain2", "data2", "write", true)
 }
 
+func testTimeEnforce(t *testing.T, e *Enforcer, sub string, obj string, act string, time string, res bool) {
+	t.Helper()
+	if myRes, _ := e.Enforce(sub, obj, act, time); myRes != res {
+		t.Errorf("%s, %s, %s, %s: %t, supposed to be %t", sub, obj, act, time, myRes, res)
+	}
+}
+
+func TestRBACModelWithDomainHierarchy(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_domain_hierarchy_model.conf", "examples/rbac_with_domain_hierarchy_policy.csv")
+
+	// roles granted at org level apply to teams and projects
+	testDomainEnforce(t, e, "alice", "org1", "data1", "read", true)
+	testDomainEnforce(t, e, "alice", "team2", "data1", "write", true)
+	testDomainEnforce(t, e, "alice", "project1", "data1", "read", true)
+	testDomainEnforce(t, e, "alice", "org2", "data1", "read", false)
+
+	// roles do not apply to parent or sibling domains
+	testDomainEnforce(t, e, "bob", "project1", "data2", "read", true)
+	testDomainEnforce(t, e, "bob", "org1", "data2", "read", false)
+	testDomainEnforce(t, e, "bob", "team2", "data2", "read", false)
+	testDomainEnforce(t, e, "carol", "project1", "data3", "write", true)
+	testDomainEnforce(t, e, "carol", "team1", "data3", "write", false)
+
+	// links of different domains are combined
+	testDomainEnforce(t, e, "alice", "project1", "log1", "read", true)
+	testDomainEnforce(t, e, "bob", "project1", "log1", "read", false)
+
+	_, _ = e.RemoveRule([]string{"g2", "project1", "team1"})
+	testDomainEnforce(t, e, "alice", "project1", "data1", "read", false)
+	testDomainEnforce(t, e, "alice", "project1", "log1", "read", false)
+}
+
+func TestRBACModelWithConstraints(t *testing.T) {
+	e, err := NewEnforcer("examples/rbac_with_constraints_model.conf", "examples/rbac_with_constraints_policy.csv")
+	assert.NoError(t, err)
+
+	testDomainEnforce(t, e, "alice", "domain1", "invoice", "create", true)
+	testDomainEnforce(t, e, "bob", "domain1", "invoice", "approve", true)
+
+	_, err = e.AddRule([]string{"g", "alice", "approver", "domain1"})
+	assert.IsType(t, &rbac.SoDError{}, err)
+	_, err = e.AddRule([]string{"g", "erin", "admin", "domain1"})
+	assert.IsType(t, &rbac.CardinalityError{}, err)
+	added, err := e.AddRule([]string{"g", "alice", "approver", "domain2"})
+	assert.True(t, added)
+	assert.NoError(t, err)
+	testDomainEnforce(t, e, "alice", "domain1", "invoice", "approve", false)
+
+	// constraints, which are added later, are reported by ValidateRoles
+	m := e.GetModel()
+	assert.Empty(t, m.ValidateRoles())
+	assert.NoError(t, m.SetDef('c', "c3", "sod(g, admin, approver)"))
+	assert.Equal(t, map[string][]error{
+		"g": {&rbac.SoDError{Constraint: "c3", Domain: []string{"domain2"}, User: "alice", Roles: []string{"admin", "approver"}}},
+	}, m.ValidateRoles())
+
+	_, err = NewEnforcer("examples/rbac_with_constraints_model.conf", "examples/rbac_with_domains_policy.csv")
+	assert.NoError(t, err)
+	assert.Error(t, m.SetDef('c', "c4", "max_users(g, admin, x)"))
+}
+
+func TestRoleProvider(t *testing.T) {
+	server := directory.NewMemoryServer()
+	server.AddUser("uid=alice,ou=people,dc=example,dc=org")
+	server.AddGroup("cn=developers,ou=groups,dc=example,dc=org", "uid=alice,ou=people,dc=example,dc=org")
+
+	e, _ := NewEnforcer("examples/rbac_model.conf", nil)
+	_ = e.AddRules([][]string{
+		{"p", "developers", "repo", "write"},
+		{"p", "bob", "repo", "read"},
+		{"g", "carol", "developers"},
+	})
+	provider := directory.NewProvider(server, directory.Config{BaseDN: "dc=example,dc=org"})
+	assert.NoError(t, e.GetModel().SetRoleProvider("g", provider, time.Minute))
+
+	testEnforce(t, e, "alice", "repo", "write", true)
+	testEnforce(t, e, "carol", "repo", "write", true)
+	testEnforce(t, e, "bob", "repo", "write", false)
+
+	// answers of the directory are cached
+	rm, _ := e.GetModel().GetRoleManager("g")
+	_, _ = rm.HasLink("alice", "developers")
+	searches := server.Searches()
+	ok, _ := rm.HasLink("alice", "developers")
+	assert.True(t, ok)
+	assert.Equal(t, searches, server.Searches())
+
+	// links of the directory are not part of the policy
+	rules := [][]string{}
+	e.GetModel().RangeRules(func(rule []string) bool {
+		if rule[0] == "g" {
+			rules = append(rules, rule)
+		}
+		return true
+	})
+	assert.Equal(t, [][]string{{"g", "carol", "developers"}}, rules)
+}
+
+func TestRoleProviderKeepsRoleManager(t *testing.T) {
+	server := directory.NewMemoryServer()
+	server.AddUser("uid=alice,ou=people,dc=example,dc=org")
+	server.AddGroup("cn=developers,ou=groups,dc=example,dc=org", "uid=alice,ou=people,dc=example,dc=org")
+
+	m := model.NewModel()
+	assert.NoError(t, m.LoadModelFromText(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+m2 = g.role == "developers"
+`))
+	e, _ := NewEnforcer(m, nil)
+	_, _ = e.AddRule([]string{"p", "developers", "repo", "write"})
+	rm, _ := e.GetModel().GetRoleManager("g")
+	links := []string{}
+	rm.(rbac.IRoleEventEmitter).AddListener(rbac.EVT_LINK_ADDED, func(arguments ...interface{}) {
+		links = append(links, arguments[0].(*rbac.RoleEvent).Name1)
+	})
+
+	provider := directory.NewProvider(server, directory.Config{BaseDN: "dc=example,dc=org"})
+	assert.NoError(t, e.GetModel().SetRoleProvider("g", provider, time.Minute))
+	_, _ = e.AddRule([]string{"g", "carol", "developers"})
+
+	// listeners of the role manager and matchers of the role definition see new rules
+	assert.Equal(t, []string{"carol"}, links)
+	rules, err := e.Filter(SetMatcher("m2"))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"g", "carol", "developers"}}, rules)
+	testEnforce(t, e, "alice", "repo", "write", true)
+	testEnforce(t, e, "carol", "repo", "write", true)
+
+	// a custom role manager does not manage local links for a provider
+	e.GetModel().SetRoleManager("g", &rbac.ConditionalRoleManager{})
+	assert.EqualError(t, e.GetModel().SetRoleProvider("g", provider, time.Minute), "error: role manager g does not support role providers")
+}
+
+func TestRBACModelWithSession(t *testing.T) {
+	m := model.NewModel()
+	assert.NoError(t, m.LoadModelFromText(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+
+[constraint_definition]
+c = dsd(g, approver, requester)
+`))
+	e, _ := NewEnforcer(m, nil)
+	_ = e.AddRules([][]string{
+		{"p", "requester", "invoice", "create"},
+		{"p", "approver", "invoice", "approve"},
+		{"p", "alice", "profile", "edit"},
+		{"g", "alice", "requester"},
+		{"g", "alice", "approver"},
+	})
+
+	// without a session all assigned roles are active
+	testEnforce(t, e, "alice", "invoice", "create", true)
+	testEnforce(t, e, "alice", "invoice", "approve", true)
+
+	session := ActivateRoles("g", "requester")
+	for _, test := range []struct {
+		obj, act string
+		res      bool
+	}{
+		{"invoice", "create", true},
+		{"invoice", "approve", false},
+		{"profile", "edit", true},
+	} {
+		res, err := e.Enforce("alice", test.obj, test.act, session)
+		assert.NoError(t, err)
+		assert.Equal(t, test.res, res, "%s, %s", test.obj, test.act)
+	}
+
+	_, err := e.Enforce("alice", "invoice", "create", ActivateRoles("g", "requester", "approver"))
+	assert.IsType(t, &rbac.SessionError{}, err)
+	_, err = e.Enforce("alice", "invoice", "create", ActivateRoles("g2", "requester"))
+	assert.Error(t, err)
+}
+
+func TestRBACModelWithTime(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_time_model.conf", "examples/rbac_with_time_policy.csv")
+
+	testTimeEnforce(t, e, "alice", "prod", "write", "2022-05-31T23:59:59Z", false)
+	testTimeEnforce(t, e, "alice", "prod", "write", "2022-06-01T00:00:00Z", true)
+	testTimeEnforce(t, e, "alice", "prod", "write", "2022-06-08T00:00:00Z", false)
+	testTimeEnforce(t, e, "alice", "staging", "write", "2022-06-04T00:00:00Z", false)
+	testTimeEnforce(t, e, "alice", "staging", "write", "2030-01-01T00:00:00Z", true)
+	testTimeEnforce(t, e, "bob", "prod", "write", "2022-05-01T00:00:00Z", true)
+	testTimeEnforce(t, e, "bob", "prod", "write", "2022-06-01T00:00:00Z", false)
+	testTimeEnforce(t, e, "carol", "staging", "write", "2022-06-01T00:00:00Z", true)
+	testTimeEnforce(t, e, "dave", "prod", "write", "2022-06-01T12:00:00Z", true)
+	testTimeEnforce(t, e, "dave", "prod", "write", "2022-06-02T12:00:00Z", false)
+
+	//the link parameters are part of the rule
+	rules, _ := e.Filter(SetMatcher("g.user == \"alice\""))
+	assert.ElementsMatch(t, []string{"g,alice,oncall,2022-06-01T00:00:00Z,2022-06-08T00:00:00Z", "g,alice,dev,2022-06-05T00:00:00Z,_"}, util.Join2D(rules, ","))
+
+	rules, _ = e.Filter(SetMatcher("g.valid_until == \"_\""))
+	assert.Equal(t, []string{"g,alice,dev,2022-06-05T00:00:00Z,_"}, util.Join2D(rules, ","))
+	rules, _ = e.Filter(SetMatcher("g.role == \"dev\" && g.valid_from == \"\""))
+	assert.Equal(t, []string{"g,carol,dev"}, util.Join2D(rules, ","))
+
+	_, _ = e.RemoveRule([]string{"g", "alice", "dev", "2022-06-05T00:00:00Z", "_"})
+	testTimeEnforce(t, e, "alice", "staging", "write", "2030-01-01T00:00:00Z", false)
+
+	assert.NoError(t, e.GetModel().SetLinkCondition("g", func(params []string, args ...interface{}) (bool, error) {
+		return false, nil
+	}))
+	testTimeEnforce(t, e, "alice", "prod", "write", "2022-06-01T00:00:00Z", false)
+	testTimeEnforce(t, e, "carol", "staging", "write", "2022-06-01T00:00:00Z", true)
+}
+
 func TestRBACModelWithDomainsAtRuntime(t *testing.T) {
 	e, _ := NewEnforcer("examples/rbac_with_domains_model.conf", nil)
 
@@ -641,3 +878,63 @@ func TestAllMatchModel(t *testing.T) {
 	testDomainEnforce(t, e, "alice", "domain2", "/book/1", "read", false)
 	testDomainEnforce(t, e, "alice", "domain2", "/book/1", "write", true)
 }
+
+func TestAllMatchModelFromConfig(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_pattern_options_model.conf", "examples/rbac_with_all_pattern_policy.csv")
+
+	testDomainEnforce(t, e, "alice", "domain1", "/book/1", "read", true)
+	testDomainEnforce(t, e, "alice", "domain1", "/book/1", "write", false)
+	testDomainEnforce(t, e, "alice", "domain2", "/book/1", "read", false)
+	testDomainEnforce(t, e, "alice", "domain2", "/book/1", "write", true)
+}
+
+func TestRoleOptions(t *testing.T) {
+	m := model.NewModel()
+	assert.NoError(t, m.LoadModelFromText(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g.max_depth = 2
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`))
+	e, _ := NewEnforcer(m, nil)
+	_ = e.AddRules([][]string{
+		{"p", "r1", "data1", "read"},
+		{"p", "r2", "data2", "read"},
+		{"g", "alice", "r1"},
+		{"g", "r1", "r2"},
+	})
+
+	testEnforce(t, e, "alice", "data1", "read", true)
+	testEnforce(t, e, "alice", "data2", "read", false)
+
+	assert.NoError(t, m.RemoveDef('g', "g.max_depth"))
+	testEnforce(t, e, "alice", "data2", "read", true)
+
+	assert.NoError(t, m.SetDef('g', "g.closure_index", "true"))
+	testEnforce(t, e, "alice", "data2", "read", true)
+	_, _ = e.RemoveRule([]string{"g", "r1", "r2"})
+	testEnforce(t, e, "alice", "data2", "read", false)
+
+	assert.Error(t, m.SetDef('g', "g.depth", "2"))
+	assert.Error(t, m.SetDef('g', "g.max_depth", "-1"))
+	assert.Error(t, m.SetDef('g', "g.role_matcher", "unknownMatch"))
+
+	// options declared before the role definition are applied in the order of their keys
+	for i := 0; i < 10; i++ {
+		m = model.NewModel()
+		assert.NoError(t, m.SetDef('g', "g.role_matcher", "unknownMatch"))
+		assert.NoError(t, m.SetDef('g', "g.max_depth", "x"))
+		assert.EqualError(t, m.SetDef('g', "g", "_, _"), "error: invalid value x of role option max_depth")
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fastac
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/abichinger/fastac/str"
+	"gopkg.in/yaml.v3"
+)
+
+// PolicyTest is a request and the expected decision. Request values and rules are written as comma separated values or as lists.
+//
+//  tests:
+//    - name: alice reads data1
+//      request: alice, data1, read
+//      expect: allow
+//      rule: p, alice, data1, read
+//    - request: [bob, data1, read]
+//      expect: deny
+//      matcher: m2
+type PolicyTest struct {
+	Name    string
+	Request []string
+	// Allow is the expected decision
+	Allow bool
+	// Rule is the expected deciding rule, nil if the rule is not checked and empty if no rule is expected to decide
+	Rule []string
+	// Matcher and RequestDef are the keys of the matcher and request definition (default: m, r)
+	Matcher    string
+	RequestDef string
+}
+
+// PolicyTestResult is the outcome of a PolicyTest
+type PolicyTestResult struct {
+	Test  *PolicyTest
+	Allow bool
+	Rule  []string
+	Err   error
+}
+
+// Passed reports whether the decision and the deciding rule match the expectations of the test
+func (r *PolicyTestResult) Passed() bool {
+	if r.Err != nil || r.Allow != r.Test.Allow {
+		return false
+	}
+	return r.Test.Rule == nil || strings.Join(r.Rule, ", ") == strings.Join(r.Test.Rule, ", ")
+}
+
+// Diff returns the expected and actual outcome of a failed test, the lines of the expectations start with - and the actual lines with +
+//
+//  FAIL alice writes data2 (alice, data2, write)
+//  - allow
+//  + deny
+//  - rule: p, data2_admin, data2, write, allow
+//  + rule: p, alice, data2, write, deny
+func (r *PolicyTestResult) Diff() string {
+	lines := []string{fmt.Sprintf("FAIL %s (%s)", r.Test.Name, strings.Join(r.Test.Request, ", "))}
+	if r.Err != nil {
+		return strings.Join(append(lines, "  "+r.Err.Error()), "\n")
+	}
+	if r.Allow != r.Test.Allow {
+		lines = append(lines, "- "+formatDecision(r.Test.Allow), "+ "+formatDecision(r.Allow))
+	}
+	if expected, actual := formatRule(r.Test.Rule), formatRule(r.Rule); r.Test.Rule != nil && expected != actual {
+		lines = append(lines, "- rule: "+expected, "+ rule: "+actual)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatDecision(allow bool) string {
+	if allow {
+		return "allow"
+	}
+	return "deny"
+}
+
+func formatRule(rule []string) string {
+	if len(rule) == 0 {
+		return "none"
+	}
+	return strings.Join(rule, ", ")
+}
+
+// PolicyTestReport contains the results of all tests in the order of the test file
+type PolicyTestReport struct {
+	Results []*PolicyTestResult
+}
+
+// Failed returns the results of the failed tests
+func (r *PolicyTestReport) Failed() []*PolicyTestResult {
+	failed := []*PolicyTestResult{}
+	for _, result := range r.Results {
+		if !result.Passed() {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// String returns the diffs of the failed tests and a summary
+func (r *PolicyTestReport) String() string {
+	res := ""
+	failed := r.Failed()
+	for _, result := range failed {
+		res += result.Diff() + "\n"
+	}
+	return res + fmt.Sprintf("%d passed, %d failed", len(r.Results)-len(failed), len(failed))
+}
+
+type policyTestDoc struct {
+	Tests []struct {
+		Name       string    `yaml:"name"`
+		Request    yaml.Node `yaml:"request"`
+		Expect     string    `yaml:"expect"`
+		Rule       yaml.Node `yaml:"rule"`
+		Matcher    string    `yaml:"matcher"`
+		RequestDef string    `yaml:"request_definition"`
+	} `yaml:"tests"`
+}
+
+// LoadPolicyTests reads the tests of a YAML or JSON file
+func LoadPolicyTests(path string) ([]*PolicyTest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc := &policyTestDoc{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+
+	tests := []*PolicyTest{}
+	for i, t := range doc.Tests {
+		test := &PolicyTest{Name: t.Name, Matcher: t.Matcher, RequestDef: t.RequestDef}
+		if test.Name == "" {
+			test.Name = fmt.Sprintf("#%d", i+1)
+		}
+		switch t.Expect {
+		case "allow":
+			test.Allow = true
+		case "deny":
+		default:
+			return nil, fmt.Errorf(str.ERR_INVALID_EXPECTATION, t.Expect, test.Name)
+		}
+		if test.Request, err = decodeValues(&t.Request); err != nil {
+			return nil, err
+		}
+		if t.Rule.Kind != 0 {
+			if test.Rule, err = decodeValues(&t.Rule); err != nil {
+				return nil, err
+			}
+		}
+		tests = append(tests, test)
+	}
+	return tests, nil
+}
+
+// decodeValues decodes comma separated values or a list of values
+func decodeValues(node *yaml.Node) ([]string, error) {
+	values := []string{}
+	if node.Kind != yaml.ScalarNode {
+		err := node.Decode(&values)
+		return values, err
+	}
+	if strings.TrimSpace(node.Value) == "" {
+		return values, nil
+	}
+	for _, value := range strings.Split(node.Value, ",") {
+		values = append(values, strings.TrimSpace(value))
+	}
+	return values, nil
+}
+
+// RunPolicyTests enforces the requests of the test file and compares the decisions with the expectations.
+// The returned error is only set, if the test file can not be loaded.
+//
+//  report, err := fastac.RunPolicyTests(e, "policy_tests.yaml")
+//  if err == nil && len(report.Failed()) > 0 {
+//  	fmt.Println(report)
+//  }
+func RunPolicyTests(e *Enforcer, path string) (*PolicyTestReport, error) {
+	tests, err := LoadPolicyTests(path)
+	if err != nil {
+		return nil, err
+	}
+	report := &PolicyTestReport{Results: []*PolicyTestResult{}}
+	for _, test := range tests {
+		report.Results = append(report.Results, e.runPolicyTest(test))
+	}
+	return report, nil
+}
+
+func (e *Enforcer) runPolicyTest(test *PolicyTest) *PolicyTestResult {
+	result := &PolicyTestResult{Test: test, Rule: []string{}}
+	params := []interface{}{SetMatcher(test.Matcher), SetRequestDef(test.RequestDef)}
+	for _, value := range test.Request {
+		params = append(params, value)
+	}
+	result.Allow, result.Rule, result.Err = e.EnforceEx(params...)
+	return result
+}
@@ -0,0 +1,159 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rbac
+
+import (
+	"strings"
+	"sync"
+)
+
+// closureIndex memoises the transitive closure of users.
+// Without a role matcher, the closure of a user is the set of roles reachable within the max hierarchy level,
+// a change of the link name1 -> name2 only invalidates the closures containing name1.
+// With a role matcher, a new role can match any pattern, therefore the results of HasLink are memoised and
+// all of them are invalidated on change.
+type closureIndex struct {
+	mu       sync.RWMutex
+	version  uint64
+	closures map[string]map[string]struct{}
+	// users contains for every role the users, whose closure contains the role
+	users   map[string]map[string]struct{}
+	results map[string]bool
+}
+
+func newClosureIndex() *closureIndex {
+	return &closureIndex{
+		closures: map[string]map[string]struct{}{},
+		users:    map[string]map[string]struct{}{},
+		results:  map[string]bool{},
+	}
+}
+
+func resultKey(name1, name2 string) string {
+	return strings.Join([]string{name1, name2}, "$$")
+}
+
+func (idx *closureIndex) getClosure(name string) (closure map[string]struct{}, version uint64, ok bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	closure, ok = idx.closures[name]
+	return closure, idx.version, ok
+}
+
+// putClosure stores a closure, unless the index changed after version
+func (idx *closureIndex) putClosure(name string, closure map[string]struct{}, version uint64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.version != version {
+		return
+	}
+	idx.closures[name] = closure
+	for role := range closure {
+		users, ok := idx.users[role]
+		if !ok {
+			users = map[string]struct{}{}
+			idx.users[role] = users
+		}
+		users[name] = struct{}{}
+	}
+}
+
+func (idx *closureIndex) getResult(name1, name2 string) (res bool, version uint64, ok bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	res, ok = idx.results[resultKey(name1, name2)]
+	return res, idx.version, ok
+}
+
+// putResult stores the result of HasLink, unless the index changed after version
+func (idx *closureIndex) putResult(name1, name2 string, res bool, version uint64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.version == version {
+		idx.results[resultKey(name1, name2)] = res
+	}
+}
+
+// invalidate removes the closures, which are affected by a change of the roles of name
+func (idx *closureIndex) invalidate(name string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.version++
+	for user := range idx.users[name] {
+		for role := range idx.closures[user] {
+			delete(idx.users[role], user)
+			if len(idx.users[role]) == 0 {
+				delete(idx.users, role)
+			}
+		}
+		delete(idx.closures, user)
+	}
+	idx.results = map[string]bool{}
+}
+
+func (idx *closureIndex) reset() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.version++
+	idx.closures = map[string]map[string]struct{}{}
+	idx.users = map[string]map[string]struct{}{}
+	idx.results = map[string]bool{}
+}
+
+// closure collects the roles of user, which are reachable within the max hierarchy level
+func (rm *RoleManager) closure(user *Role) map[string]struct{} {
+	closure := map[string]struct{}{user.name: {}}
+	current := []*Role{user}
+	for level := 1; level < rm.maxHierarchyLevel && len(current) > 0; level++ {
+		next := []*Role{}
+		for _, role := range current {
+			role.rangeRoles(func(key, value interface{}) bool {
+				name := key.(string)
+				if _, ok := closure[name]; !ok {
+					closure[name] = struct{}{}
+					next = append(next, value.(*Role))
+				}
+				return true
+			})
+		}
+		current = next
+	}
+	return closure
+}
+
+// hasIndexedLink answers HasLink with the closure index
+func (rm *RoleManager) hasIndexedLink(name1 string, name2 string) bool {
+	idx := rm.index
+	if rm.matcher != nil {
+		res, version, ok := idx.getResult(name1, name2)
+		if !ok {
+			res = rm.hasLink(name1, name2)
+			idx.putResult(name1, name2, res, version)
+		}
+		return res
+	}
+
+	closure, version, ok := idx.getClosure(name1)
+	if !ok {
+		user, exists := rm.load(name1)
+		if !exists {
+			return false
+		}
+		closure = rm.closure(user)
+		idx.putClosure(name1, closure, version)
+	}
+	_, ok = closure[name2]
+	return ok
+}
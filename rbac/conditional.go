@@ -0,0 +1,287 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rbac
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/abichinger/fastac/str"
+	"github.com/abichinger/fastac/util"
+	em "github.com/vansante/go-event-emitter"
+)
+
+// DefaultParam marks an unset link parameter
+const DefaultParam = "_"
+
+// LinkCondition decides whether a link with parameters can be followed.
+// params are the extra columns of the link and args are the request values passed to the g function.
+type LinkCondition func(params []string, args ...interface{}) (bool, error)
+
+// TimeWindow is a LinkCondition for links with the parameters valid_from and valid_until (RFC 3339).
+// An empty parameter or "_" leaves the window open on that side.
+// The time is taken from the first argument (time.Time, RFC 3339 string or unix seconds), time.Now() is used without arguments.
+//
+//  [role_definition]
+//  g = _, _, (valid_from, valid_until)
+//  [matchers]
+//  m = g(r.sub, p.sub, r.time) && r.obj == p.obj && r.act == p.act
+//
+//  g, alice, oncall, 2022-06-01T00:00:00Z, 2022-06-08T00:00:00Z
+func TimeWindow(params []string, args ...interface{}) (bool, error) {
+	now := time.Now()
+	if len(args) > 0 {
+		t, err := toTime(args[0])
+		if err != nil {
+			return false, err
+		}
+		now = t
+	}
+
+	bounds := make([]*time.Time, 2)
+	for i := 0; i < len(params) && i < 2; i++ {
+		if params[i] == "" || params[i] == DefaultParam {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, params[i])
+		if err != nil {
+			return false, fmt.Errorf(str.ERR_INVALID_TIME, params[i])
+		}
+		bounds[i] = &t
+	}
+
+	if bounds[0] != nil && now.Before(*bounds[0]) {
+		return false, nil
+	}
+	if bounds[1] != nil && !now.Before(*bounds[1]) {
+		return false, nil
+	}
+	return true, nil
+}
+
+func toTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		parsed, err := time.Parse(time.RFC3339, t)
+		if err != nil {
+			return time.Time{}, fmt.Errorf(str.ERR_INVALID_TIME, t)
+		}
+		return parsed, nil
+	case float64:
+		return time.Unix(int64(t), 0), nil
+	case int64:
+		return time.Unix(t, 0), nil
+	case int:
+		return time.Unix(int64(t), 0), nil
+	default:
+		return time.Time{}, fmt.Errorf(str.ERR_INVALID_TIME, v)
+	}
+}
+
+// ConditionalRoleManager wraps a role manager and adds parameters to links.
+// The parameters are the columns following the domains of a rule, they are evaluated by a LinkCondition at HasLink time.
+// Links are identified by their names and domains, adding an existing link with other parameters is rejected.
+type ConditionalRoleManager struct {
+	IRoleManager
+	nDomains          int
+	maxHierarchyLevel int
+	condition         LinkCondition
+	params            *sync.Map
+}
+
+var _ IDefaultRoleManager = &ConditionalRoleManager{}
+
+// NewConditionalRoleManager creates a ConditionalRoleManager, nDomains is the number of domain columns of rm
+func NewConditionalRoleManager(rm IRoleManager, nDomains int, maxHierarchyLevel int, condition LinkCondition) *ConditionalRoleManager {
+	return &ConditionalRoleManager{
+		IRoleManager:      rm,
+		nDomains:          nDomains,
+		maxHierarchyLevel: maxHierarchyLevel,
+		condition:         condition,
+		params:            &sync.Map{},
+	}
+}
+
+// split separates the domains from the link parameters
+func (crm *ConditionalRoleManager) split(values []string) (domains []string, params []string) {
+	n := crm.nDomains
+	if len(values) < n {
+		n = len(values)
+	}
+	return values[:n], values[n:]
+}
+
+// DomainCount returns the number of domain columns
+func (crm *ConditionalRoleManager) DomainCount() int {
+	return crm.nDomains
+}
+
+// SetCondition sets the function, which evaluates the link parameters
+func (crm *ConditionalRoleManager) SetCondition(condition LinkCondition) {
+	crm.condition = condition
+}
+
+// SetMatcher sets the role pattern matcher of the wrapped role manager
+func (crm *ConditionalRoleManager) SetMatcher(matcher util.IMatcher) {
+	if rm, ok := crm.IRoleManager.(IDefaultRoleManager); ok {
+		rm.SetMatcher(matcher)
+	}
+}
+
+// SetDomainMatcher sets the domain pattern matcher of the wrapped role manager
+func (crm *ConditionalRoleManager) SetDomainMatcher(matcher util.IMatcher) {
+	if rm, ok := crm.IRoleManager.(IDefaultRoleManager); ok {
+		rm.SetDomainMatcher(matcher)
+	}
+}
+
+// SetMaxHierarchyLevel sets the maximum depth of role inheritance
+func (crm *ConditionalRoleManager) SetMaxHierarchyLevel(level int) {
+	crm.maxHierarchyLevel = level
+	if rm, ok := crm.IRoleManager.(IDefaultRoleManager); ok {
+		rm.SetMaxHierarchyLevel(level)
+	}
+}
+
+// SetRejectCycles enables cycle detection of the wrapped role manager
+func (crm *ConditionalRoleManager) SetRejectCycles(reject bool) {
+	if rm, ok := crm.IRoleManager.(IDefaultRoleManager); ok {
+		rm.SetRejectCycles(reject)
+	}
+}
+
+// SetDomainHierarchy sets the domain hierarchy of the wrapped role manager
+func (crm *ConditionalRoleManager) SetDomainHierarchy(hierarchy IRoleManager) {
+	if rm, ok := crm.IRoleManager.(IDomainHierarchyManager); ok {
+		rm.SetDomainHierarchy(hierarchy)
+	}
+}
+
+// SetClosureIndex enables the memoised transitive closure of the wrapped role manager
+func (crm *ConditionalRoleManager) SetClosureIndex(enabled bool) {
+	if rm, ok := crm.IRoleManager.(IDefaultRoleManager); ok {
+		rm.SetClosureIndex(enabled)
+	}
+}
+
+// Validate validates the wrapped role manager, link conditions are not considered
+func (crm *ConditionalRoleManager) Validate() []error {
+	if rm, ok := crm.IRoleManager.(IDefaultRoleManager); ok {
+		return rm.Validate()
+	}
+	return []error{}
+}
+
+// AddListener adds a listener to the wrapped role manager, the events do not contain link parameters
+func (crm *ConditionalRoleManager) AddListener(event em.EventType, handler em.HandleFunc) *em.Listener {
+	if rm, ok := crm.IRoleManager.(IRoleEventEmitter); ok {
+		return rm.AddListener(event, handler)
+	}
+	return nil
+}
+
+func (crm *ConditionalRoleManager) RemoveListener(event em.EventType, listener *em.Listener) {
+	if rm, ok := crm.IRoleManager.(IRoleEventEmitter); ok {
+		rm.RemoveListener(event, listener)
+	}
+}
+
+func (crm *ConditionalRoleManager) Clear() error {
+	crm.params = &sync.Map{}
+	return crm.IRoleManager.Clear()
+}
+
+// AddLink adds a link, the values following the domains are stored as link parameters.
+// Returns false, if the link is already present, even if the parameters differ.
+// The parameters of a link are changed by RolePolicy.UpdateRule.
+func (crm *ConditionalRoleManager) AddLink(name1 string, name2 string, values ...string) (bool, error) {
+	domains, params := crm.split(values)
+	key := linkKey(name1, name2, domains)
+
+	//the parameters are stored first, so the link is never visible without its condition
+	stored := false
+	if len(params) > 0 {
+		if _, loaded := crm.params.LoadOrStore(key, append([]string{}, params...)); loaded {
+			return false, nil
+		}
+		stored = true
+	}
+
+	added, err := crm.IRoleManager.AddLink(name1, name2, domains...)
+	if (!added || err != nil) && stored {
+		crm.params.Delete(key)
+	}
+	return added, err
+}
+
+// DeleteLink deletes a link and its parameters
+func (crm *ConditionalRoleManager) DeleteLink(name1 string, name2 string, values ...string) (bool, error) {
+	domains, _ := crm.split(values)
+	removed, err := crm.IRoleManager.DeleteLink(name1, name2, domains...)
+	if removed {
+		crm.params.Delete(linkKey(name1, name2, domains))
+	}
+	return removed, err
+}
+
+// HasLink determines whether name1 inherits name2, link conditions are evaluated without request arguments
+func (crm *ConditionalRoleManager) HasLink(name1 string, name2 string, domains ...string) (bool, error) {
+	return crm.HasConditionalLink(name1, name2, domains)
+}
+
+// HasConditionalLink determines whether name1 inherits name2.
+// Links with parameters are only followed, if the condition holds for args.
+func (crm *ConditionalRoleManager) HasConditionalLink(name1 string, name2 string, domains []string, args ...interface{}) (bool, error) {
+	//conditions can only remove links
+	ok, err := crm.IRoleManager.HasLink(name1, name2, domains...)
+	if !ok || err != nil || !crm.hasParams() {
+		return ok, err
+	}
+	return hasLinkWhere(crm.IRoleManager, name1, name2, domains, crm.maxHierarchyLevel, func(l link) (bool, error) {
+		return crm.checkLink(l, args)
+	})
+}
+
+func (crm *ConditionalRoleManager) hasParams() bool {
+	found := false
+	crm.params.Range(func(_, _ interface{}) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+// checkLink determines whether a stored link can be followed, links without parameters are always followed
+func (crm *ConditionalRoleManager) checkLink(l link, args []interface{}) (bool, error) {
+	params, ok := crm.params.Load(linkKey(l.name1, l.name2, l.domains))
+	if !ok || crm.condition == nil {
+		return true, nil
+	}
+	return crm.condition(params.([]string), args...)
+}
+
+// Range calls fn for every link, the link parameters follow the domains
+func (crm *ConditionalRoleManager) Range(fn func(name1, name2 string, values ...string) bool) {
+	crm.IRoleManager.Range(func(name1, name2 string, domains ...string) bool {
+		values := append([]string{}, domains...)
+		if params, ok := crm.params.Load(linkKey(name1, name2, domains)); ok {
+			values = append(values, params.([]string)...)
+		}
+		return fn(name1, name2, values...)
+	})
+}
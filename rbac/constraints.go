@@ -0,0 +1,219 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rbac
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/abichinger/fastac/str"
+	"github.com/abichinger/fastac/util"
+)
+
+// Constraint restricts the links of a role manager
+type Constraint interface {
+	// CheckLink returns an error, if the link name1 -> name2 violates the constraint
+	CheckLink(rm IRoleManager, name1 string, name2 string, domains ...string) error
+	// Validate returns an error for every violation of the constraint
+	Validate(rm IRoleManager) []error
+}
+
+// SoDError is returned, if a user would hold more than one role of a SeparationOfDuty constraint
+type SoDError struct {
+	Constraint string
+	Domain     []string
+	User       string
+	// Roles contains the conflicting roles held by User
+	Roles []string
+}
+
+func (e *SoDError) Error() string {
+	return inDomain(fmt.Sprintf(str.ERR_SOD_VIOLATION, e.Constraint, e.User, strings.Join(e.Roles, ", ")), e.Domain)
+}
+
+// CardinalityError is returned, if a role would have more users than allowed by a RoleCardinality constraint
+type CardinalityError struct {
+	Constraint string
+	Domain     []string
+	Role       string
+	Max        int
+}
+
+func (e *CardinalityError) Error() string {
+	return inDomain(fmt.Sprintf(str.ERR_CARDINALITY_VIOLATION, e.Constraint, e.Role, e.Max), e.Domain)
+}
+
+// SeparationOfDuty is a static separation of duty constraint, no user may hold more than one of the roles.
+// Roles held through inheritance are considered, link conditions are ignored.
+//
+//  [constraint_definition]
+//  c = sod(g, approver, requester)
+type SeparationOfDuty struct {
+	Name  string
+	Roles []string
+}
+
+func NewSeparationOfDuty(name string, roles ...string) *SeparationOfDuty {
+	return &SeparationOfDuty{Name: name, Roles: roles}
+}
+
+// heldRoles returns the roles of the constraint, which are held by name
+func (c *SeparationOfDuty) heldRoles(rm IRoleManager, name string, domains []string) []string {
+	held := []string{}
+	for _, role := range c.Roles {
+		if ok, _ := rm.HasLink(name, role, domains...); ok {
+			held = append(held, role)
+		}
+	}
+	return held
+}
+
+func (c *SeparationOfDuty) CheckLink(rm IRoleManager, name1 string, name2 string, domains ...string) error {
+	rm, domains = unwrapConditional(rm, domains)
+	gained := c.heldRoles(rm, name2, domains)
+	if len(gained) == 0 {
+		return nil
+	}
+
+	//name1 and all users inheriting name1 gain the roles of name2
+	users := []string{name1}
+	visited := map[string]bool{name1: true}
+	for i := 0; i < len(users); i++ {
+		inheriting, _ := rm.GetUsers(users[i], domains...)
+		for _, user := range inheriting {
+			if !visited[user] {
+				visited[user] = true
+				users = append(users, user)
+			}
+		}
+	}
+
+	for _, user := range users {
+		held := c.heldRoles(rm, user, domains)
+		for _, role := range gained {
+			if !contains(held, role) {
+				held = append(held, role)
+			}
+		}
+		if len(held) > 1 {
+			sort.Strings(held)
+			return &SoDError{Constraint: c.Name, Domain: domains, User: user, Roles: held}
+		}
+	}
+	return nil
+}
+
+func (c *SeparationOfDuty) Validate(rm IRoleManager) []error {
+	rm, _ = unwrapConditional(rm, nil)
+	errs := []error{}
+	rangeDomainNames(rm, func(domain []string, names []string) {
+		for _, name := range names {
+			if held := c.heldRoles(rm, name, domain); len(held) > 1 {
+				sort.Strings(held)
+				errs = append(errs, &SoDError{Constraint: c.Name, Domain: domain, User: name, Roles: held})
+			}
+		}
+	})
+	return errs
+}
+
+// RoleCardinality limits the number of users, which are directly assigned to a role (per domain).
+//
+//  [constraint_definition]
+//  c = max_users(g, admin, 3)
+type RoleCardinality struct {
+	Name string
+	Role string
+	Max  int
+}
+
+func NewRoleCardinality(name string, role string, max int) *RoleCardinality {
+	return &RoleCardinality{Name: name, Role: role, Max: max}
+}
+
+func (c *RoleCardinality) CheckLink(rm IRoleManager, name1 string, name2 string, domains ...string) error {
+	if name2 != c.Role {
+		return nil
+	}
+	rm, domains = unwrapConditional(rm, domains)
+	users, err := rm.GetUsers(c.Role, domains...)
+	if err != nil {
+		return err
+	}
+	if !contains(users, name1) && len(users) >= c.Max {
+		return &CardinalityError{Constraint: c.Name, Domain: domains, Role: c.Role, Max: c.Max}
+	}
+	return nil
+}
+
+func (c *RoleCardinality) Validate(rm IRoleManager) []error {
+	rm, _ = unwrapConditional(rm, nil)
+	errs := []error{}
+	rangeDomainNames(rm, func(domain []string, _ []string) {
+		if users, _ := rm.GetUsers(c.Role, domain...); len(users) > c.Max {
+			errs = append(errs, &CardinalityError{Constraint: c.Name, Domain: domain, Role: c.Role, Max: c.Max})
+		}
+	})
+	return errs
+}
+
+// unwrapConditional returns the role manager wrapped by a ConditionalRoleManager and strips the link parameters from values
+func unwrapConditional(rm IRoleManager, values []string) (IRoleManager, []string) {
+	if crm, ok := rm.(*ConditionalRoleManager); ok {
+		domains, _ := crm.split(values)
+		return crm.IRoleManager, domains
+	}
+	return rm, values
+}
+
+// rangeDomainNames calls fn for every domain with the sorted names of all users and roles of the domain
+func rangeDomainNames(rm IRoleManager, fn func(domain []string, names []string)) {
+	domains := map[string][]string{}
+	names := map[string]map[string]bool{}
+	rm.Range(func(name1, name2 string, domain ...string) bool {
+		key := util.Hash(domain)
+		if _, ok := names[key]; !ok {
+			domains[key] = domain
+			names[key] = map[string]bool{}
+		}
+		names[key][name1] = true
+		names[key][name2] = true
+		return true
+	})
+
+	keys := make([]string, 0, len(domains))
+	for key := range domains {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sorted := make([]string, 0, len(names[key]))
+		for name := range names[key] {
+			sorted = append(sorted, name)
+		}
+		sort.Strings(sorted)
+		fn(domains[key], sorted)
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
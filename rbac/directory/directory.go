@@ -0,0 +1,193 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package directory provides a role provider for LDAP-like directory services.
+// Users and groups are directory entries, group membership is stored in the member attribute of a group.
+//
+//  dn: cn=admins,ou=groups,dc=example,dc=org
+//  objectClass: groupOfNames
+//  cn: admins
+//  member: uid=alice,ou=people,dc=example,dc=org
+package directory
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/abichinger/fastac/str"
+)
+
+// Entry is a directory entry, attribute names are case insensitive
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+func NewEntry(dn string, attributes map[string][]string) *Entry {
+	entry := &Entry{DN: dn, Attributes: map[string][]string{}}
+	for name, values := range attributes {
+		entry.Attributes[strings.ToLower(name)] = values
+	}
+	return entry
+}
+
+// GetAttributeValues returns all values of the attribute name
+func (e *Entry) GetAttributeValues(name string) []string {
+	return e.Attributes[strings.ToLower(name)]
+}
+
+// GetAttributeValue returns the first value of the attribute name or an empty string
+func (e *Entry) GetAttributeValue(name string) string {
+	values := e.GetAttributeValues(name)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// SearchRequest searches the subtree of BaseDN for entries matching Filter.
+// Filter uses the LDAP filter syntax, supported are equality, presence, and, or and not.
+//
+//  (&(objectClass=groupOfNames)(member=uid=alice,ou=people,dc=example,dc=org))
+type SearchRequest struct {
+	BaseDN string
+	Filter string
+}
+
+// Conn is a connection to a directory service
+type Conn interface {
+	Search(req *SearchRequest) ([]*Entry, error)
+}
+
+// RDNValue returns the value of the first relative distinguished name of dn
+//
+//  RDNValue("uid=alice,ou=people,dc=example,dc=org") // alice
+func RDNValue(dn string) string {
+	rdn := strings.SplitN(dn, ",", 2)[0]
+	if i := strings.Index(rdn, "="); i >= 0 {
+		return strings.TrimSpace(rdn[i+1:])
+	}
+	return rdn
+}
+
+// EscapeFilter escapes the special characters of a filter value
+func EscapeFilter(value string) string {
+	replacer := strings.NewReplacer(`\`, `\5c`, `*`, `\2a`, `(`, `\28`, `)`, `\29`, "\x00", `\00`)
+	return replacer.Replace(value)
+}
+
+func unescapeFilter(value string) string {
+	replacer := strings.NewReplacer(`\5c`, `\`, `\2a`, `*`, `\28`, `(`, `\29`, `)`, `\00`, "\x00")
+	return replacer.Replace(value)
+}
+
+// filter matches directory entries
+type filter func(entry *Entry) bool
+
+// parseFilter parses an LDAP filter
+func parseFilter(text string) (filter, error) {
+	f, rest, err := parseFilterPart(strings.TrimSpace(text))
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf(str.ERR_INVALID_FILTER, text)
+	}
+	return f, nil
+}
+
+func parseFilterPart(text string) (filter, string, error) {
+	if !strings.HasPrefix(text, "(") {
+		return nil, "", fmt.Errorf(str.ERR_INVALID_FILTER, text)
+	}
+	text = text[1:]
+	if text == "" {
+		return nil, "", fmt.Errorf(str.ERR_INVALID_FILTER, "(")
+	}
+
+	switch text[0] {
+	case '&', '|':
+		op := text[0]
+		text = text[1:]
+		filters := []filter{}
+		for strings.HasPrefix(text, "(") {
+			f, rest, err := parseFilterPart(text)
+			if err != nil {
+				return nil, "", err
+			}
+			filters = append(filters, f)
+			text = rest
+		}
+		if !strings.HasPrefix(text, ")") {
+			return nil, "", fmt.Errorf(str.ERR_INVALID_FILTER, text)
+		}
+		if op == '&' {
+			return and(filters), text[1:], nil
+		}
+		return or(filters), text[1:], nil
+	case '!':
+		f, rest, err := parseFilterPart(text[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", fmt.Errorf(str.ERR_INVALID_FILTER, rest)
+		}
+		return func(entry *Entry) bool { return !f(entry) }, rest[1:], nil
+	}
+
+	end := strings.Index(text, ")")
+	if end < 0 {
+		return nil, "", fmt.Errorf(str.ERR_INVALID_FILTER, text)
+	}
+	assertion := strings.SplitN(text[:end], "=", 2)
+	if len(assertion) != 2 || assertion[0] == "" {
+		return nil, "", fmt.Errorf(str.ERR_INVALID_FILTER, text[:end])
+	}
+	attribute, value := assertion[0], assertion[1]
+	if value == "*" {
+		return func(entry *Entry) bool { return len(entry.GetAttributeValues(attribute)) > 0 }, text[end+1:], nil
+	}
+	value = unescapeFilter(value)
+	return func(entry *Entry) bool {
+		for _, v := range entry.GetAttributeValues(attribute) {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+		return false
+	}, text[end+1:], nil
+}
+
+func and(filters []filter) filter {
+	return func(entry *Entry) bool {
+		for _, f := range filters {
+			if !f(entry) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func or(filters []filter) filter {
+	return func(entry *Entry) bool {
+		for _, f := range filters {
+			if f(entry) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package directory
+
+import (
+	"testing"
+
+	"github.com/abichinger/fastac/rbac"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer() *MemoryServer {
+	s := NewMemoryServer()
+	s.AddUser("uid=alice,ou=people,dc=example,dc=org")
+	s.AddUser("uid=bob,ou=people,dc=example,dc=org")
+	s.AddUser("uid=o(x)r,ou=people,dc=example,dc=org")
+	s.AddGroup("cn=developers,ou=groups,dc=example,dc=org",
+		"uid=alice,ou=people,dc=example,dc=org",
+		"uid=o(x)r,ou=people,dc=example,dc=org",
+	)
+	s.AddGroup("cn=staff,ou=groups,dc=example,dc=org",
+		"cn=developers,ou=groups,dc=example,dc=org",
+		"uid=bob,ou=people,dc=example,dc=org",
+	)
+	s.AddGroup("cn=admins,ou=groups,dc=other,dc=org",
+		"uid=alice,ou=people,dc=example,dc=org",
+	)
+	return s
+}
+
+func TestSearch(t *testing.T) {
+	s := newTestServer()
+
+	tests := []struct {
+		base, filter string
+		res          []string
+	}{
+		{"dc=example,dc=org", "(uid=alice)", []string{"uid=alice,ou=people,dc=example,dc=org"}},
+		{"dc=example,dc=org", "(CN=Staff)", []string{"cn=staff,ou=groups,dc=example,dc=org"}},
+		{"ou=groups,dc=example,dc=org", "(objectClass=groupOfNames)", []string{"cn=developers,ou=groups,dc=example,dc=org", "cn=staff,ou=groups,dc=example,dc=org"}},
+		{"", "(&(objectClass=groupOfNames)(member=uid=alice,ou=people,dc=example,dc=org))", []string{"cn=admins,ou=groups,dc=other,dc=org", "cn=developers,ou=groups,dc=example,dc=org"}},
+		{"dc=example,dc=org", "(|(uid=bob)(cn=staff))", []string{"cn=staff,ou=groups,dc=example,dc=org", "uid=bob,ou=people,dc=example,dc=org"}},
+		{"ou=people,dc=example,dc=org", "(!(uid=alice))", []string{"uid=bob,ou=people,dc=example,dc=org", "uid=o(x)r,ou=people,dc=example,dc=org"}},
+		{"dc=example,dc=org", "(member=*)", []string{"cn=developers,ou=groups,dc=example,dc=org", "cn=staff,ou=groups,dc=example,dc=org"}},
+		{"dc=example,dc=org", "(uid=" + EscapeFilter("o(x)r") + ")", []string{"uid=o(x)r,ou=people,dc=example,dc=org"}},
+	}
+
+	for _, test := range tests {
+		entries, err := s.Search(&SearchRequest{BaseDN: test.base, Filter: test.filter})
+		assert.NoError(t, err)
+		dns := []string{}
+		for _, entry := range entries {
+			dns = append(dns, entry.DN)
+		}
+		assert.Equal(t, test.res, dns, test.filter)
+	}
+
+	for _, filter := range []string{"", "uid=alice", "(uid=alice", "(=alice)", "(&(uid=alice)", "(uid=alice))"} {
+		_, err := s.Search(&SearchRequest{Filter: filter})
+		assert.Error(t, err, filter)
+	}
+}
+
+func TestProvider(t *testing.T) {
+	s := newTestServer()
+	p := NewProvider(s, Config{BaseDN: "dc=example,dc=org"})
+
+	roles, err := p.GetRoles("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"developers"}, roles)
+
+	roles, _ = p.GetRoles("developers")
+	assert.Equal(t, []string{"staff"}, roles)
+
+	roles, _ = p.GetRoles("o(x)r")
+	assert.Equal(t, []string{"developers"}, roles)
+
+	roles, _ = p.GetRoles("unknown")
+	assert.Equal(t, []string{}, roles)
+
+	users, err := p.GetUsers("staff")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"developers", "bob"}, users)
+
+	users, _ = p.GetUsers("admins")
+	assert.Equal(t, []string{}, users)
+
+	rm := rbac.NewProviderRoleManager(p, rbac.NewRoleManager(10), 10, 0)
+	ok, err := rm.HasLink("alice", "staff")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	searches := s.Searches()
+	ok, _ = rm.HasLink("alice", "staff")
+	assert.True(t, ok)
+	assert.Equal(t, searches, s.Searches())
+}
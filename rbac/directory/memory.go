@@ -0,0 +1,101 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package directory
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryServer is an in-memory directory, it can be used as a stand-in for a directory service in tests
+type MemoryServer struct {
+	mu       sync.RWMutex
+	entries  map[string]*Entry
+	searches int
+}
+
+func NewMemoryServer() *MemoryServer {
+	return &MemoryServer{entries: map[string]*Entry{}}
+}
+
+// Add adds or replaces an entry
+func (s *MemoryServer) Add(entry *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[strings.ToLower(entry.DN)] = entry
+}
+
+// Remove removes the entry dn
+func (s *MemoryServer) Remove(dn string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, strings.ToLower(dn))
+}
+
+// AddUser adds an entry with the naming attribute uid
+//
+//  s.AddUser("uid=alice,ou=people,dc=example,dc=org")
+func (s *MemoryServer) AddUser(dn string) {
+	s.Add(NewEntry(dn, map[string][]string{
+		"objectClass": {"inetOrgPerson"},
+		"uid":         {RDNValue(dn)},
+	}))
+}
+
+// AddGroup adds a group entry with the naming attribute cn
+//
+//  s.AddGroup("cn=admins,ou=groups,dc=example,dc=org", "uid=alice,ou=people,dc=example,dc=org")
+func (s *MemoryServer) AddGroup(dn string, members ...string) {
+	s.Add(NewEntry(dn, map[string][]string{
+		"objectClass": {"groupOfNames"},
+		"cn":          {RDNValue(dn)},
+		"member":      members,
+	}))
+}
+
+// Searches returns the number of search requests
+func (s *MemoryServer) Searches() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.searches
+}
+
+// Search returns the entries below req.BaseDN matching req.Filter, sorted by DN
+func (s *MemoryServer) Search(req *SearchRequest) ([]*Entry, error) {
+	f, err := parseFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.searches++
+
+	base := strings.ToLower(req.BaseDN)
+	res := []*Entry{}
+	for dn, entry := range s.entries {
+		if base != "" && dn != base && !strings.HasSuffix(dn, ","+base) {
+			continue
+		}
+		if f(entry) {
+			res = append(res, entry)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].DN < res[j].DN
+	})
+	return res, nil
+}
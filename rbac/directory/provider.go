@@ -0,0 +1,118 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package directory
+
+import (
+	"fmt"
+
+	"github.com/abichinger/fastac/rbac"
+)
+
+// Config describes the layout of the directory
+type Config struct {
+	// BaseDN is the search base of all requests
+	BaseDN string
+	// UserAttribute is the naming attribute of users, default: uid
+	UserAttribute string
+	// GroupAttribute is the naming attribute of groups, default: cn
+	GroupAttribute string
+	// GroupClass is the object class of groups, default: groupOfNames
+	GroupClass string
+	// MemberAttribute contains the DNs of the group members, default: member
+	MemberAttribute string
+}
+
+func (c Config) withDefaults() Config {
+	if c.UserAttribute == "" {
+		c.UserAttribute = "uid"
+	}
+	if c.GroupAttribute == "" {
+		c.GroupAttribute = "cn"
+	}
+	if c.GroupClass == "" {
+		c.GroupClass = "groupOfNames"
+	}
+	if c.MemberAttribute == "" {
+		c.MemberAttribute = "member"
+	}
+	return c
+}
+
+// Provider is a rbac.RoleProvider, users and groups are identified by their naming attribute.
+// A user inherits the groups, which list the user as member. Groups can be members of other groups.
+// Domains are not supported by the directory layout, domain arguments are ignored.
+//
+//  p := directory.NewProvider(conn, directory.Config{BaseDN: "dc=example,dc=org"})
+//  roles, _ := p.GetRoles("alice")
+type Provider struct {
+	conn   Conn
+	config Config
+}
+
+var _ rbac.RoleProvider = &Provider{}
+
+func NewProvider(conn Conn, config Config) *Provider {
+	return &Provider{conn: conn, config: config.withDefaults()}
+}
+
+// findDN returns the DN of the user or group name, the second return value is false if no entry was found
+func (p *Provider) findDN(name string) (string, bool, error) {
+	value := EscapeFilter(name)
+	entries, err := p.conn.Search(&SearchRequest{
+		BaseDN: p.config.BaseDN,
+		Filter: fmt.Sprintf("(|(%s=%s)(&(objectClass=%s)(%s=%s)))", p.config.UserAttribute, value, p.config.GroupClass, p.config.GroupAttribute, value),
+	})
+	if err != nil || len(entries) == 0 {
+		return "", false, err
+	}
+	return entries[0].DN, true, nil
+}
+
+// GetRoles returns the groups, which list name as member
+func (p *Provider) GetRoles(name string, domains ...string) ([]string, error) {
+	dn, ok, err := p.findDN(name)
+	if err != nil || !ok {
+		return []string{}, err
+	}
+	entries, err := p.conn.Search(&SearchRequest{
+		BaseDN: p.config.BaseDN,
+		Filter: fmt.Sprintf("(&(objectClass=%s)(%s=%s))", p.config.GroupClass, p.config.MemberAttribute, EscapeFilter(dn)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	roles := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		roles = append(roles, entry.GetAttributeValue(p.config.GroupAttribute))
+	}
+	return roles, nil
+}
+
+// GetUsers returns the members of the group name
+func (p *Provider) GetUsers(name string, domains ...string) ([]string, error) {
+	entries, err := p.conn.Search(&SearchRequest{
+		BaseDN: p.config.BaseDN,
+		Filter: fmt.Sprintf("(&(objectClass=%s)(%s=%s))", p.config.GroupClass, p.config.GroupAttribute, EscapeFilter(name)),
+	})
+	if err != nil || len(entries) == 0 {
+		return []string{}, err
+	}
+	members := entries[0].GetAttributeValues(p.config.MemberAttribute)
+	users := make([]string, 0, len(members))
+	for _, member := range members {
+		users = append(users, RDNValue(member))
+	}
+	return users, nil
+}
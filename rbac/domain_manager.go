@@ -15,6 +15,7 @@
 package rbac
 
 import (
+	"sort"
 	"strings"
 	"sync"
 
@@ -27,16 +28,20 @@ type DomainManager struct {
 	rmMap             *sync.Map
 	patternMap        *sync.Map
 	maxHierarchyLevel int
+	rejectCycles      bool
+	closureIndex      bool
+	hierarchy         IRoleManager
 	matcher           util.IMatcher
 	domainMatcher     util.IMatcher
 	matchingFuncCache *util.SyncLRUCache
+	eventEmitter
 }
 
 // NewDomainManager is the constructor for creating an instance of the
 // default DomainManager implementation.
 func NewDomainManager(maxHierarchyLevel int) *DomainManager {
-	dm := &DomainManager{}
-	_ = dm.Clear() // init rmMap and rmCache
+	dm := &DomainManager{eventEmitter: newEventEmitter()}
+	dm.reset() // init rmMap and rmCache
 	dm.maxHierarchyLevel = maxHierarchyLevel
 	return dm
 }
@@ -59,22 +64,85 @@ func (dm *DomainManager) SetDomainMatcher(matcher util.IMatcher) {
 	dm.rebuild()
 }
 
+// SetMaxHierarchyLevel sets the maximum depth of role inheritance, which is considered by HasLink
+func (dm *DomainManager) SetMaxHierarchyLevel(level int) {
+	dm.maxHierarchyLevel = level
+	dm.rmMap.Range(func(key, value interface{}) bool {
+		value.(IDefaultRoleManager).SetMaxHierarchyLevel(level - 1)
+		return true
+	})
+}
+
+// SetRejectCycles enables cycle detection, AddLink returns a *CycleError for links, which would close a cycle
+func (dm *DomainManager) SetRejectCycles(reject bool) {
+	dm.rejectCycles = reject
+	dm.rmMap.Range(func(key, value interface{}) bool {
+		value.(IDefaultRoleManager).SetRejectCycles(reject)
+		return true
+	})
+}
+
+// SetClosureIndex enables the memoised transitive closure of every domain
+func (dm *DomainManager) SetClosureIndex(enabled bool) {
+	dm.closureIndex = enabled
+	dm.rmMap.Range(func(key, value interface{}) bool {
+		value.(IDefaultRoleManager).SetClosureIndex(enabled)
+		return true
+	})
+}
+
+// SetDomainHierarchy sets the role manager, which links domains to their parent domains.
+// Links of a domain are inherited by all its descendants, hierarchy.GetRoles has to return the parents of a domain.
+//
+//  hierarchy := rbac.NewRoleManager(10)
+//  hierarchy.AddLink("project1", "team1")
+//  hierarchy.AddLink("team1", "org1")
+//  dm.SetDomainHierarchy(hierarchy)
+//  dm.AddLink("alice", "admin", "org1")
+//  dm.HasLink("alice", "admin", "project1") // true
+func (dm *DomainManager) SetDomainHierarchy(hierarchy IRoleManager) {
+	dm.hierarchy = hierarchy
+}
+
+// domainAncestry returns domain followed by all its ancestors in breadth-first order
+func (dm *DomainManager) domainAncestry(domain string) []string {
+	ancestry := []string{domain}
+	visited := map[string]bool{domain: true}
+	for i := 0; i < len(ancestry); i++ {
+		parents, _ := dm.hierarchy.GetRoles(ancestry[i])
+		for _, parent := range parents {
+			if !visited[parent] {
+				visited[parent] = true
+				ancestry = append(ancestry, parent)
+			}
+		}
+	}
+	return ancestry
+}
+
 // clears the map of RoleManagers
 func (dm *DomainManager) rebuild() {
 	rmMap := dm.rmMap
-	_ = dm.Clear()
+	dm.muted = true
+	dm.reset()
 	dm.rangeLinks(rmMap, func(name1, name2 string, domain ...string) bool {
 		_, _ = dm.AddLink(name1, name2, domain...)
 		return true
 	})
+	dm.muted = false
 }
 
 //Clear clears all stored data and resets the role manager to the initial state.
 func (dm *DomainManager) Clear() error {
+	dm.reset()
+	dm.emit(EVT_ROLES_CLEARED, "", "")
+	return nil
+}
+
+func (dm *DomainManager) reset() {
 	dm.rmMap = &sync.Map{}
 	dm.patternMap = &sync.Map{}
 	dm.matchingFuncCache = util.NewSyncLRUCache(100)
-	return nil
 }
 
 func (dm *DomainManager) getDomain(domains ...string) (domain string, subdomains []string, err error) {
@@ -138,12 +206,22 @@ func (dm *DomainManager) getRoleManager(domain string, store bool, subdomains ..
 		} else {
 			rm = newRoleManagerWithMatchingFunc(dm.maxHierarchyLevel-1, dm.matcher)
 		}
+		rm.SetRejectCycles(dm.rejectCycles)
+		rm.SetClosureIndex(dm.closureIndex)
 		if store {
 			dm.rmMap.Store(domain, rm)
+			if domain != defaultDomain {
+				dm.forwardEvents(rm, domain)
+				dm.emit(EVT_DOMAIN_CREATED, "", "", domain)
+			} else {
+				dm.forwardEvents(rm)
+			}
 		}
 		if dm.domainMatcher != nil {
 			if dm.domainMatcher.IsPattern(domain) {
-				dm.patternMap.Store(domain, nil)
+				if store {
+					dm.patternMap.Store(domain, nil)
+				}
 			} else {
 				dm.rangeMatchingPatterns(domain, func(rm2 IRoleManager) {
 					rm2.Range(func(name1, name2 string, domain ...string) bool {
@@ -165,7 +243,13 @@ func (dm *DomainManager) AddLink(name1 string, name2 string, domains ...string)
 		return false, err
 	}
 	roleManager := dm.getRoleManager(domain, true, subdomains...) //create role manager if it does not exist
-	added, _ := roleManager.AddLink(name1, name2, subdomains...)
+	added, err := roleManager.AddLink(name1, name2, subdomains...)
+	if err != nil {
+		if domain != defaultDomain {
+			err = withDomain(err, domain)
+		}
+		return false, err
+	}
 
 	if dm.domainMatcher != nil && dm.domainMatcher.IsPattern(domain) {
 		dm.rangeMatchingRMs(domain, func(rm IRoleManager) {
@@ -182,7 +266,10 @@ func (dm *DomainManager) DeleteLink(name1 string, name2 string, domains ...strin
 	if err != nil {
 		return false, err
 	}
-	roleManager := dm.getRoleManager(domain, true, subdomains...) //create role manager if it does not exist
+	roleManager, ok := dm.load(domain)
+	if !ok {
+		return false, nil
+	}
 	removed, _ := roleManager.DeleteLink(name1, name2, subdomains...)
 
 	if dm.domainMatcher != nil && dm.domainMatcher.IsPattern(domain) {
@@ -190,17 +277,74 @@ func (dm *DomainManager) DeleteLink(name1 string, name2 string, domains ...strin
 			_, _ = rm.DeleteLink(name1, name2, append(subdomains, REDUNDANT_ROLE)...)
 		})
 	}
+	if removed && domain != defaultDomain && isEmpty(roleManager) {
+		dm.rmMap.Delete(domain)
+		dm.patternMap.Delete(domain)
+		dm.emit(EVT_DOMAIN_DELETED, "", "", domain)
+	}
 	return removed, nil
 }
 
+// isEmpty returns true, if rm has no links
+func isEmpty(rm IRoleManager) bool {
+	empty := true
+	rm.Range(func(_, _ string, _ ...string) bool {
+		empty = false
+		return false
+	})
+	return empty
+}
+
 // HasLink determines whether role: name1 inherits role: name2.
 func (dm *DomainManager) HasLink(name1 string, name2 string, domains ...string) (bool, error) {
 	domain, subdomains, err := dm.getDomain(domains...)
 	if err != nil {
 		return false, err
 	}
-	rm := dm.getRoleManager(domain, false, subdomains...)
-	return rm.HasLink(name1, name2, subdomains...)
+	if dm.hierarchy == nil || domain == defaultDomain {
+		rm := dm.getRoleManager(domain, false, subdomains...)
+		return rm.HasLink(name1, name2, subdomains...)
+	}
+	return dm.hasInheritedLink(name1, name2, dm.domainAncestry(domain), subdomains)
+}
+
+// hasInheritedLink determines whether role: name1 inherits role: name2, the links of all domains are combined
+func (dm *DomainManager) hasInheritedLink(name1 string, name2 string, domains []string, subdomains []string) (bool, error) {
+	rms := make([]IRoleManager, len(domains))
+	for i, domain := range domains {
+		rms[i] = dm.getRoleManager(domain, false, subdomains...)
+		if ok, err := rms[i].HasLink(name1, name2, subdomains...); ok || err != nil {
+			return ok, err
+		}
+	}
+	if len(rms) == 1 {
+		return false, nil
+	}
+
+	visited := map[string]bool{name1: true}
+	current := []string{name1}
+	for level := 1; level < dm.maxHierarchyLevel-1 && len(current) > 0; level++ {
+		next := []string{}
+		for _, name := range current {
+			for _, rm := range rms {
+				roles, err := rm.GetRoles(name, subdomains...)
+				if err != nil {
+					return false, err
+				}
+				for _, role := range roles {
+					if role == name2 || (dm.matcher != nil && dm.matcher.Match(role, name2)) {
+						return true, nil
+					}
+					if !visited[role] {
+						visited[role] = true
+						next = append(next, role)
+					}
+				}
+			}
+		}
+		current = next
+	}
+	return false, nil
 }
 
 // GetRoles gets the roles that a subject inherits.
@@ -209,8 +353,83 @@ func (dm *DomainManager) GetRoles(name string, domains ...string) ([]string, err
 	if err != nil {
 		return nil, err
 	}
-	rm := dm.getRoleManager(domain, false, subdomains...)
-	return rm.GetRoles(name, subdomains...)
+	if dm.hierarchy == nil || domain == defaultDomain {
+		rm := dm.getRoleManager(domain, false, subdomains...)
+		return rm.GetRoles(name, subdomains...)
+	}
+
+	//roles of ancestor domains are inherited
+	res := []string{}
+	found := map[string]bool{}
+	for _, domain := range dm.domainAncestry(domain) {
+		rm := dm.getRoleManager(domain, false, subdomains...)
+		roles, err := rm.GetRoles(name, subdomains...)
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range roles {
+			if !found[role] {
+				found[role] = true
+				res = append(res, role)
+			}
+		}
+	}
+	return res, nil
+}
+
+// linkSources returns the stored links, which let name1 directly inherit name2.
+// The links of ancestor domains and matching domain patterns are included.
+func (dm *DomainManager) linkSources(name1 string, name2 string, domains ...string) []link {
+	domain, subdomains, _ := dm.getDomain(domains...)
+
+	candidates := []string{domain}
+	if dm.hierarchy != nil && domain != defaultDomain {
+		candidates = dm.domainAncestry(domain)
+	}
+	if dm.domainMatcher != nil && domain != defaultDomain {
+		found := map[string]bool{}
+		for _, d := range candidates {
+			found[d] = true
+		}
+		for _, d := range candidates {
+			dm.patternMap.Range(func(key, _ interface{}) bool {
+				pattern := key.(string)
+				if !found[pattern] && dm.match(d, pattern) {
+					found[pattern] = true
+					candidates = append(candidates, pattern)
+				}
+				return true
+			})
+		}
+	}
+
+	sources := []link{}
+	for _, d := range candidates {
+		rm, ok := dm.load(d)
+		if !ok {
+			continue
+		}
+		resolver, ok := rm.(linkResolver)
+		if !ok {
+			continue
+		}
+		for _, source := range resolver.linkSources(name1, name2, subdomains...) {
+			if d != defaultDomain {
+				source.domains = append([]string{d}, source.domains...)
+			}
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// matchesPattern returns true, if name is matched by the role pattern
+func (dm *DomainManager) matchesPattern(name string, pattern string) bool {
+	return dm.matcher != nil && dm.matcher.Match(name, pattern)
+}
+
+func (dm *DomainManager) hierarchyLevel() int {
+	return dm.maxHierarchyLevel
 }
 
 // GetUsers gets the users of a role.
@@ -293,15 +512,37 @@ func (dm *DomainManager) rangeLinks(rmMap *sync.Map, fn func(name1, name2 string
 			domains = append(domains, d)
 		}
 
+		next := true
 		roleManager.Range(func(name1, name2 string, domain ...string) bool {
-			fn(name1, name2, append(domains, domain...)...)
-			return true
+			next = fn(name1, name2, append(domains, domain...)...)
+			return next
 		})
-
-		return true
+		return next
 	})
 }
 
 func (dm *DomainManager) Range(fn func(name1, name2 string, domain ...string) bool) {
 	dm.rangeLinks(dm.rmMap, fn)
 }
+
+// Validate reports cycles (*CycleError) and links, which exceed the max hierarchy level (*DepthError) of every domain
+func (dm *DomainManager) Validate() []error {
+	domains := []string{}
+	dm.rmMap.Range(func(key, _ interface{}) bool {
+		domains = append(domains, key.(string))
+		return true
+	})
+	sort.Strings(domains)
+
+	errs := []error{}
+	for _, domain := range domains {
+		rm, _ := dm.load(domain)
+		for _, err := range rm.Validate() {
+			if domain != defaultDomain {
+				err = withDomain(err, domain)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
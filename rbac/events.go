@@ -0,0 +1,87 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rbac
+
+import (
+	em "github.com/vansante/go-event-emitter"
+)
+
+// Events of a role manager, the listeners are called with a single *RoleEvent
+const (
+	// EVT_LINK_ADDED is emitted, after AddLink added the link Name1 -> Name2
+	EVT_LINK_ADDED em.EventType = "link_added"
+	// EVT_LINK_REMOVED is emitted, after DeleteLink removed the link Name1 -> Name2
+	EVT_LINK_REMOVED em.EventType = "link_removed"
+	// EVT_PATTERN_MATCHED is emitted, if the role Name1 starts to match the pattern role Name2
+	EVT_PATTERN_MATCHED em.EventType = "pattern_matched"
+	// EVT_PATTERN_UNMATCHED is emitted, if the role Name1 no longer matches the pattern role Name2
+	EVT_PATTERN_UNMATCHED em.EventType = "pattern_unmatched"
+	// EVT_DOMAIN_CREATED is emitted, after the first link of Domain was added
+	EVT_DOMAIN_CREATED em.EventType = "domain_created"
+	// EVT_DOMAIN_DELETED is emitted, after the last link of Domain was removed
+	EVT_DOMAIN_DELETED em.EventType = "domain_deleted"
+	// EVT_ROLES_CLEARED is emitted, after Clear removed all links
+	EVT_ROLES_CLEARED em.EventType = "roles_cleared"
+)
+
+var roleEvents = []em.EventType{
+	EVT_LINK_ADDED,
+	EVT_LINK_REMOVED,
+	EVT_PATTERN_MATCHED,
+	EVT_PATTERN_UNMATCHED,
+	EVT_DOMAIN_CREATED,
+	EVT_DOMAIN_DELETED,
+	EVT_ROLES_CLEARED,
+}
+
+// RoleEvent describes a change of a role manager.
+// Events of derived changes, like a pattern role matching a new role, are emitted as well.
+//
+//  rm.AddListener(rbac.EVT_PATTERN_MATCHED, func(arguments ...interface{}) {
+//  	e := arguments[0].(*rbac.RoleEvent)
+//  	fmt.Printf("%s matches %s in %v", e.Name1, e.Name2, e.Domain)
+//  })
+type RoleEvent struct {
+	Name1  string
+	Name2  string
+	Domain []string
+}
+
+// eventEmitter emits role events, events can be muted while the role manager rebuilds its state
+type eventEmitter struct {
+	*em.Emitter
+	muted bool
+}
+
+func newEventEmitter() eventEmitter {
+	return eventEmitter{Emitter: em.NewEmitter(false)}
+}
+
+func (e *eventEmitter) emit(event em.EventType, name1 string, name2 string, domain ...string) {
+	if !e.muted {
+		e.Emitter.EmitEvent(event, &RoleEvent{Name1: name1, Name2: name2, Domain: domain})
+	}
+}
+
+// forwardEvents emits all events of rm, the domain is prepended to the domain of the forwarded events
+func (e *eventEmitter) forwardEvents(rm IRoleEventEmitter, domain ...string) {
+	for _, event := range roleEvents {
+		event := event
+		rm.AddListener(event, func(arguments ...interface{}) {
+			re := arguments[0].(*RoleEvent)
+			e.emit(event, re.Name1, re.Name2, append(append([]string{}, domain...), re.Domain...)...)
+		})
+	}
+}
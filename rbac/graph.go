@@ -0,0 +1,312 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rbac
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/abichinger/fastac/str"
+	"github.com/abichinger/fastac/util"
+)
+
+// formats of RoleGraph.Write
+const (
+	GraphDOT     = "dot"
+	GraphMermaid = "mermaid"
+	GraphJSON    = "json"
+)
+
+// RoleNode is a user or role of a domain
+type RoleNode struct {
+	Name   string
+	Domain []string
+}
+
+// RoleEdge is a link From -> To. A pattern edge means From matches the pattern role To.
+type RoleEdge struct {
+	From    string
+	To      string
+	Domain  []string
+	Pattern bool
+}
+
+// RoleGraph is the role hierarchy of a role manager, the edges are sorted by domain, From and To.
+// Links of a RoleProvider and link parameters are not part of the graph.
+//
+//  g := rbac.NewRoleGraph(rm).Subgraph("alice")
+//  g.Write(os.Stdout, rbac.GraphMermaid)
+type RoleGraph struct {
+	Edges []RoleEdge
+}
+
+// NewRoleGraph collects the links and pattern matches of rm
+func NewRoleGraph(rm IRoleManager) *RoleGraph {
+	g := &RoleGraph{Edges: []RoleEdge{}}
+	g.collect(rm, []string{})
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if da, db := strings.Join(a.Domain, "/"), strings.Join(b.Domain, "/"); da != db {
+			return da < db
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	return g
+}
+
+func (g *RoleGraph) collect(rm IRoleManager, domain []string) {
+	switch rm := rm.(type) {
+	case *ConditionalRoleManager:
+		g.collect(rm.IRoleManager, domain)
+	case *ProviderRoleManager:
+		g.collect(rm.IDefaultRoleManager, domain)
+	case *DomainManager:
+		rm.rmMap.Range(func(key, value interface{}) bool {
+			subdomain := domain
+			if d := key.(string); d != defaultDomain {
+				subdomain = append(append([]string{}, domain...), d)
+			}
+			g.collect(value.(IRoleManager), subdomain)
+			return true
+		})
+	case *RoleManager:
+		rm.Range(func(name1, name2 string, _ ...string) bool {
+			g.Edges = append(g.Edges, RoleEdge{From: name1, To: name2, Domain: domain})
+			return true
+		})
+		for match := range rm.matches() {
+			g.Edges = append(g.Edges, RoleEdge{From: match[0], To: match[1], Domain: domain, Pattern: true})
+		}
+	default:
+		rm.Range(func(name1, name2 string, d ...string) bool {
+			g.Edges = append(g.Edges, RoleEdge{From: name1, To: name2, Domain: append(append([]string{}, domain...), d...)})
+			return true
+		})
+	}
+}
+
+// Nodes returns all users and roles of the graph sorted by domain and name
+func (g *RoleGraph) Nodes() []RoleNode {
+	nodes := []RoleNode{}
+	found := map[string]bool{}
+	add := func(name string, domain []string) {
+		key := util.Hash(append([]string{name}, domain...))
+		if !found[key] {
+			found[key] = true
+			nodes = append(nodes, RoleNode{Name: name, Domain: domain})
+		}
+	}
+	for _, edge := range g.Edges {
+		add(edge.From, edge.Domain)
+		add(edge.To, edge.Domain)
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if di, dj := strings.Join(nodes[i].Domain, "/"), strings.Join(nodes[j].Domain, "/"); di != dj {
+			return di < dj
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+	return nodes
+}
+
+// Subgraph returns the edges of domain, which are reachable from user.
+// Pattern edges are followed in both directions, but not twice in a row, e.g. a user of book/* holds book/1, but not book/2.
+func (g *RoleGraph) Subgraph(user string, domain ...string) *RoleGraph {
+	key := strings.Join(domain, "/")
+	edges := []RoleEdge{}
+	for _, edge := range g.Edges {
+		if strings.Join(edge.Domain, "/") == key {
+			edges = append(edges, edge)
+		}
+	}
+
+	type state struct {
+		name     string
+		viaMatch bool
+	}
+	included := make([]bool, len(edges))
+	visited := map[state]bool{{user, false}: true}
+	queue := []state{{user, false}}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for i, edge := range edges {
+			var next state
+			switch {
+			case !edge.Pattern && edge.From == current.name:
+				next = state{edge.To, false}
+			case edge.Pattern && !current.viaMatch && edge.From == current.name:
+				next = state{edge.To, true}
+			case edge.Pattern && !current.viaMatch && edge.To == current.name:
+				next = state{edge.From, true}
+			default:
+				continue
+			}
+			included[i] = true
+			if !visited[next] {
+				visited[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	sub := &RoleGraph{Edges: []RoleEdge{}}
+	for i, edge := range edges {
+		if included[i] {
+			sub.Edges = append(sub.Edges, edge)
+		}
+	}
+	return sub
+}
+
+// Write writes the graph in the format GraphDOT, GraphMermaid or GraphJSON
+func (g *RoleGraph) Write(w io.Writer, format string) error {
+	switch format {
+	case GraphDOT:
+		return g.WriteDOT(w)
+	case GraphMermaid:
+		return g.WriteMermaid(w)
+	case GraphJSON:
+		return g.WriteJSON(w)
+	}
+	return fmt.Errorf(str.ERR_GRAPH_FORMAT, format)
+}
+
+// nodeIDs assigns the ids n0, n1, ... to the nodes and groups them by domain
+func (g *RoleGraph) nodeIDs() (ids map[string]string, domains [][]RoleNode) {
+	ids = map[string]string{}
+	domainIndex := map[string]int{}
+	for i, node := range g.Nodes() {
+		ids[util.Hash(append([]string{node.Name}, node.Domain...))] = fmt.Sprintf("n%d", i)
+		key := strings.Join(node.Domain, "/")
+		index, ok := domainIndex[key]
+		if !ok {
+			index = len(domains)
+			domainIndex[key] = index
+			domains = append(domains, []RoleNode{})
+		}
+		domains[index] = append(domains[index], node)
+	}
+	return ids, domains
+}
+
+func nodeID(ids map[string]string, name string, domain []string) string {
+	return ids[util.Hash(append([]string{name}, domain...))]
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language, every domain is a cluster and pattern edges are dashed
+func (g *RoleGraph) WriteDOT(w io.Writer) error {
+	ids, domains := g.nodeIDs()
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph roles {")
+	for i, nodes := range domains {
+		indent := "\t"
+		if len(nodes[0].Domain) > 0 {
+			fmt.Fprintf(bw, "\tsubgraph cluster_%d {\n\t\tlabel=\"%s\";\n", i, quote(strings.Join(nodes[0].Domain, "/")))
+			indent = "\t\t"
+		}
+		for _, node := range nodes {
+			fmt.Fprintf(bw, "%s%s [label=\"%s\"];\n", indent, nodeID(ids, node.Name, node.Domain), quote(node.Name))
+		}
+		if len(nodes[0].Domain) > 0 {
+			fmt.Fprintln(bw, "\t}")
+		}
+	}
+	for _, edge := range g.Edges {
+		style := ""
+		if edge.Pattern {
+			style = " [style=dashed]"
+		}
+		fmt.Fprintf(bw, "\t%s -> %s%s;\n", nodeID(ids, edge.From, edge.Domain), nodeID(ids, edge.To, edge.Domain), style)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// WriteMermaid writes the graph as Mermaid flowchart, every domain is a subgraph and pattern edges are dotted
+func (g *RoleGraph) WriteMermaid(w io.Writer) error {
+	ids, domains := g.nodeIDs()
+	quote := strings.NewReplacer(`"`, `#quot;`).Replace
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "graph LR")
+	for i, nodes := range domains {
+		indent := "\t"
+		if len(nodes[0].Domain) > 0 {
+			fmt.Fprintf(bw, "\tsubgraph d%d [\"%s\"]\n", i, quote(strings.Join(nodes[0].Domain, "/")))
+			indent = "\t\t"
+		}
+		for _, node := range nodes {
+			fmt.Fprintf(bw, "%s%s[\"%s\"]\n", indent, nodeID(ids, node.Name, node.Domain), quote(node.Name))
+		}
+		if len(nodes[0].Domain) > 0 {
+			fmt.Fprintln(bw, "\tend")
+		}
+	}
+	for _, edge := range g.Edges {
+		arrow := "-->"
+		if edge.Pattern {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(bw, "\t%s %s %s\n", nodeID(ids, edge.From, edge.Domain), arrow, nodeID(ids, edge.To, edge.Domain))
+	}
+	return bw.Flush()
+}
+
+type jsonDomain struct {
+	Domain []string `json:"domain"`
+	// Roles contains the directly inherited roles of every user
+	Roles map[string][]string `json:"roles"`
+	// Matches contains the matching pattern roles of every role
+	Matches map[string][]string `json:"matches,omitempty"`
+}
+
+// WriteJSON writes the adjacency lists of every domain
+//
+//  {"domains": [{"domain": [], "roles": {"alice": ["admin"]}, "matches": {"book/1": ["book/*"]}}]}
+func (g *RoleGraph) WriteJSON(w io.Writer) error {
+	domains := []*jsonDomain{}
+	index := map[string]*jsonDomain{}
+	for _, edge := range g.Edges {
+		key := strings.Join(edge.Domain, "/")
+		d, ok := index[key]
+		if !ok {
+			d = &jsonDomain{Domain: edge.Domain, Roles: map[string][]string{}}
+			index[key] = d
+			domains = append(domains, d)
+		}
+		if edge.Pattern {
+			if d.Matches == nil {
+				d.Matches = map[string][]string{}
+			}
+			d.Matches[edge.From] = append(d.Matches[edge.From], edge.To)
+		} else {
+			d.Roles[edge.From] = append(d.Roles[edge.From], edge.To)
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]interface{}{"domains": domains})
+}
@@ -0,0 +1,212 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rbac
+
+import (
+	"github.com/abichinger/fastac/util"
+)
+
+// link is a stored link of a role manager
+type link struct {
+	name1   string
+	name2   string
+	domains []string
+}
+
+// linkResolver is implemented by role managers, which can resolve links produced by pattern matching.
+type linkResolver interface {
+	// linkSources returns the stored links, which let name1 directly inherit name2
+	linkSources(name1 string, name2 string, domains ...string) []link
+	// matchesPattern returns true, if name is matched by the role pattern
+	matchesPattern(name string, pattern string) bool
+	// hierarchyLevel returns the maximum depth of role inheritance
+	hierarchyLevel() int
+}
+
+func linkKey(name1, name2 string, domains []string) string {
+	return util.Hash(append([]string{name1, name2}, domains...))
+}
+
+// hasLinkWhere determines whether name1 inherits name2 only through links, which are accepted by follow.
+// A link produced by pattern matching is followed, if one of the stored links producing it is accepted.
+// Role managers, which do not resolve pattern links, are traversed by their direct links.
+func hasLinkWhere(rm IRoleManager, name1 string, name2 string, domains []string, maxHierarchyLevel int, follow func(l link) (bool, error)) (bool, error) {
+	resolver, _ := rm.(linkResolver)
+	matches := func(name, pattern string) bool {
+		return name == pattern || (resolver != nil && resolver.matchesPattern(name, pattern))
+	}
+	if matches(name1, name2) {
+		return true, nil
+	}
+
+	followLink := func(name, role string, roles []string) (bool, error) {
+		sources := []link{}
+		if resolver != nil {
+			sources = resolver.linkSources(name, role, domains...)
+		} else if contains(roles, role) {
+			sources = append(sources, link{name1: name, name2: role, domains: domains})
+		}
+		for _, source := range sources {
+			if ok, err := follow(source); ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+
+	visited := map[string]bool{name1: true}
+	current := []string{name1}
+	for level := 0; level < maxHierarchyLevel && len(current) > 0; level++ {
+		next := []string{}
+		for _, name := range current {
+			roles, err := rm.GetRoles(name, domains...)
+			if err != nil {
+				return false, err
+			}
+			//name2 might be matched by a pattern role, which is not returned by GetRoles
+			if ok, err := followLink(name, name2, roles); ok || err != nil {
+				return ok, err
+			}
+			for _, role := range roles {
+				if visited[role] {
+					continue
+				}
+				ok, err := followLink(name, role, roles)
+				if err != nil {
+					return false, err
+				}
+				if !ok {
+					continue
+				}
+				if matches(role, name2) {
+					return true, nil
+				}
+				visited[role] = true
+				next = append(next, role)
+			}
+		}
+		current = next
+	}
+	return false, nil
+}
+
+// HasLinkExcluding works like the g function of rm, but the links of excluded are not followed.
+// The excluded rules consist of the values of a grouping rule without the policy key.
+// args are the arguments of the g function: name1, name2, the domains and the arguments of link conditions.
+//
+//  rbac.HasLinkExcluding(rm, [][]string{{"alice", "admin"}}, "alice", "admin") // false
+func HasLinkExcluding(rm IRoleManager, excluded [][]string, args ...interface{}) (bool, error) {
+	name1 := args[0].(string)
+	name2 := args[1].(string)
+
+	crm, conditional := rm.(*ConditionalRoleManager)
+	nDomains := len(args) - 2
+	if conditional && crm.nDomains < nDomains {
+		nDomains = crm.nDomains
+	}
+	domains := make([]string, 0, nDomains)
+	for _, arg := range args[2 : 2+nDomains] {
+		domains = append(domains, arg.(string))
+	}
+	conditionArgs := args[2+nDomains:]
+
+	skip := map[string]bool{}
+	for _, rule := range excluded {
+		if len(rule) < 2 {
+			continue
+		}
+		values := rule[2:]
+		if conditional {
+			values, _ = crm.split(values)
+		}
+		skip[linkKey(rule[0], rule[1], values)] = true
+	}
+
+	maxHierarchyLevel := 10
+	if conditional {
+		maxHierarchyLevel = crm.maxHierarchyLevel
+		rm = crm.IRoleManager
+	} else if resolver, ok := rm.(linkResolver); ok {
+		maxHierarchyLevel = resolver.hierarchyLevel()
+	}
+
+	return hasLinkWhere(rm, name1, name2, domains, maxHierarchyLevel, func(l link) (bool, error) {
+		if skip[linkKey(l.name1, l.name2, l.domains)] {
+			return false, nil
+		}
+		if conditional {
+			return crm.checkLink(l, conditionArgs)
+		}
+		return true, nil
+	})
+}
+
+// hasStoredLink determines whether the link of name1 to name2 is stored in rm.
+// Inherited links, links produced by patterns and links of role providers are not considered, parameters of conditional links are ignored.
+func hasStoredLink(rm IRoleManager, name1 string, name2 string, values ...string) bool {
+	switch r := rm.(type) {
+	case *ConditionalRoleManager:
+		domains, _ := r.split(values)
+		return hasStoredLink(r.IRoleManager, name1, name2, domains...)
+	case *ProviderRoleManager:
+		return hasStoredLink(r.IDefaultRoleManager, name1, name2, values...)
+	case linkResolver:
+		key := linkKey(name1, name2, values)
+		for _, l := range r.linkSources(name1, name2, values...) {
+			if linkKey(l.name1, l.name2, l.domains) == key {
+				return true
+			}
+		}
+		return false
+	}
+
+	key := linkKey(name1, name2, values)
+	found := false
+	rm.Range(func(n1, n2 string, domains ...string) bool {
+		found = linkKey(n1, n2, domains) == key
+		return !found
+	})
+	return found
+}
+
+// excludingRoleManager hides a stored link of the wrapped role manager from HasLink and GetUsers.
+// It lets constraints check a link, as if the excluded link was already removed.
+type excludingRoleManager struct {
+	IRoleManager
+	excluded link
+}
+
+func (rm *excludingRoleManager) HasLink(name1 string, name2 string, domains ...string) (bool, error) {
+	args := []interface{}{name1, name2}
+	for _, domain := range domains {
+		args = append(args, domain)
+	}
+	excluded := append([]string{rm.excluded.name1, rm.excluded.name2}, rm.excluded.domains...)
+	return HasLinkExcluding(rm.IRoleManager, [][]string{excluded}, args...)
+}
+
+func (rm *excludingRoleManager) GetUsers(name string, domains ...string) ([]string, error) {
+	users, err := rm.IRoleManager.GetUsers(name, domains...)
+	if err != nil || name != rm.excluded.name2 || util.Hash(domains) != util.Hash(rm.excluded.domains) {
+		return users, err
+	}
+	res := make([]string, 0, len(users))
+	for _, user := range users {
+		if user != rm.excluded.name1 {
+			res = append(res, user)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,226 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rbac
+
+import (
+	"sync"
+	"time"
+
+	"github.com/abichinger/fastac/util"
+)
+
+// RoleProvider answers role queries from an external source, e.g. a directory service.
+// The links of a provider are read-only, they are neither enumerated by Range nor stored by adapters.
+type RoleProvider interface {
+	// GetRoles returns the roles, which are directly inherited by name
+	GetRoles(name string, domains ...string) ([]string, error)
+	// GetUsers returns the users, which directly inherit the role name
+	GetUsers(name string, domains ...string) ([]string, error)
+}
+
+type cacheEntry struct {
+	values  []string
+	expires time.Time
+}
+
+// providerCache caches the answers of a RoleProvider, a ttl <= 0 caches answers until they are invalidated
+type providerCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cacheEntry
+}
+
+func newProviderCache(ttl time.Duration) *providerCache {
+	return &providerCache{ttl: ttl, entries: map[string]cacheEntry{}}
+}
+
+func (c *providerCache) get(name string, domains []string, load func() ([]string, error)) ([]string, error) {
+	key := util.Hash(append([]string{name}, domains...))
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && (c.ttl <= 0 || time.Now().Before(entry.expires)) {
+		return entry.values, nil
+	}
+
+	values, err := load()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[key] = cacheEntry{values: values, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return values, nil
+}
+
+func (c *providerCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]cacheEntry{}
+}
+
+// ProviderRoleManager combines the links of a RoleProvider with local links.
+// Local links are managed by the wrapped role manager, pattern matchers only apply to local links.
+//
+//  rm := rbac.NewProviderRoleManager(provider, rbac.NewRoleManager(10), 10, time.Minute)
+type ProviderRoleManager struct {
+	IDefaultRoleManager
+	provider          RoleProvider
+	maxHierarchyLevel int
+	roles             *providerCache
+	users             *providerCache
+}
+
+// NewProviderRoleManager creates a ProviderRoleManager, the answers of the provider are cached for ttl
+func NewProviderRoleManager(provider RoleProvider, local IDefaultRoleManager, maxHierarchyLevel int, ttl time.Duration) *ProviderRoleManager {
+	return &ProviderRoleManager{
+		IDefaultRoleManager: local,
+		provider:            provider,
+		maxHierarchyLevel:   maxHierarchyLevel,
+		roles:               newProviderCache(ttl),
+		users:               newProviderCache(ttl),
+	}
+}
+
+// Provider returns the RoleProvider of the role manager
+func (prm *ProviderRoleManager) Provider() RoleProvider {
+	return prm.provider
+}
+
+// Invalidate removes all cached answers of the provider
+func (prm *ProviderRoleManager) Invalidate() {
+	prm.roles.clear()
+	prm.users.clear()
+}
+
+// SetMaxHierarchyLevel sets the maximum depth of role inheritance
+func (prm *ProviderRoleManager) SetMaxHierarchyLevel(level int) {
+	prm.maxHierarchyLevel = level
+	prm.IDefaultRoleManager.SetMaxHierarchyLevel(level)
+}
+
+// SetDomainHierarchy sets the domain hierarchy of the local role manager
+func (prm *ProviderRoleManager) SetDomainHierarchy(hierarchy IRoleManager) {
+	if rm, ok := prm.IDefaultRoleManager.(IDomainHierarchyManager); ok {
+		rm.SetDomainHierarchy(hierarchy)
+	}
+}
+
+// Clear removes all local links and cached answers
+func (prm *ProviderRoleManager) Clear() error {
+	prm.Invalidate()
+	return prm.IDefaultRoleManager.Clear()
+}
+
+func (prm *ProviderRoleManager) providerRoles(name string, domains []string) ([]string, error) {
+	return prm.roles.get(name, domains, func() ([]string, error) {
+		return prm.provider.GetRoles(name, domains...)
+	})
+}
+
+func (prm *ProviderRoleManager) providerUsers(name string, domains []string) ([]string, error) {
+	return prm.users.get(name, domains, func() ([]string, error) {
+		return prm.provider.GetUsers(name, domains...)
+	})
+}
+
+// HasLink determines whether role: name1 inherits role: name2, local and provided links are combined
+func (prm *ProviderRoleManager) HasLink(name1 string, name2 string, domains ...string) (bool, error) {
+	if ok, err := prm.IDefaultRoleManager.HasLink(name1, name2, domains...); ok || err != nil {
+		return ok, err
+	}
+
+	visited := map[string]bool{name1: true}
+	current := []string{name1}
+	for level := 1; level < prm.maxHierarchyLevel && len(current) > 0; level++ {
+		next := []string{}
+		for _, name := range current {
+			roles, err := prm.GetRoles(name, domains...)
+			if err != nil {
+				return false, err
+			}
+			for _, role := range roles {
+				if role == name2 {
+					return true, nil
+				}
+				if !visited[role] {
+					visited[role] = true
+					next = append(next, role)
+				}
+			}
+		}
+		current = next
+	}
+	return false, nil
+}
+
+// GetRoles returns the local and provided roles, which are directly inherited by name
+func (prm *ProviderRoleManager) GetRoles(name string, domains ...string) ([]string, error) {
+	local, err := prm.IDefaultRoleManager.GetRoles(name, domains...)
+	if err != nil {
+		return nil, err
+	}
+	provided, err := prm.providerRoles(name, domains)
+	if err != nil {
+		return nil, err
+	}
+	return union(local, provided), nil
+}
+
+// linkSources returns the local links, which let name1 directly inherit name2, and the provided link
+func (prm *ProviderRoleManager) linkSources(name1 string, name2 string, domains ...string) []link {
+	sources := []link{}
+	if resolver, ok := prm.IDefaultRoleManager.(linkResolver); ok {
+		sources = resolver.linkSources(name1, name2, domains...)
+	}
+	if provided, err := prm.providerRoles(name1, domains); err == nil && contains(provided, name2) {
+		sources = append(sources, link{name1: name1, name2: name2, domains: domains})
+	}
+	return sources
+}
+
+// matchesPattern returns true, if name is matched by the role pattern of the local role manager
+func (prm *ProviderRoleManager) matchesPattern(name string, pattern string) bool {
+	resolver, ok := prm.IDefaultRoleManager.(linkResolver)
+	return ok && resolver.matchesPattern(name, pattern)
+}
+
+func (prm *ProviderRoleManager) hierarchyLevel() int {
+	return prm.maxHierarchyLevel
+}
+
+// GetUsers returns the local and provided users, which directly inherit the role name
+func (prm *ProviderRoleManager) GetUsers(name string, domains ...string) ([]string, error) {
+	local, err := prm.IDefaultRoleManager.GetUsers(name, domains...)
+	if err != nil {
+		return nil, err
+	}
+	provided, err := prm.providerUsers(name, domains)
+	if err != nil {
+		return nil, err
+	}
+	return union(local, provided), nil
+}
+
+func union(a, b []string) []string {
+	res := append([]string{}, a...)
+	for _, value := range b {
+		if !contains(res, value) {
+			res = append(res, value)
+		}
+	}
+	return res
+}
@@ -28,16 +28,19 @@ type RoleManager struct {
 	allRoles          *sync.Map
 	patternRoles      *sync.Map
 	maxHierarchyLevel int
+	rejectCycles      bool
+	index             *closureIndex
 	matcher           util.IMatcher
 	domainMatcher     util.IMatcher
 	matchingFuncCache *util.SyncLRUCache
+	eventEmitter
 }
 
 // NewRoleManager is the constructor for creating an instance of the
 // default RoleManager implementation.
 func NewRoleManager(maxHierarchyLevel int) *RoleManager {
-	rm := RoleManager{}
-	_ = rm.Clear() //init allRoles and matchingFuncCache
+	rm := RoleManager{eventEmitter: newEventEmitter()}
+	rm.reset() //init allRoles and matchingFuncCache
 	rm.maxHierarchyLevel = maxHierarchyLevel
 	return &rm
 }
@@ -49,14 +52,69 @@ func newRoleManagerWithMatchingFunc(maxHierarchyLevel int, matcher util.IMatcher
 	return rm
 }
 
-// rebuilds role cache
+// rebuilds role cache, the changed pattern matches are emitted
 func (rm *RoleManager) rebuild() {
 	roles := rm.allRoles
-	_ = rm.Clear()
+	before := rm.matches()
+
+	rm.muted = true
+	rm.reset()
 	rangeLinks(roles, func(name1, name2 string, domain ...string) bool {
 		_, _ = rm.AddLink(name1, name2, domain...)
 		return true
 	})
+	rm.muted = false
+
+	after := rm.matches()
+	for match := range before {
+		if !after[match] {
+			rm.emit(EVT_PATTERN_UNMATCHED, match[0], match[1])
+		}
+	}
+	for match := range after {
+		if !before[match] {
+			rm.emit(EVT_PATTERN_MATCHED, match[0], match[1])
+		}
+	}
+}
+
+// matches returns all pairs of role and matching pattern role
+func (rm *RoleManager) matches() map[[2]string]bool {
+	res := map[[2]string]bool{}
+	rm.allRoles.Range(func(_, value interface{}) bool {
+		role := value.(*Role)
+		role.matchedBy.Range(func(key, _ interface{}) bool {
+			res[[2]string{role.name, key.(string)}] = true
+			return true
+		})
+		return true
+	})
+	return res
+}
+
+// emitMatches emits the pattern matches of a created role
+func (rm *RoleManager) emitMatches(role *Role) {
+	role.matched.Range(func(key, _ interface{}) bool {
+		rm.emit(EVT_PATTERN_MATCHED, key.(string), role.name)
+		return true
+	})
+	role.matchedBy.Range(func(key, _ interface{}) bool {
+		rm.emit(EVT_PATTERN_MATCHED, role.name, key.(string))
+		return true
+	})
+}
+
+// getLinkRoles loads or creates the roles of a link, the pattern matches of created roles are emitted
+func (rm *RoleManager) getLinkRoles(name1 string, name2 string) (*Role, *Role) {
+	user, created := rm.getRole(name1)
+	if created {
+		rm.emitMatches(user)
+	}
+	role, created := rm.getRole(name2)
+	if created {
+		rm.emitMatches(role)
+	}
+	return user, role
 }
 
 func (rm *RoleManager) match(str string, pattern string) bool {
@@ -70,6 +128,11 @@ func (rm *RoleManager) match(str string, pattern string) bool {
 	}
 }
 
+// isPatternOf returns true, if pattern is a pattern role matching name
+func (rm *RoleManager) isPatternOf(pattern string, name string) bool {
+	return rm.matcher != nil && rm.matcher.IsPattern(pattern) && rm.match(name, pattern)
+}
+
 func (rm *RoleManager) rangeMatchingRoles(pattern string, fn func(role *Role)) {
 	rm.allRoles.Range(func(key, value interface{}) bool {
 		name := key.(string)
@@ -150,38 +213,109 @@ func (rm *RoleManager) SetDomainMatcher(matcher util.IMatcher) {
 	rm.domainMatcher = matcher
 }
 
+// SetMaxHierarchyLevel sets the maximum depth of role inheritance, which is considered by HasLink
+func (rm *RoleManager) SetMaxHierarchyLevel(level int) {
+	rm.maxHierarchyLevel = level
+	rm.resetIndex()
+}
+
+// SetRejectCycles enables cycle detection, AddLink returns a *CycleError for links, which would close a cycle
+func (rm *RoleManager) SetRejectCycles(reject bool) {
+	rm.rejectCycles = reject
+}
+
+// SetClosureIndex enables a memoised transitive closure, which answers HasLink without traversing the role hierarchy.
+// The index is updated by AddLink and DeleteLink.
+func (rm *RoleManager) SetClosureIndex(enabled bool) {
+	if !enabled {
+		rm.index = nil
+	} else if rm.index == nil {
+		rm.index = newClosureIndex()
+	}
+}
+
 // Clear clears all stored data and resets the role manager to the initial state.
 func (rm *RoleManager) Clear() error {
+	rm.reset()
+	rm.emit(EVT_ROLES_CLEARED, "", "")
+	return nil
+}
+
+func (rm *RoleManager) reset() {
 	rm.matchingFuncCache = util.NewSyncLRUCache(100)
 	rm.allRoles = &sync.Map{}
 	rm.patternRoles = &sync.Map{}
-	return nil
+	rm.resetIndex()
+}
+
+func (rm *RoleManager) resetIndex() {
+	if rm.index != nil {
+		rm.index.reset()
+	}
+}
+
+// invalidateIndex updates the index after the roles of name changed
+func (rm *RoleManager) invalidateIndex(name string) {
+	if rm.index != nil {
+		rm.index.invalidate(name)
+	}
 }
 
 // AddLink adds the inheritance link between role: name1 and role: name2.
 // aka role: name1 inherits role: name2.
 func (rm *RoleManager) AddLink(name1 string, name2 string, domains ...string) (bool, error) {
-	user, _ := rm.getRole(name1)
-	role, _ := rm.getRole(name2)
+	if rm.rejectCycles {
+		if path := rm.findPath(name2, name1); path != nil {
+			return false, &CycleError{Cycle: append([]string{name1}, path...)}
+		}
+	}
+
+	user, role := rm.getLinkRoles(name1, name2)
 
-	if len(domains) > 0 && domains[0] == REDUNDANT_ROLE {
+	redundant := len(domains) > 0 && domains[0] == REDUNDANT_ROLE
+	if redundant {
 		user.redundant.LoadOrStore(name2, nil)
 	}
 
-	return user.addRole(role), nil
+	added := user.addRole(role)
+	if added {
+		rm.invalidateIndex(name1)
+		if !redundant {
+			rm.emit(EVT_LINK_ADDED, name1, name2)
+		}
+	}
+	return added, nil
 }
 
 // DeleteLink deletes the inheritance link between role: name1 and role: name2.
 // aka role: name1 does not inherit role: name2 any more.
 func (rm *RoleManager) DeleteLink(name1 string, name2 string, domains ...string) (bool, error) {
-	user, _ := rm.getRole(name1)
-	role, _ := rm.getRole(name2)
+	//unknown roles have no links, they are not created
+	user, ok := rm.load(name1)
+	if !ok {
+		return false, nil
+	}
+	role, ok := rm.load(name2)
+	if !ok {
+		return false, nil
+	}
 
-	if len(domains) > 0 && domains[0] == REDUNDANT_ROLE {
+	redundant := len(domains) > 0 && domains[0] == REDUNDANT_ROLE
+	if redundant {
 		user.redundant.Delete(name2)
+	} else if _, ok := user.redundant.Load(name2); ok {
+		//a redundant link is not visible to Range
+		redundant = true
 	}
 
-	return user.removeRole(role), nil
+	removed := user.removeRole(role)
+	if removed {
+		rm.invalidateIndex(name1)
+		if !redundant {
+			rm.emit(EVT_LINK_REMOVED, name1, name2)
+		}
+	}
+	return removed, nil
 }
 
 // HasLink determines whether role: name1 inherits role: name2.
@@ -189,7 +323,14 @@ func (rm *RoleManager) HasLink(name1 string, name2 string, domains ...string) (b
 	if name1 == name2 || (rm.matcher != nil && rm.match(name1, name2)) {
 		return true, nil
 	}
+	if rm.index != nil {
+		return rm.hasIndexedLink(name1, name2), nil
+	}
+	return rm.hasLink(name1, name2), nil
+}
 
+// hasLink traverses the role hierarchy
+func (rm *RoleManager) hasLink(name1 string, name2 string) bool {
 	user, userCreated := rm.getRole(name1)
 	role, roleCreated := rm.getRole(name2)
 
@@ -200,7 +341,7 @@ func (rm *RoleManager) HasLink(name1 string, name2 string, domains ...string) (b
 		defer rm.removeRole(role.name)
 	}
 
-	return rm.hasLinkHelper(role.name, map[string]*Role{user.name: user}, rm.maxHierarchyLevel), nil
+	return rm.hasLinkHelper(role.name, map[string]*Role{user.name: user}, rm.maxHierarchyLevel)
 }
 
 func (rm *RoleManager) hasLinkHelper(targetName string, roles map[string]*Role, level int) bool {
@@ -222,6 +363,48 @@ func (rm *RoleManager) hasLinkHelper(targetName string, roles map[string]*Role,
 	return rm.hasLinkHelper(targetName, nextRoles, level-1)
 }
 
+// findPath returns the shortest chain of links from name1 to name2 or nil
+func (rm *RoleManager) findPath(name1 string, name2 string) []string {
+	if name1 == name2 {
+		return []string{name1}
+	}
+	start, ok := rm.load(name1)
+	if !ok {
+		return nil
+	}
+
+	parents := map[string]string{name1: ""}
+	current := []*Role{start}
+	for len(current) > 0 {
+		next := []*Role{}
+		for _, role := range current {
+			found := false
+			role.rangeRoles(func(key, value interface{}) bool {
+				name := key.(string)
+				if _, ok := parents[name]; ok {
+					return true
+				}
+				parents[name] = role.name
+				if name == name2 {
+					found = true
+					return false
+				}
+				next = append(next, value.(*Role))
+				return true
+			})
+			if found {
+				path := []string{name2}
+				for name := parents[name2]; name != ""; name = parents[name] {
+					path = append([]string{name}, path...)
+				}
+				return path
+			}
+		}
+		current = next
+	}
+	return nil
+}
+
 // GetRoles gets the roles that a user inherits.
 func (rm *RoleManager) GetRoles(name string, domains ...string) ([]string, error) {
 	user, created := rm.getRole(name)
@@ -231,6 +414,51 @@ func (rm *RoleManager) GetRoles(name string, domains ...string) ([]string, error
 	return user.getRoles(), nil
 }
 
+// linkSources returns the stored links, which let name1 directly inherit name2.
+// Besides the link itself, these are links to matching pattern roles and links of pattern roles matching name1.
+func (rm *RoleManager) linkSources(name1 string, name2 string, _ ...string) []link {
+	user, created := rm.getRole(name1)
+	if created {
+		defer rm.removeRole(user.name)
+	}
+
+	//redundant links are copies of links in other domains
+	stored := func(user *Role, name string) bool {
+		_, ok := user.roles.Load(name)
+		_, redundant := user.redundant.Load(name)
+		return ok && !redundant
+	}
+
+	sources := []link{}
+	if stored(user, name2) {
+		sources = append(sources, link{name1: name1, name2: name2})
+	}
+	user.roles.Range(func(_, value interface{}) bool {
+		role := value.(*Role)
+		if role.name != name2 && rm.isPatternOf(role.name, name2) && stored(user, role.name) {
+			sources = append(sources, link{name1: name1, name2: role.name})
+		}
+		return true
+	})
+	user.matchedBy.Range(func(_, value interface{}) bool {
+		pattern := value.(*Role)
+		if stored(pattern, name2) {
+			sources = append(sources, link{name1: pattern.name, name2: name2})
+		}
+		return true
+	})
+	return sources
+}
+
+// matchesPattern returns true, if name is matched by the role pattern
+func (rm *RoleManager) matchesPattern(name string, pattern string) bool {
+	return rm.matcher != nil && rm.match(name, pattern)
+}
+
+func (rm *RoleManager) hierarchyLevel() int {
+	return rm.maxHierarchyLevel
+}
+
 // GetUsers gets the users of a role.
 // domain is an unreferenced parameter here, may be used in other implementations.
 func (rm *RoleManager) GetUsers(name string, domain ...string) ([]string, error) {
@@ -252,19 +480,30 @@ func (rm *RoleManager) GetAllDomains() ([]string, error) {
 }
 
 func rangeLinks(users *sync.Map, fn func(name1, name2 string, domain ...string) bool) {
+	next := true
 	users.Range(func(_, value interface{}) bool {
 		user := value.(*Role)
 		user.roles.Range(func(key, _ interface{}) bool {
 			roleName := key.(string)
 			if _, ok := user.redundant.Load(roleName); !ok {
-				return fn(user.name, roleName)
+				next = fn(user.name, roleName)
 			}
-			return true
+			return next
 		})
-		return true
+		return next
 	})
 }
 
 func (rm *RoleManager) Range(fn func(name1, name2 string, domain ...string) bool) {
 	rangeLinks(rm.allRoles, fn)
 }
+
+// Validate reports cycles (*CycleError) and links, which exceed the max hierarchy level (*DepthError)
+func (rm *RoleManager) Validate() []error {
+	links := map[string][]string{}
+	rm.Range(func(name1, name2 string, _ ...string) bool {
+		links[name1] = append(links[name1], name2)
+		return true
+	})
+	return validateLinks(links, rm.maxHierarchyLevel-1)
+}
@@ -15,6 +15,7 @@
 package rbac
 
 import (
+	"github.com/abichinger/fastac/api"
 	"github.com/abichinger/fastac/util"
 	"github.com/abichinger/govaluate"
 )
@@ -42,11 +43,43 @@ type IRoleManager interface {
 	Range(fn func(name1, name2 string, domain ...string) bool)
 }
 
-type IDefaultRoleManager interface {
+// IRoleEventEmitter is a role manager, which emits a *RoleEvent for every change (EVT_LINK_ADDED, EVT_PATTERN_MATCHED, ...)
+type IRoleEventEmitter interface {
 	IRoleManager
+	api.IAddRemoveListener
+}
+
+type IDefaultRoleManager interface {
+	IRoleEventEmitter
 
 	SetMatcher(fn util.IMatcher)
 	SetDomainMatcher(fn util.IMatcher)
+	SetMaxHierarchyLevel(level int)
+	// SetRejectCycles enables cycle detection, AddLink returns a *CycleError for links, which would close a cycle.
+	SetRejectCycles(reject bool)
+	// SetClosureIndex enables a memoised transitive closure, which is used by HasLink.
+	SetClosureIndex(enabled bool)
+	// Validate reports cycles (*CycleError) and links, which exceed the max hierarchy level (*DepthError).
+	Validate() []error
+}
+
+// IDomainHierarchyManager is a role manager, which supports inheritance of links from parent domains
+type IDomainHierarchyManager interface {
+	IRoleManager
+
+	// SetDomainHierarchy sets the role manager, which links domains to their parent domains.
+	SetDomainHierarchy(hierarchy IRoleManager)
+}
+
+// IConditionalRoleManager is a role manager, which evaluates conditions of links with request arguments
+type IConditionalRoleManager interface {
+	IRoleManager
+
+	// HasConditionalLink determines whether role: name1 inherits role: name2.
+	// args are the request values, which are passed to the g function after the domains.
+	HasConditionalLink(name1 string, name2 string, domains []string, args ...interface{}) (bool, error)
+	// DomainCount returns the number of domain arguments
+	DomainCount() int
 }
 
 // GenerateGFunction is the factory method of the g(_, _) function.
@@ -56,6 +89,18 @@ func GenerateGFunction(rm IRoleManager) govaluate.ExpressionFunction {
 		name1 := args[0].(string)
 		name2 := args[1].(string)
 
+		if crm, ok := rm.(IConditionalRoleManager); ok {
+			n := 2 + crm.DomainCount()
+			if n > len(args) {
+				n = len(args)
+			}
+			domains := []string{}
+			for _, domain := range args[2:n] {
+				domains = append(domains, domain.(string))
+			}
+			return crm.HasConditionalLink(name1, name2, domains, args[n:]...)
+		}
+
 		if rm == nil {
 			return name1 == name2, nil
 		} else if len(args) == 2 {
@@ -0,0 +1,77 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rbac
+
+import (
+	"fmt"
+	"testing"
+)
+
+// genHierarchy creates nUsers users, every user inherits one of nRoles role chains with the given depth
+func genHierarchy(rm IRoleManager, nUsers int, nRoles int, depth int) {
+	for i := 0; i < nRoles; i++ {
+		for d := 1; d < depth; d++ {
+			_, _ = rm.AddLink(fmt.Sprintf("role%d_%d", i, d-1), fmt.Sprintf("role%d_%d", i, d))
+		}
+	}
+	for i := 0; i < nUsers; i++ {
+		_, _ = rm.AddLink(fmt.Sprintf("user%d", i), fmt.Sprintf("role%d_0", i%nRoles))
+	}
+}
+
+func BenchmarkHasLink(b *testing.B) {
+	bmUsers := []int{100, 10000}
+
+	bmDepth := []int{2, 5, 9}
+
+	for _, indexed := range []bool{false, true} {
+		b.Run(fmt.Sprintf("index=%t", indexed), func(b *testing.B) {
+			for _, nUsers := range bmUsers {
+				for _, depth := range bmDepth {
+					b.Run(fmt.Sprintf("users=%d/depth=%d", nUsers, depth), func(b *testing.B) {
+						rm := NewRoleManager(10)
+						rm.SetClosureIndex(indexed)
+						genHierarchy(rm, nUsers, 10, depth)
+						target := fmt.Sprintf("role0_%d", depth-1)
+
+						b.ResetTimer()
+						for i := 0; i < b.N; i++ {
+							_, _ = rm.HasLink("user0", target) //returns true
+						}
+					})
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAddLink(b *testing.B) {
+	for _, indexed := range []bool{false, true} {
+		b.Run(fmt.Sprintf("index=%t", indexed), func(b *testing.B) {
+			rm := NewRoleManager(10)
+			rm.SetClosureIndex(indexed)
+			genHierarchy(rm, 1000, 10, 5)
+			for i := 0; i < 1000; i++ {
+				_, _ = rm.HasLink(fmt.Sprintf("user%d", i), "role0_4")
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = rm.AddLink("role0_4", "role1_0")
+				_, _ = rm.DeleteLink("role0_4", "role1_0")
+			}
+		})
+	}
+}
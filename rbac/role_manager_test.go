@@ -16,8 +16,11 @@ package rbac
 
 import (
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/abichinger/fastac/model/policy"
 	"github.com/abichinger/fastac/util"
 	"github.com/stretchr/testify/assert"
 )
@@ -491,3 +494,644 @@ func TestSubdomain(t *testing.T) {
 	testDomainRole(t, rm, false, "bob", "user", "domain3", "sub1")
 	testDomainRole(t, rm, false, "bob", "user", "domain3", "sub2")
 }
+
+func TestConditionalRole(t *testing.T) {
+	rm := NewConditionalRoleManager(NewDomainManager(10), 1, 10, TimeWindow)
+
+	testAddLink(t, rm, true, "alice", "oncall", "domain1", "2022-06-01T00:00:00Z", "2022-06-08T00:00:00Z")
+	testAddLink(t, rm, false, "alice", "oncall", "domain1", "2022-06-01T00:00:00Z", "2022-06-08T00:00:00Z")
+	//adding a link with other parameters is rejected, the parameters are changed by UpdateRule
+	testAddLink(t, rm, false, "alice", "oncall", "domain1", "2022-06-01T00:00:00Z", "2022-06-09T00:00:00Z")
+	updated, err := NewRolePolicy(rm).UpdateRule(
+		[]string{"alice", "oncall", "domain1", "2022-06-01T00:00:00Z", "2022-06-08T00:00:00Z"},
+		[]string{"alice", "oncall", "domain1", "2022-06-01T00:00:00Z", "2022-06-09T00:00:00Z"},
+	)
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	testAddLink(t, rm, true, "oncall", "admin", "domain1")
+	testAddLink(t, rm, true, "bob", "admin", "domain1", "_", "2022-06-01T00:00:00Z")
+
+	hasLink := func(name1, name2, time string) bool {
+		t.Helper()
+		ok, err := rm.HasConditionalLink(name1, name2, []string{"domain1"}, time)
+		assert.NoError(t, err)
+		return ok
+	}
+
+	assert.False(t, hasLink("alice", "admin", "2022-05-31T00:00:00Z"))
+	assert.True(t, hasLink("alice", "admin", "2022-06-08T12:00:00Z"))
+	assert.False(t, hasLink("alice", "admin", "2022-06-09T00:00:00Z"))
+	assert.True(t, hasLink("bob", "admin", "2000-01-01T00:00:00Z"))
+	assert.False(t, hasLink("bob", "admin", "2022-06-01T00:00:00Z"))
+	assert.True(t, hasLink("oncall", "admin", "2000-01-01T00:00:00Z"))
+
+	_, err = rm.HasConditionalLink("alice", "admin", []string{"domain1"}, "yesterday")
+	assert.Error(t, err)
+
+	links := []string{}
+	rm.Range(func(name1, name2 string, values ...string) bool {
+		links = append(links, strings.Join(append([]string{name1, name2}, values...), ","))
+		return true
+	})
+	assert.ElementsMatch(t, []string{
+		"alice,oncall,domain1,2022-06-01T00:00:00Z,2022-06-09T00:00:00Z",
+		"oncall,admin,domain1",
+		"bob,admin,domain1,_,2022-06-01T00:00:00Z",
+	}, links)
+
+	testDeleteLink(t, rm, true, "bob", "admin", "domain1", "_", "2022-06-01T00:00:00Z")
+	testDomainRole(t, rm, false, "bob", "admin", "domain1")
+}
+
+func TestConditionalPatternRole(t *testing.T) {
+	rm := NewConditionalRoleManager(NewRoleManager(10), 0, 10, TimeWindow)
+	rm.SetMatcher(util.PathMatcher)
+
+	testAddLink(t, rm, true, "alice", "book/:id", "2000-01-01T00:00:00Z", "2000-01-02T00:00:00Z")
+	testAddLink(t, rm, true, "user/:id", "reader", "2000-01-01T00:00:00Z", "2000-01-02T00:00:00Z")
+	testAddLink(t, rm, true, "bob", "book/1")
+
+	hasLink := func(name1, name2, time string) bool {
+		t.Helper()
+		ok, err := rm.HasConditionalLink(name1, name2, []string{}, time)
+		assert.NoError(t, err)
+		return ok
+	}
+
+	//links produced by pattern matching inherit the condition of the pattern link
+	assert.False(t, hasLink("alice", "book/:id", "2022-01-01T00:00:00Z"))
+	assert.False(t, hasLink("alice", "book/1", "2022-01-01T00:00:00Z"))
+	assert.True(t, hasLink("alice", "book/1", "2000-01-01T12:00:00Z"))
+	assert.False(t, hasLink("user/1", "reader", "2022-01-01T00:00:00Z"))
+	assert.True(t, hasLink("user/1", "reader", "2000-01-01T12:00:00Z"))
+	assert.True(t, hasLink("bob", "book/1", "2022-01-01T00:00:00Z"))
+
+	dm := NewConditionalRoleManager(NewDomainManager(10), 1, 10, TimeWindow)
+	dm.SetMatcher(util.PathMatcher)
+	dm.SetDomainMatcher(util.PathMatcher)
+	testAddLink(t, dm, true, "alice", "book/:id", "org/:id", "2000-01-01T00:00:00Z", "2000-01-02T00:00:00Z")
+	ok, err := dm.HasConditionalLink("alice", "book/1", []string{"org/1"}, "2022-01-01T00:00:00Z")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = dm.HasConditionalLink("alice", "book/1", []string{"org/1"}, "2000-01-01T12:00:00Z")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestHasLinkExcluding(t *testing.T) {
+	rm := NewRoleManager(10)
+	rm.SetMatcher(util.PathMatcher)
+	testAddLink(t, rm, true, "alice", "admin")
+	testAddLink(t, rm, true, "bob", "book/:id")
+	testAddLink(t, rm, true, "admin", "book/:id")
+
+	hasLink := func(excluded [][]string, name1, name2 string) bool {
+		t.Helper()
+		ok, err := HasLinkExcluding(rm, excluded, name1, name2)
+		assert.NoError(t, err)
+		return ok
+	}
+
+	assert.True(t, hasLink(nil, "alice", "book/1"))
+	assert.False(t, hasLink([][]string{{"alice", "admin"}}, "alice", "book/1"))
+	assert.False(t, hasLink([][]string{{"bob", "book/:id"}}, "bob", "book/1"))
+	assert.True(t, hasLink([][]string{{"bob", "book/:id"}}, "alice", "book/1"))
+	assert.True(t, hasLink([][]string{{"alice", "admin"}}, "alice", "alice"))
+}
+
+func TestRejectCycles(t *testing.T) {
+	rm := NewRoleManager(10)
+	rm.SetRejectCycles(true)
+
+	testAddLink(t, rm, true, "a", "b")
+	testAddLink(t, rm, true, "b", "c")
+
+	_, err := rm.AddLink("c", "a")
+	cErr, ok := err.(*CycleError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"c", "a", "b", "c"}, cErr.Cycle)
+	testRole(t, rm, "c", "a", false)
+
+	_, err = rm.AddLink("d", "d")
+	assert.IsType(t, &CycleError{}, err)
+	testPrintRoles(t, rm, "d", []string{})
+
+	dm := NewDomainManager(10)
+	dm.SetRejectCycles(true)
+	testAddLink(t, dm, true, "a", "b", "domain1")
+	testAddLink(t, dm, true, "b", "a", "domain2")
+	_, err = dm.AddLink("b", "a", "domain1")
+	assert.EqualError(t, err, "error: role cycle b -> a -> b in domain domain1")
+}
+
+func TestValidate(t *testing.T) {
+	rm := NewRoleManager(3)
+	testAddLink(t, rm, true, "a", "b")
+	testAddLink(t, rm, true, "b", "a")
+	testAddLink(t, rm, true, "u", "r1")
+	testAddLink(t, rm, true, "r1", "r2")
+	testAddLink(t, rm, true, "r2", "r3")
+
+	errs := rm.Validate()
+	assert.Equal(t, []error{
+		&CycleError{Cycle: []string{"a", "b", "a"}},
+		&DepthError{User: "u", Role: "r3", Depth: 3, MaxDepth: 2},
+	}, errs)
+
+	dm := NewDomainManager(10)
+	testAddLink(t, dm, true, "a", "b", "domain1")
+	testAddLink(t, dm, true, "b", "a", "domain1")
+	testAddLink(t, dm, true, "a", "b", "domain2")
+	errs = dm.Validate()
+	assert.Equal(t, []error{&CycleError{Domain: []string{"domain1"}, Cycle: []string{"a", "b", "a"}}}, errs)
+}
+
+func TestClosureIndex(t *testing.T) {
+	names := []string{"u0", "u1", "u2", "r0", "r1", "r2", "r3", "/book/:id", "/book/1", "/pen/1"}
+	links := [][]string{
+		{"u0", "r0"}, {"r0", "r1"}, {"r1", "r2"}, {"r2", "r3"},
+		{"u1", "r1"}, {"u2", "/book/:id"}, {"/book/:id", "r3"},
+		{"/book/1", "r0"}, {"r3", "u0"}, {"u1", "r0"},
+	}
+
+	compare := func(rm, indexed IRoleManager) {
+		t.Helper()
+		for _, name1 := range names {
+			for _, name2 := range append(names, "unknown") {
+				expected, _ := rm.HasLink(name1, name2)
+				actual, _ := indexed.HasLink(name1, name2)
+				assert.Equal(t, expected, actual, "%s -> %s", name1, name2)
+			}
+		}
+	}
+
+	for _, matcher := range []util.IMatcher{nil, util.PathMatcher} {
+		rm := NewRoleManager(3)
+		indexed := NewRoleManager(3)
+		indexed.SetClosureIndex(true)
+		if matcher != nil {
+			rm.SetMatcher(matcher)
+			indexed.SetMatcher(matcher)
+		}
+
+		for _, link := range links {
+			_, _ = rm.AddLink(link[0], link[1])
+			_, _ = indexed.AddLink(link[0], link[1])
+			compare(rm, indexed)
+		}
+		for _, link := range links {
+			_, _ = rm.DeleteLink(link[0], link[1])
+			_, _ = indexed.DeleteLink(link[0], link[1])
+			compare(rm, indexed)
+		}
+	}
+
+	dm := NewDomainManager(10)
+	dm.SetClosureIndex(true)
+	testAddLink(t, dm, true, "alice", "admin", "domain1")
+	testAddLink(t, dm, true, "admin", "staff", "domain1")
+	testDomainRole(t, dm, true, "alice", "staff", "domain1")
+	testDomainRole(t, dm, false, "alice", "staff", "domain2")
+	testDeleteLink(t, dm, true, "admin", "staff", "domain1")
+	testDomainRole(t, dm, false, "alice", "staff", "domain1")
+}
+
+func TestDomainHierarchy(t *testing.T) {
+	hierarchy := NewRoleManager(10)
+	_, _ = hierarchy.AddLink("project1", "team1")
+	_, _ = hierarchy.AddLink("team1", "org1")
+	_, _ = hierarchy.AddLink("team2", "org1")
+
+	dm := NewDomainManager(10)
+	dm.SetDomainHierarchy(hierarchy)
+	testAddLink(t, dm, true, "alice", "admin", "org1")
+	testAddLink(t, dm, true, "admin", "auditor", "project1")
+	testAddLink(t, dm, true, "bob", "reader", "team1")
+
+	testDomainRole(t, dm, true, "alice", "admin", "project1")
+	testDomainRole(t, dm, true, "alice", "admin", "team2")
+	testDomainRole(t, dm, true, "alice", "auditor", "project1")
+	testDomainRole(t, dm, false, "alice", "auditor", "team1")
+	testDomainRole(t, dm, true, "bob", "reader", "project1")
+	testDomainRole(t, dm, false, "bob", "reader", "org1")
+	testDomainRole(t, dm, false, "bob", "reader", "team2")
+	testPrintRolesWithDomain(t, dm, "admin", "project1", []string{"auditor"})
+	testPrintRolesWithDomain(t, dm, "alice", "project1", []string{"admin"})
+
+	dm.SetDomainHierarchy(nil)
+	testDomainRole(t, dm, false, "alice", "admin", "project1")
+}
+
+func TestDomainHierarchyWithPatterns(t *testing.T) {
+	hierarchy := NewRoleManager(10)
+	hierarchy.SetMatcher(util.PathMatcher)
+	_, _ = hierarchy.AddLink("/org1/:team", "/org1")
+	_, _ = hierarchy.AddLink("/org1/team1/:project", "/org1/team1")
+
+	dm := NewDomainManager(10)
+	dm.SetDomainHierarchy(hierarchy)
+	dm.SetDomainMatcher(util.PathMatcher)
+	dm.SetMatcher(util.PathMatcher)
+	testAddLink(t, dm, true, "alice", "admin", "/org1")
+	testAddLink(t, dm, true, "bob", "reader", "/org1/:team")
+	testAddLink(t, dm, true, "reader", "/book/:id", "/org1/team1")
+
+	testDomainRole(t, dm, true, "alice", "admin", "/org1/team2")
+	testDomainRole(t, dm, true, "alice", "admin", "/org1/team1/project1")
+	testDomainRole(t, dm, false, "alice", "admin", "/org2/team1")
+	testDomainRole(t, dm, true, "bob", "reader", "/org1/team1/project1")
+	testDomainRole(t, dm, false, "bob", "reader", "/org1")
+	testDomainRole(t, dm, true, "bob", "/book/1", "/org1/team1/project1")
+	testDomainRole(t, dm, false, "bob", "/book/1", "/org1/team2")
+}
+
+func TestConstraints(t *testing.T) {
+	rp := NewRolePolicy(NewRoleManager(10))
+	rp.SetConstraint("c", NewSeparationOfDuty("c", "approver", "requester"))
+	rp.SetConstraint("c2", NewRoleCardinality("c2", "admin", 2))
+
+	for _, rule := range [][]string{
+		{"alice", "requester"},
+		{"bob", "approver"},
+		{"lead", "approver"},
+		{"carol", "admin"},
+		{"dave", "admin"},
+	} {
+		_, err := rp.AddRule(rule)
+		assert.NoError(t, err)
+	}
+
+	_, err := rp.AddRule([]string{"alice", "approver"})
+	assert.Equal(t, &SoDError{Constraint: "c", Domain: []string{}, User: "alice", Roles: []string{"approver", "requester"}}, err)
+	assert.EqualError(t, err, "error: constraint c violated, alice must not hold more than one of the roles approver, requester")
+
+	// roles held through inheritance
+	_, err = rp.AddRule([]string{"approver", "requester"})
+	assert.IsType(t, &SoDError{}, err)
+	_, err = rp.AddRule([]string{"team", "requester"})
+	assert.NoError(t, err)
+	_, err = rp.AddRule([]string{"bob", "team"})
+	assert.IsType(t, &SoDError{}, err)
+
+	_, err = rp.AddRule([]string{"erin", "admin"})
+	assert.EqualError(t, err, "error: constraint c2 violated, role admin must not have more than 2 users")
+	added, err := rp.AddRule([]string{"carol", "admin"})
+	assert.False(t, added)
+	assert.NoError(t, err)
+
+	_, err = rp.UpdateRule([]string{"lead", "approver"}, []string{"lead", "requester"})
+	assert.NoError(t, err)
+	_, err = rp.UpdateRule([]string{"lead", "requester"}, []string{"alice", "approver"})
+	assert.IsType(t, &SoDError{}, err)
+	testRole(t, rp.GetRoleManager(), "lead", "requester", true)
+
+	assert.Empty(t, rp.ValidateConstraints())
+	rp.SetConstraint("c3", NewRoleCardinality("c3", "admin", 1))
+	rp.SetConstraint("c4", NewSeparationOfDuty("c4", "admin", "requester"))
+	_, _ = rp.rm.AddLink("carol", "team")
+	assert.Equal(t, []error{
+		&CardinalityError{Constraint: "c3", Role: "admin", Max: 1},
+		&SoDError{Constraint: "c4", User: "carol", Roles: []string{"admin", "requester"}},
+	}, rp.ValidateConstraints())
+
+	dp := NewRolePolicy(NewDomainManager(10))
+	dp.SetConstraint("c", NewRoleCardinality("c", "admin", 1))
+	_, err = dp.AddRule([]string{"alice", "admin", "domain1"})
+	assert.NoError(t, err)
+	_, err = dp.AddRule([]string{"bob", "admin", "domain2"})
+	assert.NoError(t, err)
+	_, err = dp.AddRule([]string{"bob", "admin", "domain1"})
+	assert.EqualError(t, err, "error: constraint c violated, role admin must not have more than 1 users in domain domain1")
+}
+
+func TestRolePolicyHasRule(t *testing.T) {
+	rm := NewRoleManager(10)
+	rm.SetMatcher(util.PathMatcher)
+	rp := NewRolePolicy(rm)
+	_, _ = rp.AddRule([]string{"book/*", "reader"})
+	_, _ = rp.AddRule([]string{"reader", "guest"})
+	assert.True(t, rp.HasRule([]string{"book/*", "reader"}))
+	//links produced by patterns or inheritance are not stored
+	assert.False(t, rp.HasRule([]string{"book/1", "reader"}))
+	assert.False(t, rp.HasRule([]string{"book/*", "guest"}))
+
+	dp := NewRolePolicy(NewDomainManager(10))
+	_, _ = dp.AddRule([]string{"alice", "admin", "domain1"})
+	assert.True(t, dp.HasRule([]string{"alice", "admin", "domain1"}))
+	assert.False(t, dp.HasRule([]string{"alice", "admin", "domain2"}))
+	assert.False(t, dp.HasRule([]string{"alice", "admin"}))
+
+	//parameters of conditional links are ignored
+	cp := NewRolePolicy(NewConditionalRoleManager(NewDomainManager(10), 1, 10, TimeWindow))
+	_, _ = cp.AddRule([]string{"alice", "oncall", "domain1", "_", "2022-06-01T00:00:00Z"})
+	assert.True(t, cp.HasRule([]string{"alice", "oncall", "domain1", "_", "2022-06-09T00:00:00Z"}))
+	assert.False(t, cp.HasRule([]string{"alice", "oncall", "domain2"}))
+}
+
+func TestRolePolicyUpdateRule(t *testing.T) {
+	rp := NewRolePolicy(NewRoleManager(10))
+	events := 0
+	rp.AddListener(policy.EVT_RULE_UPDATED, func(arguments ...interface{}) {
+		events++
+	})
+	_, _ = rp.AddRule([]string{"alice", "admin"})
+	_, _ = rp.AddRule([]string{"alice", "user"})
+
+	updated, err := rp.UpdateRule([]string{"alice", "admin"}, []string{"alice", "admin"})
+	assert.NoError(t, err)
+	assert.False(t, updated)
+	//the new link is already present
+	updated, err = rp.UpdateRule([]string{"alice", "admin"}, []string{"alice", "user"})
+	assert.NoError(t, err)
+	assert.False(t, updated)
+	testRole(t, rp.GetRoleManager(), "alice", "admin", true)
+	testRole(t, rp.GetRoleManager(), "alice", "user", true)
+	assert.Equal(t, 0, events)
+
+	updated, err = rp.UpdateRule([]string{"alice", "admin"}, []string{"alice", "guest"})
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	testRole(t, rp.GetRoleManager(), "alice", "admin", false)
+	assert.Equal(t, 1, events)
+}
+
+func TestRolePolicyRejectedUpdate(t *testing.T) {
+	rm := NewRoleManager(10)
+	rp := NewRolePolicy(rm)
+	rp.SetConstraint("c1", NewSeparationOfDuty("c1", "approver", "requester"))
+	rp.SetConstraint("c2", NewRoleCardinality("c2", "admin", 1))
+	_, _ = rp.AddRule([]string{"alice", "approver"})
+	_, _ = rp.AddRule([]string{"bob", "requester"})
+	_, _ = rp.AddRule([]string{"carol", "admin"})
+	events := recordEvents(rm)
+
+	_, err := rp.UpdateRule([]string{"bob", "requester"}, []string{"alice", "requester"})
+	assert.IsType(t, &SoDError{}, err)
+	_, err = rp.UpdateRule([]string{"bob", "requester"}, []string{"bob", "admin"})
+	assert.IsType(t, &CardinalityError{}, err)
+	updated, err := rp.UpdateRule([]string{"bob", "requester"}, []string{"carol", "admin"})
+	assert.NoError(t, err)
+	assert.False(t, updated)
+	updated, err = rp.UpdateRule([]string{"dave", "requester"}, []string{"dave", "admin"})
+	assert.NoError(t, err)
+	assert.False(t, updated)
+	assert.Empty(t, *events)
+
+	//the old link does not count against the constraints
+	_, err = rp.UpdateRule([]string{"alice", "approver"}, []string{"alice", "requester"})
+	assert.NoError(t, err)
+	_, err = rp.UpdateRule([]string{"carol", "admin"}, []string{"bob", "admin"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"link_removed alice approver []",
+		"link_added alice requester []",
+		"link_removed carol admin []",
+		"link_added bob admin []",
+	}, *events)
+}
+
+func TestSessionGFunction(t *testing.T) {
+	rp := NewRolePolicy(NewDomainManager(10))
+	rp.SetConstraint("c", NewDynamicSeparationOfDuty("c", "approver", "requester"))
+	for _, rule := range [][]string{
+		{"alice", "approver", "domain1"},
+		{"alice", "requester", "domain1"},
+		{"alice", "manager", "domain1"},
+		{"manager", "approver", "domain1"},
+		{"manager", "requester", "domain1"},
+		{"approver", "reader", "domain1"},
+	} {
+		_, err := rp.AddRule(rule)
+		assert.NoError(t, err)
+	}
+
+	g := GenerateSessionGFunction(rp, []string{"approver"})
+	for _, test := range []struct {
+		name1, name2 string
+		res          bool
+	}{
+		{"alice", "approver", true},
+		{"alice", "reader", true},
+		{"alice", "requester", false},
+		{"alice", "alice", true},
+		{"bob", "approver", false},
+	} {
+		res, err := g(test.name1, test.name2, "domain1")
+		assert.NoError(t, err)
+		assert.Equal(t, test.res, res, "%s -> %s", test.name1, test.name2)
+	}
+
+	g = GenerateSessionGFunction(rp, []string{"approver", "requester"})
+	_, err := g("alice", "approver", "domain1")
+	assert.EqualError(t, err, "error: constraint c violated, the roles approver, requester must not be activated in the same session in domain domain1")
+	_, err = g("alice", "approver", "domain2")
+	assert.IsType(t, &SessionError{}, err)
+
+	g = GenerateSessionGFunction(rp, []string{"manager"})
+	_, err = g("alice", "approver", "domain1")
+	assert.IsType(t, &SessionError{}, err)
+}
+
+type mapProvider struct {
+	roles map[string][]string
+	calls int
+}
+
+func (p *mapProvider) GetRoles(name string, domains ...string) ([]string, error) {
+	p.calls++
+	return p.roles[name], nil
+}
+
+func (p *mapProvider) GetUsers(name string, domains ...string) ([]string, error) {
+	p.calls++
+	users := []string{}
+	for user, roles := range p.roles {
+		if contains(roles, name) {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func TestProviderRoleManager(t *testing.T) {
+	provider := &mapProvider{roles: map[string][]string{
+		"alice":      {"developers"},
+		"developers": {"staff"},
+	}}
+	rm := NewProviderRoleManager(provider, NewRoleManager(10), 10, 0)
+	testAddLink(t, rm, true, "staff", "reader")
+	testAddLink(t, rm, true, "bob", "developers")
+
+	testRole(t, rm, "alice", "developers", true)
+	testRole(t, rm, "alice", "staff", true)
+	testRole(t, rm, "alice", "reader", true)
+	testRole(t, rm, "bob", "staff", true)
+	testRole(t, rm, "bob", "alice", false)
+	testPrintRoles(t, rm, "staff", []string{"reader"})
+	testPrintUsers(t, rm, "developers", []string{"alice", "bob"})
+
+	// provided links are not enumerated
+	links := [][]string{}
+	rm.Range(func(name1, name2 string, domain ...string) bool {
+		links = append(links, []string{name1, name2})
+		return true
+	})
+	assert.ElementsMatch(t, [][]string{{"staff", "reader"}, {"bob", "developers"}}, links)
+
+	// answers are cached until they are invalidated
+	calls := provider.calls
+	testRole(t, rm, "alice", "reader", true)
+	assert.Equal(t, calls, provider.calls)
+
+	provider.roles["alice"] = []string{}
+	testRole(t, rm, "alice", "reader", true)
+	rm.Invalidate()
+	testRole(t, rm, "alice", "reader", false)
+
+	// answers expire after ttl
+	rm = NewProviderRoleManager(provider, NewRoleManager(10), 10, time.Millisecond)
+	testRole(t, rm, "developers", "staff", true)
+	provider.roles["developers"] = []string{}
+	testRole(t, rm, "developers", "staff", true)
+	time.Sleep(2 * time.Millisecond)
+	testRole(t, rm, "developers", "staff", false)
+}
+
+func recordEvents(rm IRoleEventEmitter) *[]string {
+	events := []string{}
+	for _, event := range roleEvents {
+		event := event
+		rm.AddListener(event, func(arguments ...interface{}) {
+			e := arguments[0].(*RoleEvent)
+			events = append(events, fmt.Sprintf("%s %s %s %v", event, e.Name1, e.Name2, e.Domain))
+		})
+	}
+	return &events
+}
+
+func TestRoleEvents(t *testing.T) {
+	rm := NewRoleManager(10)
+	rm.SetMatcher(util.PathMatcher)
+	events := recordEvents(rm)
+
+	testAddLink(t, rm, true, "book/*", "reader")
+	testAddLink(t, rm, true, "book/1", "book_group")
+	testAddLink(t, rm, false, "book/1", "book_group")
+	testRole(t, rm, "book/2", "reader", true)
+	testDeleteLink(t, rm, true, "book/1", "book_group")
+	//deleting links of unknown roles neither creates roles nor emits events
+	testDeleteLink(t, rm, false, "book/3", "book_group")
+	testDeleteLink(t, rm, false, "book/1", "unknown")
+	_, ok := rm.load("book/3")
+	assert.False(t, ok)
+	_, ok = rm.load("unknown")
+	assert.False(t, ok)
+	assert.Equal(t, []string{
+		"link_added book/* reader []",
+		"pattern_matched book/1 book/* []",
+		"link_added book/1 book_group []",
+		"link_removed book/1 book_group []",
+	}, *events)
+
+	*events = []string{}
+	rm.SetMatcher(nil)
+	_ = rm.Clear()
+	assert.Equal(t, []string{
+		"pattern_unmatched book/1 book/* []",
+		"roles_cleared   []",
+	}, *events)
+
+	dm := NewDomainManager(10)
+	dm.SetDomainMatcher(util.PathMatcher)
+	events = recordEvents(dm)
+
+	testAddLink(t, dm, true, "alice", "admin")
+	testAddLink(t, dm, true, "alice", "admin", "org/*")
+	testAddLink(t, dm, true, "bob", "admin", "org/1")
+	testDeleteLink(t, dm, true, "bob", "admin", "org/1")
+	testDeleteLink(t, dm, false, "bob", "admin", "org/2")
+	testDeleteLink(t, dm, true, "alice", "admin", "org/*")
+	assert.Equal(t, []string{
+		"link_added alice admin []",
+		"domain_created   [org/*]",
+		"link_added alice admin [org/*]",
+		"domain_created   [org/1]",
+		"link_added bob admin [org/1]",
+		"link_removed bob admin [org/1]",
+		"domain_deleted   [org/1]",
+		"link_removed alice admin [org/*]",
+		"domain_deleted   [org/*]",
+	}, *events)
+
+	// events of nested domains
+	dm = NewDomainManager(10)
+	events = recordEvents(dm)
+	testAddLink(t, dm, true, "alice", "admin", "org1", "team1")
+	testDeleteLink(t, dm, true, "alice", "admin", "org1", "team1")
+	assert.Equal(t, []string{
+		"domain_created   [org1]",
+		"domain_created   [org1 team1]",
+		"link_added alice admin [org1 team1]",
+		"link_removed alice admin [org1 team1]",
+		"domain_deleted   [org1 team1]",
+		"domain_deleted   [org1]",
+	}, *events)
+}
+
+func TestRoleGraph(t *testing.T) {
+	rm := NewRoleManager(10)
+	rm.SetMatcher(util.PathMatcher)
+	testAddLink(t, rm, true, "alice", "book/*")
+	testAddLink(t, rm, true, "book/1", "reader")
+	testAddLink(t, rm, true, "book/2", "writer")
+	testAddLink(t, rm, true, "bob", "book/2")
+	testAddLink(t, rm, true, "carol", `"admin"`)
+
+	g := NewRoleGraph(rm)
+	assert.Equal(t, []RoleEdge{
+		{From: "alice", To: "book/*", Domain: []string{}},
+		{From: "bob", To: "book/2", Domain: []string{}},
+		{From: "book/1", To: "book/*", Domain: []string{}, Pattern: true},
+		{From: "book/1", To: "reader", Domain: []string{}},
+		{From: "book/2", To: "book/*", Domain: []string{}, Pattern: true},
+		{From: "book/2", To: "writer", Domain: []string{}},
+		{From: "carol", To: `"admin"`, Domain: []string{}},
+	}, g.Edges)
+
+	// bob holds book/2, book/2 matches book/*, but bob does not hold book/1
+	assert.Equal(t, []RoleEdge{
+		{From: "bob", To: "book/2", Domain: []string{}},
+		{From: "book/2", To: "book/*", Domain: []string{}, Pattern: true},
+		{From: "book/2", To: "writer", Domain: []string{}},
+	}, g.Subgraph("bob").Edges)
+	assert.Len(t, g.Subgraph("alice").Edges, 5)
+
+	var sb strings.Builder
+	assert.NoError(t, g.Subgraph("carol").Write(&sb, GraphDOT))
+	assert.Equal(t, "digraph roles {\n\tn0 [label=\"\\\"admin\\\"\"];\n\tn1 [label=\"carol\"];\n\tn1 -> n0;\n}\n", sb.String())
+
+	sb.Reset()
+	assert.NoError(t, g.Subgraph("bob").Write(&sb, GraphMermaid))
+	assert.Equal(t, "graph LR\n\tn0[\"bob\"]\n\tn1[\"book/*\"]\n\tn2[\"book/2\"]\n\tn3[\"writer\"]\n\tn0 --> n2\n\tn2 -.-> n1\n\tn2 --> n3\n", sb.String())
+
+	assert.Error(t, g.Write(&sb, "svg"))
+
+	dm := NewDomainManager(10)
+	testAddLink(t, dm, true, "alice", "admin", "domain1")
+	testAddLink(t, dm, true, "bob", "admin", "domain2", "team1")
+	testAddLink(t, dm, true, "admin", "reader")
+
+	g = NewRoleGraph(NewConditionalRoleManager(dm, 2, 10, TimeWindow))
+	sb.Reset()
+	assert.NoError(t, g.Write(&sb, GraphJSON))
+	assert.JSONEq(t, `{"domains": [
+		{"domain": [], "roles": {"admin": ["reader"]}},
+		{"domain": ["domain1"], "roles": {"alice": ["admin"]}},
+		{"domain": ["domain2", "team1"], "roles": {"bob": ["admin"]}}
+	]}`, sb.String())
+	assert.Equal(t, []RoleEdge{{From: "alice", To: "admin", Domain: []string{"domain1"}}}, g.Subgraph("alice", "domain1").Edges)
+
+	sb.Reset()
+	assert.NoError(t, g.Write(&sb, GraphDOT))
+	assert.Contains(t, sb.String(), "\tsubgraph cluster_2 {\n\t\tlabel=\"domain2/team1\";\n\t\tn4 [label=\"admin\"];\n\t\tn5 [label=\"bob\"];\n\t}\n")
+}
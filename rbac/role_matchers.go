@@ -0,0 +1,40 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rbac
+
+import (
+	"github.com/abichinger/fastac/util"
+)
+
+// role matchers, which can be referenced by name in the model (g.role_matcher = pathMatch)
+var roleMatchers = map[string]util.IMatcher{
+	"pathMatch":  util.PathMatcher,
+	"pathMatch2": util.PathMatcher2,
+	"regexMatch": util.RegexMatcher,
+	"globMatch":  util.GlobMatcher,
+}
+
+// SetRoleMatcher registers a custom role matcher, it has to be registered before the model is loaded
+//
+//  rbac.SetRoleMatcher("prefixMatch", util.NewMatcher(isPrefixPattern, prefixMatch))
+func SetRoleMatcher(name string, matcher util.IMatcher) {
+	roleMatchers[name] = matcher
+}
+
+// GetRoleMatcher returns the role matcher registered under name
+func GetRoleMatcher(name string) (util.IMatcher, bool) {
+	matcher, ok := roleMatchers[name]
+	return matcher, ok
+}
@@ -1,21 +1,86 @@
 package rbac
 
 import (
+	"sort"
+	"time"
+
 	"github.com/abichinger/fastac/model/policy"
+	"github.com/abichinger/fastac/util"
 	em "github.com/vansante/go-event-emitter"
 )
 
 type RolePolicy struct {
-	rm IRoleManager
+	rm          IRoleManager
+	constraints map[string]Constraint
 	*em.Emitter
 }
 
 func NewRolePolicy(rm IRoleManager) *RolePolicy {
 	emitter := em.NewEmitter(false)
-	return &RolePolicy{rm, emitter}
+	return &RolePolicy{rm, map[string]Constraint{}, emitter}
+}
+
+// SetConstraint adds or replaces a constraint, which is checked before a rule is added
+func (p *RolePolicy) SetConstraint(key string, c Constraint) {
+	p.constraints[key] = c
+}
+
+func (p *RolePolicy) RemoveConstraint(key string) {
+	delete(p.constraints, key)
+}
+
+func (p *RolePolicy) rangeConstraints(fn func(c Constraint) error) error {
+	keys := make([]string, 0, len(p.constraints))
+	for key := range p.constraints {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn(p.constraints[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkConstraints returns the first violated constraint of rule
+func (p *RolePolicy) checkConstraints(rule []string) error {
+	return p.rangeConstraints(func(c Constraint) error {
+		return c.CheckLink(p.rm, rule[0], rule[1], rule[2:]...)
+	})
+}
+
+// sameLink returns true, if both rules describe the same link. Parameters of conditional links are ignored.
+func (p *RolePolicy) sameLink(rule1, rule2 []string) bool {
+	_, domains1 := unwrapConditional(p.rm, rule1[2:])
+	_, domains2 := unwrapConditional(p.rm, rule2[2:])
+	return linkKey(rule1[0], rule1[1], domains1) == linkKey(rule2[0], rule2[1], domains2)
+}
+
+// checkUpdate returns the first constraint, which is violated by replacing oldRule with newRule
+func (p *RolePolicy) checkUpdate(oldRule, newRule []string) error {
+	rm, oldDomains := unwrapConditional(p.rm, oldRule[2:])
+	_, newDomains := unwrapConditional(p.rm, newRule[2:])
+	view := &excludingRoleManager{IRoleManager: rm, excluded: link{name1: oldRule[0], name2: oldRule[1], domains: oldDomains}}
+	return p.rangeConstraints(func(c Constraint) error {
+		return c.CheckLink(view, newRule[0], newRule[1], newDomains...)
+	})
+}
+
+// ValidateConstraints returns all violations of the constraints
+func (p *RolePolicy) ValidateConstraints() []error {
+	errs := []error{}
+	_ = p.rangeConstraints(func(c Constraint) error {
+		errs = append(errs, c.Validate(p.rm)...)
+		return nil
+	})
+	return errs
 }
 
 func (p *RolePolicy) AddRule(rule []string) (bool, error) {
+	if err := p.checkConstraints(rule); err != nil {
+		return false, err
+	}
 	added, err := p.rm.AddLink(rule[0], rule[1], rule[2:]...)
 	if !added || err != nil {
 		return added, err
@@ -33,6 +98,38 @@ func (p *RolePolicy) RemoveRule(rule []string) (bool, error) {
 	return true, nil
 }
 
+// UpdateRule replaces the link of oldRule with the link of newRule
+// Returns false, if oldRule was not present, newRule is already present or both rules are equal.
+// The constraints are checked before any link is changed, as if oldRule was already removed.
+func (p *RolePolicy) UpdateRule(oldRule, newRule []string) (bool, error) {
+	if util.Hash(oldRule) == util.Hash(newRule) || !p.HasRule(oldRule) {
+		return false, nil
+	}
+	//the parameters of a conditional link are changed by updating the link itself
+	if !p.sameLink(oldRule, newRule) && p.HasRule(newRule) {
+		return false, nil
+	}
+	if err := p.checkUpdate(oldRule, newRule); err != nil {
+		return false, err
+	}
+	removed, err := p.rm.DeleteLink(oldRule[0], oldRule[1], oldRule[2:]...)
+	if !removed || err != nil {
+		return removed, err
+	}
+	added, err := p.rm.AddLink(newRule[0], newRule[1], newRule[2:]...)
+	if !added || err != nil {
+		_, _ = p.rm.AddLink(oldRule[0], oldRule[1], oldRule[2:]...)
+		return false, err
+	}
+	p.Emitter.EmitEvent(policy.EVT_RULE_UPDATED, oldRule, newRule)
+	return true, nil
+}
+
+// HasRule returns true, if the link of rule is stored. Inherited links and links produced by patterns are not considered.
+func (p *RolePolicy) HasRule(rule []string) bool {
+	return len(rule) >= 2 && hasStoredLink(p.rm, rule[0], rule[1], rule[2:]...)
+}
+
 func (p *RolePolicy) Range(fn func(rule []string) bool) {
 	p.rm.Range(func(name1, name2 string, domain ...string) bool {
 		rule := []string{name1, name2}
@@ -48,6 +145,33 @@ func (p *RolePolicy) Clear() error {
 	return p.rm.Clear()
 }
 
+// SetRoleProvider adds the links of provider to the role manager of the policy, a previous provider is replaced.
+// The local links, the options and the listeners of the role manager are kept.
+// Returns false, if the local links are not managed by an IDefaultRoleManager.
+func (p *RolePolicy) SetRoleProvider(provider RoleProvider, ttl time.Duration) bool {
+	rm := p.rm
+	crm, conditional := rm.(*ConditionalRoleManager)
+	if conditional {
+		rm = crm.IRoleManager
+	}
+	if prm, ok := rm.(*ProviderRoleManager); ok {
+		rm = prm.IDefaultRoleManager
+	}
+	local, ok := rm.(IDefaultRoleManager)
+	resolver, isResolver := rm.(linkResolver)
+	if !ok || !isResolver {
+		return false
+	}
+
+	prm := NewProviderRoleManager(provider, local, resolver.hierarchyLevel(), ttl)
+	if conditional {
+		crm.IRoleManager = prm
+	} else {
+		p.rm = prm
+	}
+	return true
+}
+
 func (p *RolePolicy) GetRoleManager() IRoleManager {
 	return p.rm
 }
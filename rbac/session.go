@@ -0,0 +1,154 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rbac
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/abichinger/fastac/str"
+	"github.com/abichinger/fastac/util"
+	"github.com/abichinger/govaluate"
+)
+
+// Session contains the activated roles of a request by role definition key
+type Session map[string][]string
+
+// SessionConstraint restricts the roles, which can be activated in one session
+type SessionConstraint interface {
+	// CheckSession returns an error, if the activated roles violate the constraint
+	CheckSession(rm IRoleManager, roles []string, domains ...string) error
+}
+
+// SessionError is returned, if the activated roles of a session violate a DynamicSeparationOfDuty constraint
+type SessionError struct {
+	Constraint string
+	Domain     []string
+	// Roles contains the mutually exclusive roles of the session
+	Roles []string
+}
+
+func (e *SessionError) Error() string {
+	return inDomain(fmt.Sprintf(str.ERR_DSD_VIOLATION, e.Constraint, strings.Join(e.Roles, ", ")), e.Domain)
+}
+
+// DynamicSeparationOfDuty is a dynamic separation of duty constraint, a session may hold at most one of the roles.
+// Roles inherited by the activated roles are considered. Unlike SeparationOfDuty, a user may be assigned to several of the roles.
+//
+//  [constraint_definition]
+//  c = dsd(g, approver, requester)
+type DynamicSeparationOfDuty struct {
+	Name  string
+	Roles []string
+}
+
+func NewDynamicSeparationOfDuty(name string, roles ...string) *DynamicSeparationOfDuty {
+	return &DynamicSeparationOfDuty{Name: name, Roles: roles}
+}
+
+// CheckLink accepts every link, the constraint is checked at enforce time
+func (c *DynamicSeparationOfDuty) CheckLink(rm IRoleManager, name1 string, name2 string, domains ...string) error {
+	return nil
+}
+
+// Validate returns no errors, the constraint is checked at enforce time
+func (c *DynamicSeparationOfDuty) Validate(rm IRoleManager) []error {
+	return []error{}
+}
+
+func (c *DynamicSeparationOfDuty) CheckSession(rm IRoleManager, roles []string, domains ...string) error {
+	rm, domains = unwrapConditional(rm, domains)
+	held := []string{}
+	for _, role := range c.Roles {
+		for _, active := range roles {
+			if ok, _ := rm.HasLink(active, role, domains...); ok {
+				held = append(held, role)
+				break
+			}
+		}
+	}
+	if len(held) > 1 {
+		sort.Strings(held)
+		return &SessionError{Constraint: c.Name, Domain: domains, Roles: held}
+	}
+	return nil
+}
+
+// CheckSession checks the activated roles against all session constraints
+func (p *RolePolicy) CheckSession(roles []string, domains ...string) error {
+	return p.rangeConstraints(func(c Constraint) error {
+		if sc, ok := c.(SessionConstraint); ok {
+			return sc.CheckSession(p.rm, roles, domains...)
+		}
+		return nil
+	})
+}
+
+// GenerateSessionGFunction is the factory method of the g(_, _) function of a session.
+// name1 inherits name2 only through the activated roles, name1 inherits itself.
+// The session constraints of rp are checked once per domain.
+func GenerateSessionGFunction(rp *RolePolicy, roles []string) govaluate.ExpressionFunction {
+	g := GenerateGFunction(rp.GetRoleManager())
+	checked := map[string]error{}
+
+	return func(args ...interface{}) (interface{}, error) {
+		name1 := args[0].(string)
+		name2 := args[1].(string)
+		rest := args[2:]
+
+		domains := sessionDomains(rp.GetRoleManager(), rest)
+		key := util.Hash(domains)
+		err, ok := checked[key]
+		if !ok {
+			err = rp.CheckSession(roles, domains...)
+			checked[key] = err
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if name1 == name2 {
+			return true, nil
+		}
+		for _, role := range roles {
+			res, err := g(append([]interface{}{name1, role}, rest...)...)
+			if err != nil {
+				return false, err
+			}
+			if res != true {
+				continue
+			}
+			res, err = g(append([]interface{}{role, name2}, rest...)...)
+			if err != nil || res == true {
+				return res, err
+			}
+		}
+		return false, nil
+	}
+}
+
+// sessionDomains returns the domain arguments of a g function call
+func sessionDomains(rm IRoleManager, args []interface{}) []string {
+	n := len(args)
+	if crm, ok := rm.(IConditionalRoleManager); ok && crm.DomainCount() < n {
+		n = crm.DomainCount()
+	}
+	domains := make([]string, 0, n)
+	for _, arg := range args[:n] {
+		domains = append(domains, arg.(string))
+	}
+	return domains
+}
@@ -0,0 +1,150 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rbac
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/abichinger/fastac/str"
+)
+
+func inDomain(msg string, domain []string) string {
+	if len(domain) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(str.ERR_IN_DOMAIN, msg, strings.Join(domain, "/"))
+}
+
+// CycleError is returned by AddLink, if cycle detection is enabled and the new link closes a cycle.
+// Validate reports a CycleError for every cycle of the role hierarchy.
+type CycleError struct {
+	Domain []string
+	// Cycle contains the roles of the cycle, the first role is repeated at the end (a -> b -> a)
+	Cycle []string
+}
+
+func (e *CycleError) Error() string {
+	return inDomain(fmt.Sprintf(str.ERR_ROLE_CYCLE, strings.Join(e.Cycle, " -> ")), e.Domain)
+}
+
+// DepthError is reported by Validate, if a user inherits a role through more links than HasLink follows
+type DepthError struct {
+	Domain []string
+	User   string
+	Role   string
+	// Depth is the number of links between User and Role
+	Depth int
+	// MaxDepth is the number of links followed by HasLink
+	MaxDepth int
+}
+
+func (e *DepthError) Error() string {
+	return inDomain(fmt.Sprintf(str.ERR_ROLE_DEPTH, e.User, e.Role, e.Depth, e.MaxDepth), e.Domain)
+}
+
+// DanglingRoleError is reported, if a role is inherited, but neither used by a policy nor inherits other roles
+type DanglingRoleError struct {
+	Role string
+}
+
+func (e *DanglingRoleError) Error() string {
+	return fmt.Sprintf(str.ERR_DANGLING_ROLE, e.Role)
+}
+
+// withDomain prefixes the domain of a validation error
+func withDomain(err error, domain string) error {
+	switch e := err.(type) {
+	case *CycleError:
+		e.Domain = append([]string{domain}, e.Domain...)
+	case *DepthError:
+		e.Domain = append([]string{domain}, e.Domain...)
+	}
+	return err
+}
+
+// validateLinks reports cycles and links, which exceed maxDepth
+func validateLinks(links map[string][]string, maxDepth int) []error {
+	names := make([]string, 0, len(links))
+	for name, roles := range links {
+		names = append(names, name)
+		sort.Strings(roles)
+	}
+	sort.Strings(names)
+
+	errs := findCycles(names, links)
+
+	for _, user := range names {
+		depth := map[string]int{user: 0}
+		current := []string{user}
+		for d := 1; len(current) > 0; d++ {
+			next := []string{}
+			for _, name := range current {
+				for _, role := range links[name] {
+					if _, ok := depth[role]; ok {
+						continue
+					}
+					depth[role] = d
+					next = append(next, role)
+					if d > maxDepth {
+						errs = append(errs, &DepthError{User: user, Role: role, Depth: d, MaxDepth: maxDepth})
+					}
+				}
+			}
+			current = next
+		}
+	}
+	return errs
+}
+
+// findCycles reports a cycle for every back edge of a depth-first search
+func findCycles(names []string, links map[string][]string) []error {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	stack := []string{}
+	errs := []error{}
+
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, role := range links[name] {
+			switch state[role] {
+			case visiting:
+				i := len(stack) - 1
+				for stack[i] != role {
+					i--
+				}
+				cycle := append(append([]string{}, stack[i:]...), role)
+				errs = append(errs, &CycleError{Cycle: cycle})
+			case 0:
+				visit(role)
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+	}
+
+	for _, name := range names {
+		if state[name] == 0 {
+			visit(name)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,554 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package server exposes an Enforcer as JSON-over-HTTP authorization service.
+//
+// Requests are JSON objects, which map the arguments of the request definition to values.
+// The request definition and matcher are selected by the query parameters request and matcher (default: r, m).
+//
+//  POST /enforce          {"sub": "alice", "obj": "data1", "act": "read"} -> {"allow": true}
+//  POST /explain          {"sub": "alice", "obj": "data1", "act": "read"} -> {"allow": true, "rule": ["p", "alice", "data1", "read"]}
+//  POST /batch-enforce    [{"sub": "alice", ...}, {"sub": "bob", ...}]    -> {"allow": [true, false]}
+//  POST /filter           {"matcher": "p.sub == \"alice\""}               -> {"rules": [["p", "alice", "data1", "read"]]}
+//  GET /rules?key=p                                                       -> {"rules": [["p", "alice", "data1", "read"]]}
+//  POST /rules            {"rules": [["p", "bob", "data1", "read"]]}
+//  DELETE /rules          {"rules": [["p", "bob", "data1", "read"]]}
+//  PUT /rules             {"old": [["p", "bob", "data1", "read"]], "new": [["p", "bob", "data1", "write"]]}
+//  GET /schema                                                            -> {"r": ["sub", "obj", "act"]}
+//  POST /reload
+//  GET /health
+//  GET /ready
+//
+// Errors are returned as {"error": "..."}.
+// POST, PUT and DELETE /rules and POST /reload change the enforcer, they can be disabled with OptionReadOnly
+// or guarded with OptionAuthorize.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/abichinger/fastac"
+	"github.com/abichinger/fastac/model"
+	"github.com/abichinger/fastac/model/defs"
+	"github.com/abichinger/fastac/storage"
+	"github.com/abichinger/fastac/str"
+	"github.com/abichinger/fastac/util"
+)
+
+// Server is a http.Handler, which answers authorization requests with an Enforcer
+//
+//  s := server.NewServer(e, server.OptionModelFile("model.conf"))
+//  http.ListenAndServe(":8080", s)
+type Server struct {
+	mu        sync.RWMutex
+	e         *fastac.Enforcer
+	modelPath string
+	ready     bool
+
+	readOnly     bool
+	authorize    func(r *http.Request) error
+	maxBodySize  int64
+	maxBatchSize int
+
+	mux *http.ServeMux
+}
+
+const (
+	// DefaultMaxBodySize is the default size limit of request bodies in bytes
+	DefaultMaxBodySize = 1 << 20
+	// DefaultMaxBatchSize is the default limit of requests per /batch-enforce call
+	DefaultMaxBatchSize = 1000
+)
+
+type Option func(*Server)
+
+// OptionModelFile sets the model file, which is loaded by Reload
+func OptionModelFile(path string) Option {
+	return func(s *Server) {
+		s.modelPath = path
+	}
+}
+
+// OptionReadOnly disables the endpoints, which change the enforcer (POST, PUT and DELETE /rules, POST /reload).
+// Requests to them are answered with 403 Forbidden.
+func OptionReadOnly(readOnly bool) Option {
+	return func(s *Server) {
+		s.readOnly = readOnly
+	}
+}
+
+// OptionAuthorize sets a function, which is called before every request to an endpoint, which changes the enforcer.
+// If fn returns an error, the request is answered with 401 Unauthorized.
+//
+//  server.OptionAuthorize(func(r *http.Request) error {
+//  	if r.Header.Get("Authorization") != "Bearer "+token {
+//  		return errors.New("invalid token")
+//  	}
+//  	return nil
+//  })
+func OptionAuthorize(fn func(r *http.Request) error) Option {
+	return func(s *Server) {
+		s.authorize = fn
+	}
+}
+
+// OptionMaxBodySize sets the size limit of request bodies in bytes (default: DefaultMaxBodySize)
+func OptionMaxBodySize(n int64) Option {
+	return func(s *Server) {
+		s.maxBodySize = n
+	}
+}
+
+// OptionMaxBatchSize sets the maximum number of requests per /batch-enforce call (default: DefaultMaxBatchSize)
+func OptionMaxBatchSize(n int) Option {
+	return func(s *Server) {
+		s.maxBatchSize = n
+	}
+}
+
+func NewServer(e *fastac.Enforcer, options ...Option) *Server {
+	s := &Server{
+		e:            e,
+		ready:        true,
+		maxBodySize:  DefaultMaxBodySize,
+		maxBatchSize: DefaultMaxBatchSize,
+		mux:          http.NewServeMux(),
+	}
+	for _, option := range options {
+		option(s)
+	}
+
+	s.mux.HandleFunc("/enforce", s.handle(http.MethodPost, enforce))
+	s.mux.HandleFunc("/explain", s.handle(http.MethodPost, explain))
+	s.mux.HandleFunc("/batch-enforce", s.handle(http.MethodPost, s.batchEnforce))
+	s.mux.HandleFunc("/filter", s.handle(http.MethodPost, filter))
+	s.mux.HandleFunc("/rules", s.rules)
+	s.mux.HandleFunc("/schema", s.handle(http.MethodGet, schema))
+	s.mux.HandleFunc("/reload", s.reload)
+	s.mux.HandleFunc("/health", s.handle(http.MethodGet, health))
+	s.mux.HandleFunc("/ready", s.handle(http.MethodGet, s.readiness))
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodySize)
+	s.mux.ServeHTTP(w, r)
+}
+
+// Enforcer returns the current enforcer, which is replaced by Reload
+func (s *Server) Enforcer() *fastac.Enforcer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.e
+}
+
+// SetReady sets the state reported by /ready, e.g. to drain the server before shutdown
+func (s *Server) SetReady(ready bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ready = ready
+}
+
+// Reload loads the model file and replaces the enforcer, requests are answered by the old enforcer until the new one is ready.
+// The rules of the old model are copied, pending changes are flushed before. If the model is invalid, the old enforcer is kept.
+// The expiry times of rules are copied as well, custom functions are not copied.
+func (s *Server) Reload() error {
+	if s.modelPath == "" {
+		return errors.New(str.ERR_NO_MODEL_FILE)
+	}
+	m, err := model.NewModelFromFile(s.modelPath)
+	if err != nil {
+		return err
+	}
+	if errs := m.Validate(); len(errs) > 0 {
+		return errs[0]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.e
+	if err := old.Flush(); err != nil {
+		return err
+	}
+
+	rules := [][]string{}
+	old.GetModel().RangeRules(func(rule []string) bool {
+		rules = append(rules, rule)
+		return true
+	})
+	e, err := fastac.NewEnforcer(m, old.GetAdapter(), fastac.OptionStorage(false))
+	if err != nil {
+		return err
+	}
+	if err := e.AddRules(rules); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if at, ok := old.GetModel().GetExpiry(rule); ok {
+			if err := e.GetModel().SetExpiry(rule, at); err != nil {
+				return err
+			}
+		}
+	}
+
+	sc := old.GetStorageController()
+	if err := e.SetOption(fastac.OptionAutosave(sc.AutosaveEnabled())); err != nil {
+		return err
+	}
+	if err := e.SetOption(fastac.OptionStorage(sc.Enabled())); err != nil {
+		return err
+	}
+	sc.Disable()
+	s.e = e
+	return nil
+}
+
+type handlerFunc func(e *fastac.Enforcer, r *http.Request) (interface{}, error)
+
+// statusError is an error with a http status code
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(err error) error {
+	return &statusError{http.StatusBadRequest, err}
+}
+
+// storageError answers errors of the adapter with 500 Internal Server Error and all other errors with 400 Bad Request
+func storageError(err error) error {
+	var fErr *storage.FlushError
+	if errors.As(err, &fErr) {
+		return err
+	}
+	return badRequest(err)
+}
+
+// handle checks the method and calls fn with the current enforcer
+func (s *Server) handle(method string, fn handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowMethod(w, r, method) {
+			s.serve(w, r, fn)
+		}
+	}
+}
+
+// serve calls fn with the current enforcer, Reload and changes wait until fn returns
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, fn handlerFunc) {
+	s.mu.RLock()
+	res, err := fn(s.e, r)
+	s.mu.RUnlock()
+	respond(w, res, err)
+}
+
+// change calls fn with the current enforcer, while no other request is served
+func (s *Server) change(w http.ResponseWriter, r *http.Request, fn handlerFunc) {
+	s.mu.Lock()
+	res, err := fn(s.e, r)
+	s.mu.Unlock()
+	respond(w, res, err)
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	writeError(w, &statusError{http.StatusMethodNotAllowed, fmt.Errorf(str.ERR_METHOD_NOT_ALLOWED, r.Method, r.URL.Path)})
+	return false
+}
+
+// allowChange checks, if the request is allowed to change the enforcer
+func (s *Server) allowChange(w http.ResponseWriter, r *http.Request) bool {
+	if s.readOnly {
+		writeError(w, &statusError{http.StatusForbidden, fmt.Errorf(str.ERR_READ_ONLY, r.Method, r.URL.Path)})
+		return false
+	}
+	if s.authorize != nil {
+		if err := s.authorize(r); err != nil {
+			writeError(w, &statusError{http.StatusUnauthorized, err})
+			return false
+		}
+	}
+	return true
+}
+
+// respond writes the result as JSON, a nil result is answered with 204 No Content
+func respond(w http.ResponseWriter, res interface{}, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if res == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var sErr *statusError
+	if errors.As(err, &sErr) {
+		status = sErr.status
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func decodeBody(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		//http.MaxBytesError is not available before go1.19
+		if strings.Contains(err.Error(), "request body too large") {
+			return &statusError{http.StatusRequestEntityTooLarge, err}
+		}
+		return badRequest(err)
+	}
+	return nil
+}
+
+// contextOptions returns the request definition and matcher selected by the query parameters
+func contextOptions(r *http.Request) []interface{} {
+	query := r.URL.Query()
+	return []interface{}{fastac.SetRequestDef(query.Get("request")), fastac.SetMatcher(query.Get("matcher"))}
+}
+
+// requestValues converts a request object into the values of the request definition
+func requestValues(e *fastac.Enforcer, r *http.Request, request map[string]interface{}) ([]interface{}, error) {
+	key := r.URL.Query().Get("request")
+	if key == "" {
+		key = "r"
+	}
+	rDef, ok := e.GetModel().GetRequestDef(key)
+	if !ok {
+		return nil, badRequest(fmt.Errorf(str.ERR_REQUESTDEF_NOT_FOUND, key))
+	}
+
+	values := make([]interface{}, 0, len(rDef.GetArgs()))
+	for _, arg := range rDef.GetArgs() {
+		value, ok := request[arg]
+		if !ok {
+			return nil, badRequest(fmt.Errorf(str.ERR_MISSING_REQUEST_ARG, arg))
+		}
+		values = append(values, value)
+	}
+	if len(request) > len(values) {
+		names := make([]string, 0, len(request))
+		for name := range request {
+			if !rDef.Has(key + "_" + name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return nil, badRequest(fmt.Errorf(str.ERR_UNKNOWN_REQUEST_ARG, names[0]))
+	}
+	return values, nil
+}
+
+func decide(e *fastac.Enforcer, r *http.Request, request map[string]interface{}) (bool, []string, error) {
+	values, err := requestValues(e, r, request)
+	if err != nil {
+		return false, nil, err
+	}
+	allow, rule, err := e.EnforceEx(append(values, contextOptions(r)...)...)
+	if err != nil {
+		return false, nil, storageError(err)
+	}
+	return allow, rule, nil
+}
+
+func enforce(e *fastac.Enforcer, r *http.Request) (interface{}, error) {
+	request := map[string]interface{}{}
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	allow, _, err := decide(e, r, request)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]bool{"allow": allow}, nil
+}
+
+type explanation struct {
+	Allow bool     `json:"allow"`
+	Rule  []string `json:"rule"`
+}
+
+func explain(e *fastac.Enforcer, r *http.Request) (interface{}, error) {
+	request := map[string]interface{}{}
+	if err := decodeBody(r, &request); err != nil {
+		return nil, err
+	}
+	allow, rule, err := decide(e, r, request)
+	if err != nil {
+		return nil, err
+	}
+	return &explanation{Allow: allow, Rule: rule}, nil
+}
+
+func (s *Server) batchEnforce(e *fastac.Enforcer, r *http.Request) (interface{}, error) {
+	requests := []map[string]interface{}{}
+	if err := decodeBody(r, &requests); err != nil {
+		return nil, err
+	}
+	if len(requests) > s.maxBatchSize {
+		return nil, &statusError{http.StatusRequestEntityTooLarge, fmt.Errorf(str.ERR_BATCH_TOO_LARGE, len(requests), s.maxBatchSize)}
+	}
+	res := make([]bool, len(requests))
+	for i, request := range requests {
+		allow, _, err := decide(e, r, request)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = allow
+	}
+	return map[string][]bool{"allow": res}, nil
+}
+
+type filterRequest struct {
+	Matcher string                 `json:"matcher"`
+	Request map[string]interface{} `json:"request"`
+}
+
+func filter(e *fastac.Enforcer, r *http.Request) (interface{}, error) {
+	body := &filterRequest{}
+	if err := decodeBody(r, body); err != nil {
+		return nil, err
+	}
+	params := contextOptions(r)
+	if body.Matcher != "" {
+		params = append(params, fastac.SetMatcher(body.Matcher))
+	}
+	if body.Request != nil {
+		values, err := requestValues(e, r, body.Request)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, values...)
+	}
+	rules, err := e.Filter(params...)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return map[string][][]string{"rules": sortRules(rules)}, nil
+}
+
+type rulesRequest struct {
+	Rules [][]string `json:"rules"`
+	Old   [][]string `json:"old"`
+	New   [][]string `json:"new"`
+}
+
+// rules lists (GET), adds (POST), removes (DELETE) and updates (PUT) rules
+func (s *Server) rules(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodPost, http.MethodDelete, http.MethodPut) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		s.serve(w, r, listRules)
+	} else if s.allowChange(w, r) {
+		s.change(w, r, changeRules)
+	}
+}
+
+func listRules(e *fastac.Enforcer, r *http.Request) (interface{}, error) {
+	key := r.URL.Query().Get("key")
+	rules := [][]string{}
+	e.GetModel().RangeRules(func(rule []string) bool {
+		if key == "" || rule[0] == key {
+			rules = append(rules, rule)
+		}
+		return true
+	})
+	return map[string][][]string{"rules": sortRules(rules)}, nil
+}
+
+func changeRules(e *fastac.Enforcer, r *http.Request) (interface{}, error) {
+	body := &rulesRequest{}
+	if err := decodeBody(r, body); err != nil {
+		return nil, err
+	}
+	for _, rule := range append(append(body.Rules, body.Old...), body.New...) {
+		if len(rule) == 0 {
+			return nil, badRequest(errors.New(str.ERR_EMPTY_RULE))
+		}
+	}
+
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		err = e.AddRules(body.Rules)
+	case http.MethodDelete:
+		err = e.RemoveRules(body.Rules)
+	case http.MethodPut:
+		err = e.UpdateRules(body.Old, body.New)
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return nil, nil
+}
+
+func schema(e *fastac.Enforcer, r *http.Request) (interface{}, error) {
+	res := map[string][]string{}
+	e.GetModel().RangeDefs(model.R_SEC, func(def defs.IDef) bool {
+		if rDef, ok := def.(*defs.RequestDef); ok {
+			res[rDef.GetKey()] = rDef.GetArgs()
+		}
+		return true
+	})
+	return res, nil
+}
+
+func (s *Server) reload(w http.ResponseWriter, r *http.Request) {
+	if allowMethod(w, r, http.MethodPost) && s.allowChange(w, r) {
+		respond(w, nil, s.Reload())
+	}
+}
+
+func health(e *fastac.Enforcer, r *http.Request) (interface{}, error) {
+	return map[string]string{"status": "ok"}, nil
+}
+
+func (s *Server) readiness(e *fastac.Enforcer, r *http.Request) (interface{}, error) {
+	if !s.ready {
+		return nil, &statusError{http.StatusServiceUnavailable, errors.New(str.ERR_NOT_READY)}
+	}
+	return map[string]string{"status": "ready"}, nil
+}
+
+func sortRules(rules [][]string) [][]string {
+	sort.Slice(rules, func(i, j int) bool {
+		return util.Hash(rules[i]) < util.Hash(rules[j])
+	})
+	return rules
+}
@@ -0,0 +1,249 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/abichinger/fastac"
+	"github.com/abichinger/fastac/storage/adapter"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T, options ...Option) *Server {
+	//rule changes must not be written to the example policy
+	e, err := fastac.NewEnforcer("../examples/rbac_with_deny_model.conf", "../examples/rbac_with_deny_policy.csv", fastac.OptionStorage(false))
+	assert.NoError(t, err)
+	return NewServer(e, options...)
+}
+
+func do(s *Server, method, path, body string) (int, string) {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w.Code, strings.TrimSpace(w.Body.String())
+}
+
+func TestEnforce(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		method   string
+		path     string
+		body     string
+		status   int
+		expected string
+	}{
+		{"POST", "/enforce", `{"sub": "alice", "obj": "data1", "act": "read"}`, 200, `{"allow":true}`},
+		{"POST", "/enforce", `{"sub": "alice", "obj": "data2", "act": "write"}`, 200, `{"allow":false}`},
+		{"POST", "/enforce", `{"sub": "alice", "obj": "data1"}`, 400, `{"error":"error: request argument act is missing"}`},
+		{"POST", "/enforce", `{"sub": "alice", "obj": "data1", "act": "read", "time": 1}`, 400, `{"error":"error: unknown request argument time"}`},
+		{"POST", "/enforce?request=r2", `{"sub": "alice"}`, 400, `{"error":"error: request definition r2 not found"}`},
+		{"POST", "/enforce?matcher=g.user+%3D%3D+%22alice%22", `{"sub": "alice", "obj": "data1", "act": "read"}`, 400, `{"error":"error: policy g not found"}`},
+		{"POST", "/explain?matcher=g.user+%3D%3D+%22alice%22", `{"sub": "alice", "obj": "data1", "act": "read"}`, 400, `{"error":"error: policy g not found"}`},
+		{"POST", "/batch-enforce?matcher=g.user+%3D%3D+%22alice%22", `[{"sub": "alice", "obj": "data1", "act": "read"}]`, 400, `{"error":"error: policy g not found"}`},
+		{"GET", "/enforce", ``, 405, `{"error":"error: method GET is not allowed for /enforce"}`},
+		{"POST", "/explain", `{"sub": "alice", "obj": "data2", "act": "read"}`, 200, `{"allow":true,"rule":["p","data2_admin","data2","read","allow"]}`},
+		{"POST", "/explain", `{"sub": "bob", "obj": "data1", "act": "read"}`, 200, `{"allow":false,"rule":[]}`},
+		{"POST", "/batch-enforce", `[{"sub": "alice", "obj": "data1", "act": "read"}, {"sub": "bob", "obj": "data1", "act": "read"}]`, 200, `{"allow":[true,false]}`},
+		{"POST", "/filter", `{"matcher": "p.sub == \"bob\""}`, 200, `{"rules":[["p","bob","data2","write","allow"]]}`},
+		{"POST", "/filter", `{"request": {"sub": "alice", "obj": "data2", "act": "write"}}`, 200, `{"rules":[["p","alice","data2","write","deny"],["p","data2_admin","data2","write","allow"]]}`},
+		{"GET", "/schema", ``, 200, `{"r":["sub","obj","act"]}`},
+		{"GET", "/health", ``, 200, `{"status":"ok"}`},
+		{"GET", "/ready", ``, 200, `{"status":"ready"}`},
+	}
+	for _, test := range tests {
+		status, body := do(s, test.method, test.path, test.body)
+		assert.Equal(t, test.status, status, test.path+" "+test.body)
+		assert.Equal(t, test.expected, body, test.path+" "+test.body)
+	}
+
+	s.SetReady(false)
+	status, _ := do(s, "GET", "/ready", "")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+}
+
+func TestRules(t *testing.T) {
+	s := newTestServer(t)
+
+	status, _ := do(s, "POST", "/rules", `{"rules": [["p", "carol", "data1", "read"], ["g", "carol", "data2_admin"]]}`)
+	assert.Equal(t, http.StatusNoContent, status)
+	_, body := do(s, "POST", "/enforce", `{"sub": "carol", "obj": "data2", "act": "write"}`)
+	assert.Equal(t, `{"allow":true}`, body)
+
+	status, _ = do(s, "PUT", "/rules", `{"old": [["p", "carol", "data1", "read"]], "new": [["p", "carol", "data1", "write"]]}`)
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = do(s, "DELETE", "/rules", `{"rules": [["g", "carol", "data2_admin"]]}`)
+	assert.Equal(t, http.StatusNoContent, status)
+
+	status, body = do(s, "GET", "/rules?key=g", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"rules":[["g","alice","data2_admin"]]}`, body)
+	_, body = do(s, "GET", "/rules", "")
+	assert.Contains(t, body, `["p","carol","data1","write"]`)
+	assert.NotContains(t, body, `["p","carol","data1","read"]`)
+
+	status, body = do(s, "POST", "/rules", `{"rules": [[]]}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, `{"error":"error: empty rule"}`, body)
+	status, _ = do(s, "POST", "/rules", `{"rule": []}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = do(s, "PATCH", "/rules", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, status)
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastac")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "model.conf")
+	data, err := ioutil.ReadFile("../examples/rbac_with_deny_model.conf")
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(path, data, 0644))
+
+	status, body := do(newTestServer(t), "POST", "/reload", "")
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, `{"error":"error: no model file to reload"}`, body)
+
+	s := newTestServer(t, OptionModelFile(path))
+	old := s.Enforcer()
+
+	//roles are no longer considered
+	model := strings.Replace(string(data), "g(r.sub, p.sub)", "r.sub == p.sub", 1)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(model), 0644))
+	status, _ = do(s, "POST", "/reload", "")
+	assert.Equal(t, http.StatusNoContent, status)
+	assert.NotSame(t, old, s.Enforcer())
+	_, body = do(s, "POST", "/enforce", `{"sub": "alice", "obj": "data2", "act": "read"}`)
+	assert.Equal(t, `{"allow":false}`, body)
+	_, body = do(s, "POST", "/enforce", `{"sub": "alice", "obj": "data1", "act": "read"}`)
+	assert.Equal(t, `{"allow":true}`, body)
+
+	//an invalid model keeps the current enforcer
+	current := s.Enforcer()
+	assert.NoError(t, ioutil.WriteFile(path, []byte(strings.Replace(model, "r.act == p.act", "r.act == p.action", 1)), 0644))
+	status, body = do(s, "POST", "/reload", "")
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, `{"error":"error: matcher m references undefined argument p.action"}`, body)
+	assert.Same(t, current, s.Enforcer())
+}
+
+func TestReadOnly(t *testing.T) {
+	s := newTestServer(t, OptionReadOnly(true))
+	for _, method := range []string{"POST", "PUT", "DELETE"} {
+		status, body := do(s, method, "/rules", `{"rules": [["p", "carol", "data1", "read"]]}`)
+		assert.Equal(t, http.StatusForbidden, status)
+		assert.Equal(t, `{"error":"error: method `+method+` is not allowed for /rules, the server is read-only"}`, body)
+	}
+	status, _ := do(s, "POST", "/reload", "")
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = do(s, "GET", "/rules", "")
+	assert.Equal(t, http.StatusOK, status)
+	_, body := do(s, "POST", "/enforce", `{"sub": "alice", "obj": "data1", "act": "read"}`)
+	assert.Equal(t, `{"allow":true}`, body)
+
+	s = newTestServer(t, OptionAuthorize(func(r *http.Request) error {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			return errors.New("invalid token")
+		}
+		return nil
+	}))
+	status, body = do(s, "POST", "/rules", `{"rules": [["p", "carol", "data1", "read"]]}`)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, `{"error":"invalid token"}`, body)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/rules", strings.NewReader(`{"rules": [["p", "carol", "data1", "read"]]}`))
+	r.Header.Set("Authorization", "Bearer secret")
+	s.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestLimits(t *testing.T) {
+	s := newTestServer(t, OptionMaxBodySize(64), OptionMaxBatchSize(2))
+
+	status, _ := do(s, "POST", "/enforce", `{"sub": "alice", "obj": "data1", "act": "read"}`)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = do(s, "POST", "/enforce", `{"sub": "`+strings.Repeat("a", 64)+`", "obj": "data1", "act": "read"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+
+	request := `{"sub": "bob", "obj": "data2", "act": "write"}`
+	status, _ = do(newTestServer(t, OptionMaxBatchSize(2)), "POST", "/batch-enforce", "["+request+","+request+"]")
+	assert.Equal(t, http.StatusOK, status)
+	status, body := do(newTestServer(t, OptionMaxBatchSize(2)), "POST", "/batch-enforce", "["+request+","+request+","+request+"]")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+	assert.Equal(t, `{"error":"error: batch contains 3 requests, the limit is 2"}`, body)
+}
+
+type failingAdapter struct {
+	adapter.NoopAdapter
+}
+
+func (a *failingAdapter) AddRule(rule []string) error {
+	return errors.New("adapter unavailable")
+}
+
+func (a *failingAdapter) RemoveRule(rule []string) error {
+	return a.AddRule(rule)
+}
+
+func TestStorageError(t *testing.T) {
+	e, err := fastac.NewEnforcer("../examples/basic_model.conf", &failingAdapter{}, fastac.OptionAutosave(true))
+	assert.NoError(t, err)
+	s := NewServer(e)
+
+	status, body := do(s, "POST", "/rules", `{"rules": [["p", "carol", "data1", "read"]]}`)
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Contains(t, body, "adapter unavailable")
+	_, body = do(s, "GET", "/rules", "")
+	assert.Equal(t, `{"rules":[]}`, body)
+
+	//invalid rules are still rejected with 400
+	status, _ = do(s, "POST", "/rules", `{"rules": [["x", "carol"]]}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestConcurrentChanges(t *testing.T) {
+	s := newTestServer(t)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			status, _ := do(s, "POST", "/rules", fmt.Sprintf(`{"rules": [["p", "user%d", "data1", "read", "allow"]]}`, i))
+			assert.Equal(t, http.StatusNoContent, status)
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			status, _ := do(s, "POST", "/enforce", fmt.Sprintf(`{"sub": "user%d", "obj": "data1", "act": "read"}`, i))
+			assert.Equal(t, http.StatusOK, status)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 20; i++ {
+		_, body := do(s, "POST", "/enforce", fmt.Sprintf(`{"sub": "user%d", "obj": "data1", "act": "read"}`, i))
+		assert.Equal(t, `{"allow":true}`, body)
+	}
+}
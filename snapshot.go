@@ -0,0 +1,51 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fastac
+
+import (
+	m "github.com/abichinger/fastac/model"
+)
+
+// Snapshot returns an immutable copy of all rules of the model
+func (e *Enforcer) Snapshot() *m.Snapshot {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.model.Snapshot()
+}
+
+// Restore reverts the rules of the model to the state of snapshot s.
+// The difference to the current rules is applied as a single transaction,
+// the storage adapter receives one change set and the model is left untouched if storing fails.
+//
+//  s := e.Snapshot()
+//  //...
+//  err := e.Restore(s)
+func (e *Enforcer) Restore(s *m.Snapshot) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	added, removed := m.Diff(e.model, s)
+	return e.applyChanges(added, removed)
+}
+
+// applyChanges removes and adds rules as a single transaction, the caller must hold e.mu
+func (e *Enforcer) applyChanges(added, removed [][]string) error {
+	return e.transaction(true, func(tx *Tx) error {
+		if err := tx.RemoveRules(removed); err != nil {
+			return err
+		}
+		return tx.AddRules(added)
+	})
+}
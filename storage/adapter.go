@@ -49,3 +49,31 @@ type BatchAdapter interface {
 	api.IAddRules
 	api.IRemoveRules
 }
+
+// UpdatableAdapter is the interface for adapters, which can replace rules in place.
+type UpdatableAdapter interface {
+	Adapter
+
+	api.IUpdateRule
+	api.IUpdateRules
+}
+
+// TransactionalAdapter is the interface for adapters, which can store a set of changes atomically.
+// Either all changes are stored or none of them.
+type TransactionalAdapter interface {
+	Adapter
+
+	// ApplyChanges removes and adds rules in a single atomic operation. added and removed are disjoint.
+	ApplyChanges(added, removed [][]string) error
+}
+
+// ExpiryAdapter is the interface for adapters, which store the expiry times of rules.
+// LoadPolicy loads the expiry times into models implementing api.ISetExpiry,
+// SavePolicy stores the expiry times of models implementing api.IGetExpiry.
+// The expiry of a rule is discarded, when the rule is removed from the adapter.
+type ExpiryAdapter interface {
+	Adapter
+
+	// SetExpiry stores the expiry time of rule, a zero time removes the expiry
+	api.ISetExpiry
+}
@@ -15,10 +15,21 @@
 package adapter
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/abichinger/fastac/model/defs"
+	"github.com/abichinger/fastac/storage"
 	"github.com/abichinger/fastac/testutil"
+	"github.com/abichinger/fastac/util"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestFileAdapter(t *testing.T) {
@@ -27,3 +38,401 @@ func TestFileAdapter(t *testing.T) {
 
 	testutil.BasicAdapterTest(t, a)
 }
+
+func TestJSONAdapter(t *testing.T) {
+	a := NewJSONAdapter("test.json", defs.NewPolicyDef("p", "sub, obj, act"))
+	defer os.Remove("test.json")
+
+	testutil.BasicAdapterTest(t, a)
+}
+
+func TestYAMLAdapter(t *testing.T) {
+	a := NewYAMLAdapter("test.yaml", defs.NewPolicyDef("p", "sub, obj, act"))
+	defer os.Remove("test.yaml")
+
+	testutil.BasicAdapterTest(t, a)
+}
+
+func TestDocAdapterColumns(t *testing.T) {
+	path := "test_columns.yaml"
+	defer os.Remove(path)
+
+	pDef := defs.NewPolicyDef("p", "sub, obj, act, eft")
+	a := NewYAMLAdapter(path, pDef)
+
+	doc := "p:\n  - sub: alice\n    act: read\n  - sub: bob\n    obj: data1\n    act: write\n    eft: deny\ng:\n  - user: alice\n    role: admin\n"
+	assert.NoError(t, ioutil.WriteFile(path, []byte(doc), 0600))
+
+	rs := NewRuleSet()
+	assert.NoError(t, a.LoadPolicy(rs))
+	assert.ElementsMatch(t, []string{
+		"p,alice,,read",
+		"p,bob,data1,write,deny",
+		"g,alice,admin",
+	}, util.Join2D(rs.Rules(), ","))
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("p:\n  - sub: alice\n    object: data1\n"), 0600))
+	assert.Error(t, a.LoadPolicy(NewRuleSet()))
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("q:\n  - sub: alice\n"), 0600))
+	assert.Error(t, a.LoadPolicy(NewRuleSet()))
+
+	assert.Error(t, a.AddRule([]string{"p", "alice", "data1", "read", "allow", "extra"}))
+}
+
+func TestFileAdapterOptions(t *testing.T) {
+	path := "test_options.csv"
+	defer os.Remove(path)
+	defer os.Remove(path + ".lock")
+
+	a := NewFileAdapter(path, FileOptionAppend(true), FileOptionLock(true))
+	testutil.BasicAdapterTest(t, a)
+}
+
+func TestFileAdapterAppend(t *testing.T) {
+	path := "test_append.csv"
+	defer os.Remove(path)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("p, alice, data1, read"), 0600))
+
+	a := NewFileAdapter(path, FileOptionAppend(true))
+	assert.NoError(t, a.AddRule([]string{"p", "bob", "data2", "write"}))
+	assert.NoError(t, a.AddRules([][]string{{"g", "alice", "admin"}, {"g", "bob", "admin"}}))
+
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "p, alice, data1, read\np, bob, data2, write\ng, alice, admin\ng, bob, admin\n", string(content))
+}
+
+func TestFileAdapterAtomicSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastac")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policy.csv")
+	a := NewFileAdapter(path)
+	assert.NoError(t, a.SavePolicy(&testutil.ModelMock{}))
+	assert.NoError(t, a.AddRule([]string{"p", "alice", "data1", "read"}))
+	assert.NoError(t, a.RemoveRule([]string{"p", "bob", "data1", "read"}))
+
+	//a failing write must not touch the existing file
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		_, _ = w.Write([]byte("p, bob"))
+		return errors.New("write failed")
+	})
+	assert.Error(t, err)
+
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "p, alice, data1, read\n", string(content))
+
+	//no temporary files are left behind
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, "policy.csv", files[0].Name())
+}
+
+func TestFileAdapterExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastac")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policy.csv")
+	at := time.Date(2022, 6, 1, 8, 0, 0, 0, time.UTC)
+	a := NewFileAdapter(path)
+	assert.NoError(t, a.SavePolicy(&testutil.ModelMock{}))
+	assert.NoError(t, a.AddRule([]string{"g", "alice", "admin"}))
+	assert.NoError(t, a.SetExpiry([]string{"g", "alice", "admin"}, at))
+	//the expiry can be stored before the rule
+	assert.NoError(t, a.SetExpiry([]string{"g", "bob", "admin"}, at))
+	assert.NoError(t, a.AddRule([]string{"g", "bob", "admin"}))
+
+	rs := NewRuleSet()
+	assert.NoError(t, a.LoadPolicy(rs))
+	for _, name := range []string{"alice", "bob"} {
+		expires, ok := rs.GetExpiry([]string{"g", name, "admin"})
+		assert.True(t, ok)
+		assert.True(t, at.Equal(expires))
+	}
+
+	//the expiry is moved by updates and removed with the rule
+	assert.NoError(t, a.UpdateRule([]string{"g", "alice", "admin"}, []string{"g", "carol", "admin"}))
+	assert.NoError(t, a.RemoveRule([]string{"g", "bob", "admin"}))
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "g, carol, admin\n#expires, 2022-06-01T08:00:00Z, g, carol, admin\n", string(content))
+
+	//in append-only mode, the latest expiry line wins
+	a = NewFileAdapter(path, FileOptionAppend(true))
+	assert.NoError(t, a.SetExpiry([]string{"g", "carol", "admin"}, time.Time{}))
+	rs = NewRuleSet()
+	assert.NoError(t, a.LoadPolicy(rs))
+	_, ok := rs.GetExpiry([]string{"g", "carol", "admin"})
+	assert.False(t, ok)
+}
+
+func TestFileAdapterLock(t *testing.T) {
+	path := "test_lock.csv"
+	defer os.Remove(path)
+	defer os.Remove(path + ".lock")
+
+	assert.NoError(t, NewFileAdapter(path).SavePolicy(&testutil.ModelMock{}))
+
+	n := 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			a := NewFileAdapter(path, FileOptionLock(true))
+			assert.NoError(t, a.AddRule([]string{"p", fmt.Sprintf("user%d", i), "data1", "read"}))
+		}(i)
+	}
+	wg.Wait()
+
+	rs := NewRuleSet()
+	assert.NoError(t, NewFileAdapter(path).LoadPolicy(rs))
+	assert.Len(t, rs.Rules(), n)
+}
+
+func loadRules(t *testing.T, a storage.Adapter) []string {
+	t.Helper()
+	rs := NewRuleSet()
+	assert.NoError(t, a.LoadPolicy(rs))
+	return util.Join2D(rs.Rules(), ",")
+}
+
+func TestWALAdapter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastac")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a, err := NewWALAdapter(dir)
+	assert.NoError(t, err)
+	defer a.Close()
+
+	testutil.BasicAdapterTest(t, a)
+}
+
+func TestWALAdapterRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastac")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a, err := NewWALAdapter(dir, WALOptionCompactAfter(0))
+	assert.NoError(t, err)
+	assert.NoError(t, a.AddRules([][]string{{"p", "alice", "data1", "read"}, {"p", "bob", "data2", "write"}}))
+	assert.NoError(t, a.AddRule([]string{"g", "alice", "admin, with comma"}))
+	assert.NoError(t, a.RemoveRule([]string{"p", "bob", "data2", "write"}))
+	assert.NoError(t, a.Close())
+	assert.Error(t, a.AddRule([]string{"p", "bob", "data1", "read"}))
+
+	expected := []string{"p,alice,data1,read", "g,alice,admin, with comma"}
+
+	a, err = NewWALAdapter(dir)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, expected, loadRules(t, a))
+	assert.NoError(t, a.Close())
+
+	logPath := filepath.Join(dir, walLogFile)
+	data, err := ioutil.ReadFile(logPath)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"torn header", append(append([]byte{}, data...), 0, 0, 0)},
+		{"torn payload", append(append([]byte{}, data...), 0, 0, 0, 100, 0, 0, 0, 0, '+', 0)},
+		{"checksum", append(append([]byte{}, data...), 0, 0, 0, 10, 1, 2, 3, 4, '+', 0, 0, 0, 0, 0, 0, 0, 9, 'x')},
+		{"zeros", append(append([]byte{}, data...), make([]byte, 32)...)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.NoError(t, ioutil.WriteFile(logPath, test.data, 0600))
+
+			a, err = NewWALAdapter(dir)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, expected, loadRules(t, a))
+
+			//the corrupted tail is truncated, new records are readable
+			assert.NoError(t, a.AddRule([]string{"p", "carol", "data3", "read"}))
+			assert.NoError(t, a.Close())
+
+			a, err = NewWALAdapter(dir)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, append([]string{"p,carol,data3,read"}, expected...), loadRules(t, a))
+			assert.NoError(t, a.Close())
+		})
+	}
+}
+
+func TestWALAdapterCorruption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastac")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a, err := NewWALAdapter(dir, WALOptionCompactAfter(0))
+	assert.NoError(t, err)
+	assert.NoError(t, a.AddRule([]string{"p", "alice", "data1", "read"}))
+	assert.NoError(t, a.AddRule([]string{"p", "bob", "data2", "write"}))
+	assert.NoError(t, a.Close())
+
+	//flip a byte of the first record, the second record is still intact
+	logPath := filepath.Join(dir, walLogFile)
+	data, err := ioutil.ReadFile(logPath)
+	assert.NoError(t, err)
+	data[walHeaderSize+walPayloadHead] ^= 0xff
+	assert.NoError(t, ioutil.WriteFile(logPath, data, 0600))
+
+	_, err = NewWALAdapter(dir)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+	unchanged, err := ioutil.ReadFile(logPath)
+	assert.NoError(t, err)
+	assert.Equal(t, data, unchanged)
+}
+
+func TestWALAdapterCompactionError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastac")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a, err := NewWALAdapter(dir, WALOptionCompactAfter(2))
+	assert.NoError(t, err)
+
+	//the snapshot can not be replaced by a file
+	snapshot := filepath.Join(dir, walSnapshotFile)
+	assert.NoError(t, os.MkdirAll(filepath.Join(snapshot, "dir"), 0700))
+	assert.NoError(t, a.AddRule([]string{"p", "alice", "data1", "read"}))
+	assert.NoError(t, a.AddRule([]string{"p", "bob", "data2", "write"}))
+	assert.Error(t, a.LastCompactError())
+
+	//the compaction is retried with the next record
+	assert.NoError(t, os.RemoveAll(snapshot))
+	assert.NoError(t, a.RemoveRule([]string{"p", "alice", "data1", "read"}))
+	assert.NoError(t, a.LastCompactError())
+	assert.Equal(t, 0, a.records)
+	assert.NoError(t, a.Close())
+
+	a, err = NewWALAdapter(dir)
+	assert.NoError(t, err)
+	defer a.Close()
+	assert.Equal(t, []string{"p,bob,data2,write"}, loadRules(t, a))
+}
+
+func TestWALAdapterSavePolicyError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastac")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a, err := NewWALAdapter(dir)
+	assert.NoError(t, err)
+	defer a.Close()
+	assert.NoError(t, a.AddRule([]string{"p", "alice", "data1", "read"}))
+
+	//the snapshot can not be replaced by a file
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, walSnapshotFile, "dir"), 0700))
+	rs := NewRuleSet()
+	_, _ = rs.AddRule([]string{"p", "bob", "data2", "write"})
+	assert.Error(t, a.SavePolicy(rs))
+	assert.Equal(t, []string{"p,alice,data1,read"}, loadRules(t, a))
+}
+
+func TestWALAdapterCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastac")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a, err := NewWALAdapter(dir, WALOptionCompactAfter(3))
+	assert.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, a.AddRule([]string{"p", fmt.Sprintf("user%d", i), "data1", "read"}))
+	}
+	assert.Equal(t, 2, a.records)
+
+	//simulate a crash after the snapshot has been written, but before the log was truncated
+	logPath := filepath.Join(dir, walLogFile)
+	stale, err := ioutil.ReadFile(logPath)
+	assert.NoError(t, err)
+	assert.NoError(t, a.RemoveRule([]string{"p", "user0", "data1", "read"}))
+	assert.Equal(t, 0, a.records)
+	assert.NoError(t, a.Close())
+	assert.NoError(t, ioutil.WriteFile(logPath, stale, 0600))
+
+	a, err = NewWALAdapter(dir)
+	assert.NoError(t, err)
+	defer a.Close()
+	assert.ElementsMatch(t, []string{
+		"p,user1,data1,read",
+		"p,user2,data1,read",
+		"p,user3,data1,read",
+		"p,user4,data1,read",
+	}, loadRules(t, a))
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, walSnapshotFile), []byte("corrupted"), 0600))
+	_, err = NewWALAdapter(dir)
+	assert.Error(t, err)
+}
+
+func TestApplyChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastac")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wal, err := NewWALAdapter(filepath.Join(dir, "wal"))
+	assert.NoError(t, err)
+	defer wal.Close()
+
+	adapters := []storage.TransactionalAdapter{
+		NewFileAdapter(filepath.Join(dir, "policy.csv")),
+		NewJSONAdapter(filepath.Join(dir, "policy.json"), defs.NewPolicyDef("p", "sub, obj, act")),
+		wal,
+	}
+
+	for _, a := range adapters {
+		assert.NoError(t, a.SavePolicy(&testutil.ModelMock{}))
+		assert.NoError(t, a.ApplyChanges([][]string{{"p", "alice", "data1", "read"}, {"g", "alice", "admin"}}, nil))
+		assert.NoError(t, a.ApplyChanges([][]string{{"p", "bob", "data1", "read"}}, [][]string{{"g", "alice", "admin"}}))
+		assert.ElementsMatch(t, []string{"p,alice,data1,read", "p,bob,data1,read"}, loadRules(t, a))
+	}
+
+	assert.NoError(t, wal.Close())
+	wal, err = NewWALAdapter(filepath.Join(dir, "wal"))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"p,alice,data1,read", "p,bob,data1,read"}, loadRules(t, wal))
+	assert.NoError(t, wal.Close())
+}
+
+func TestUpdateRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastac")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wal, err := NewWALAdapter(filepath.Join(dir, "wal"))
+	assert.NoError(t, err)
+	defer wal.Close()
+
+	adapters := []storage.UpdatableAdapter{
+		NewFileAdapter(filepath.Join(dir, "policy.csv")),
+		NewYAMLAdapter(filepath.Join(dir, "policy.yaml"), defs.NewPolicyDef("p", "sub, obj, act")),
+		wal,
+	}
+
+	for _, a := range adapters {
+		assert.NoError(t, a.SavePolicy(&testutil.ModelMock{}))
+		assert.NoError(t, a.UpdateRules(nil, nil))
+		assert.NoError(t, a.(storage.BatchAdapter).AddRules([][]string{{"p", "alice", "data1", "read"}, {"g", "alice", "admin"}}))
+		assert.NoError(t, a.UpdateRule([]string{"g", "alice", "admin"}, []string{"g", "bob", "admin"}))
+		//updates are applied in order
+		assert.NoError(t, a.UpdateRules(
+			[][]string{{"p", "alice", "data1", "read"}, {"p", "alice", "data2", "read"}},
+			[][]string{{"p", "alice", "data2", "read"}, {"p", "alice", "data3", "read"}},
+		))
+		assert.Error(t, a.UpdateRules([][]string{{"p", "alice", "data3", "read"}}, [][]string{}))
+		assert.ElementsMatch(t, []string{"p,alice,data3,read", "g,bob,admin"}, loadRules(t, a))
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/abichinger/fastac/api"
+	"github.com/abichinger/fastac/model/defs"
+	"github.com/abichinger/fastac/str"
+	"github.com/abichinger/fastac/util"
+)
+
+// Document is the in-memory representation of a JSON or YAML policy file.
+// Rules are grouped by their policy key, every rule maps column names to values.
+//
+//  p:
+//    - sub: alice
+//      obj: data1
+//      act: read
+//  g:
+//    - user: alice
+//      role: admin
+type Document map[string][]map[string]string
+
+type docAdapter struct {
+	path      string
+	pDefs     map[string]*defs.PolicyDef
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, v interface{}) error
+}
+
+func newDocAdapter(path string, pDefs []*defs.PolicyDef, marshal func(v interface{}) ([]byte, error), unmarshal func(data []byte, v interface{}) error) *docAdapter {
+	a := &docAdapter{
+		path:      path,
+		pDefs:     make(map[string]*defs.PolicyDef),
+		marshal:   marshal,
+		unmarshal: unmarshal,
+	}
+	for _, pDef := range pDefs {
+		a.pDefs[pDef.GetKey()] = pDef
+	}
+	return a
+}
+
+// getDef returns the policy definition of key.
+// Grouping rules without an explicit definition use the columns user, role and domain.
+func (a *docAdapter) getDef(key string) (*defs.PolicyDef, error) {
+	if pDef, ok := a.pDefs[key]; ok {
+		return pDef, nil
+	}
+	if len(key) > 0 && key[0] == 'g' {
+		pDef := defs.NewPolicyDef(key, defs.DefaultRoleArgs)
+		a.pDefs[key] = pDef
+		return pDef, nil
+	}
+	return nil, fmt.Errorf(str.ERR_POLICY_NOT_FOUND, key)
+}
+
+// toRule converts a document entry into a rule, the policy key is the first value of the rule
+func (a *docAdapter) toRule(key string, entry map[string]string) ([]string, error) {
+	pDef, err := a.getDef(key)
+	if err != nil {
+		return nil, err
+	}
+	args := pDef.GetArgs()
+	for column := range entry {
+		if !pDef.Has(key + "_" + column) {
+			return nil, fmt.Errorf(str.ERR_UNKNOWN_COLUMN, column, key)
+		}
+	}
+
+	//trailing columns, which are not present, are omitted
+	n := 0
+	for i, arg := range args {
+		if _, ok := entry[arg]; ok {
+			n = i + 1
+		}
+	}
+
+	rule := []string{key}
+	for _, arg := range args[:n] {
+		rule = append(rule, entry[arg])
+	}
+	return rule, nil
+}
+
+// toEntry converts a rule into a document entry
+func (a *docAdapter) toEntry(rule []string) (map[string]string, error) {
+	key := rule[0]
+	pDef, err := a.getDef(key)
+	if err != nil {
+		return nil, err
+	}
+	args := pDef.GetArgs()
+	if len(rule)-1 > len(args) {
+		return nil, fmt.Errorf(str.ERR_TOO_MANY_VALUES, rule, key)
+	}
+	entry := make(map[string]string, len(rule)-1)
+	for i, value := range rule[1:] {
+		entry[args[i]] = value
+	}
+	return entry, nil
+}
+
+func (a *docAdapter) read() (Document, error) {
+	data, err := ioutil.ReadFile(a.path)
+	if err != nil {
+		return nil, err
+	}
+	doc := Document{}
+	if len(data) == 0 {
+		return doc, nil
+	}
+	if err := a.unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (a *docAdapter) write(doc Document) error {
+	data, err := a.marshal(doc)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(a.path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func (a *docAdapter) LoadPolicy(model api.IAddRuleBool) error {
+	doc, err := a.read()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{}
+	for key := range doc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, entry := range doc[key] {
+			rule, err := a.toRule(key, entry)
+			if err != nil {
+				return err
+			}
+			if _, err := model.AddRule(rule); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (a *docAdapter) SavePolicy(model api.IRangeRules) error {
+	rules := [][]string{}
+	model.RangeRules(func(rule []string) bool {
+		rules = append(rules, rule)
+		return true
+	})
+
+	//sort rules to get a stable output
+	sort.Slice(rules, func(i, j int) bool {
+		return util.Hash(rules[i]) < util.Hash(rules[j])
+	})
+
+	doc := Document{}
+	for _, rule := range rules {
+		entry, err := a.toEntry(rule)
+		if err != nil {
+			return err
+		}
+		doc[rule[0]] = append(doc[rule[0]], entry)
+	}
+
+	return a.write(doc)
+}
+
+// modify loads the stored rules into a RuleSet, applies fn and saves the result, if fn reports a change
+func (a *docAdapter) modify(fn func(rs *RuleSet) (bool, error)) error {
+	rs := NewRuleSet()
+	if err := a.LoadPolicy(rs); err != nil {
+		return err
+	}
+	changed, err := fn(rs)
+	if err != nil || !changed {
+		return err
+	}
+	return a.SavePolicy(rs)
+}
+
+func (a *docAdapter) AddRule(rule []string) error {
+	return a.AddRules([][]string{rule})
+}
+
+func (a *docAdapter) RemoveRule(rule []string) error {
+	return a.RemoveRules([][]string{rule})
+}
+
+func (a *docAdapter) AddRules(rules [][]string) error {
+	return a.ApplyChanges(rules, nil)
+}
+
+func (a *docAdapter) RemoveRules(rules [][]string) error {
+	return a.ApplyChanges(nil, rules)
+}
+
+func (a *docAdapter) UpdateRule(oldRule, newRule []string) error {
+	return a.UpdateRules([][]string{oldRule}, [][]string{newRule})
+}
+
+// UpdateRules replaces each rule of oldRules with the rule of newRules at the same index
+func (a *docAdapter) UpdateRules(oldRules, newRules [][]string) error {
+	return a.modify(func(rs *RuleSet) (bool, error) {
+		return rs.applyUpdates(oldRules, newRules)
+	})
+}
+
+// ApplyChanges removes and adds rules with a single atomic save
+func (a *docAdapter) ApplyChanges(added, removed [][]string) error {
+	return a.modify(func(rs *RuleSet) (bool, error) {
+		return rs.applyChanges(added, removed)
+	})
+}
@@ -17,12 +17,17 @@ package adapter
 import (
 	"bufio"
 	"encoding/csv"
+	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/abichinger/fastac/api"
 	"github.com/abichinger/fastac/model/defs"
 	"github.com/abichinger/fastac/model/policy"
+	"github.com/abichinger/fastac/str"
 	"github.com/abichinger/fastac/util"
 )
 
@@ -46,17 +51,95 @@ func LoadPolicyLine(line string, m api.IAddRuleBool) error {
 	return err
 }
 
+// expiryPrefix starts a line, which stores the expiry time of a rule: #expires, <RFC 3339 time>, <rule>
+// Readers without expiry support treat the line as comment.
+const expiryPrefix = "#expires,"
+
+// loadExpiryLine loads a line starting with expiryPrefix as expiry time to model
+func loadExpiryLine(line string, m api.ISetExpiry) error {
+	r := csv.NewReader(strings.NewReader(strings.TrimPrefix(line, expiryPrefix)))
+	r.TrimLeadingSpace = true
+
+	tokens, err := r.Read()
+	if err != nil {
+		return err
+	}
+	if len(tokens) < 2 {
+		return fmt.Errorf(str.ERR_INVALID_TIME, line)
+	}
+	var at time.Time
+	if tokens[0] != "" {
+		if at, err = time.Parse(time.RFC3339, tokens[0]); err != nil {
+			return fmt.Errorf(str.ERR_INVALID_TIME, tokens[0])
+		}
+	}
+	return m.SetExpiry(tokens[1:], at)
+}
+
+func expiryToLine(rule []string, at time.Time) string {
+	value := ""
+	if !at.IsZero() {
+		value = at.UTC().Format(time.RFC3339)
+	}
+	return ruleToLine(append([]string{expiryPrefix[:len(expiryPrefix)-1], value}, rule...))
+}
+
 type FileAdapter struct {
-	path string
+	path       string
+	appendOnly bool
+	locking    bool
+}
+
+type FileAdapterOption func(a *FileAdapter)
+
+// FileOptionAppend enables/disables the append-only mode (default: disabled)
+// In append-only mode AddRule and AddRules append the rules to the end of the file instead of rewriting it.
+// Duplicate rules are not detected, they are removed the next time the file is rewritten.
+func FileOptionAppend(enable bool) FileAdapterOption {
+	return func(a *FileAdapter) {
+		a.appendOnly = enable
+	}
+}
+
+// FileOptionLock enables/disables file locking (default: disabled)
+// If enabled, an advisory lock on the file <path>.lock guards every read and write,
+// which allows multiple processes to share the same policy file.
+func FileOptionLock(enable bool) FileAdapterOption {
+	return func(a *FileAdapter) {
+		a.locking = enable
+	}
+}
+
+type ruleExpiry struct {
+	rule []string
+	at   time.Time
 }
 
 type RuleSet struct {
 	*policy.Policy
+	expiries map[string]ruleExpiry
 }
 
 func NewRuleSet() *RuleSet {
 	def := defs.NewPolicyDef("", "")
-	return &RuleSet{Policy: policy.NewPolicy(def)}
+	return &RuleSet{Policy: policy.NewPolicy(def), expiries: map[string]ruleExpiry{}}
+}
+
+// SetExpiry sets the expiry time of rule, a zero time removes the expiry.
+// The rule does not need to be present, e.g. if the rule is stored after its expiry.
+func (set *RuleSet) SetExpiry(rule []string, at time.Time) error {
+	if at.IsZero() {
+		delete(set.expiries, util.Hash(rule))
+	} else {
+		set.expiries[util.Hash(rule)] = ruleExpiry{append([]string{}, rule...), at}
+	}
+	return nil
+}
+
+// GetExpiry returns the expiry time of rule, false is returned if the rule does not expire
+func (set *RuleSet) GetExpiry(rule []string) (time.Time, bool) {
+	entry, ok := set.expiries[util.Hash(rule)]
+	return entry.at, ok
 }
 
 func (set *RuleSet) RangeRules(fn func(rule []string) bool) {
@@ -74,20 +157,108 @@ func (set *RuleSet) Rules() [][]string {
 	return rules
 }
 
-func NewFileAdapter(path string) *FileAdapter {
-	return &FileAdapter{path: path}
+// applyChanges removes and adds rules, returns true if the RuleSet has been modified
+func (set *RuleSet) applyChanges(added, removed [][]string) (bool, error) {
+	changed := false
+	for _, rule := range removed {
+		ok, err := set.RemoveRule(rule)
+		if err != nil {
+			return false, err
+		}
+		//the expiry might be stored before the rule
+		if _, expires := set.GetExpiry(rule); expires {
+			_ = set.SetExpiry(rule, time.Time{})
+			ok = true
+		}
+		changed = changed || ok
+	}
+	for _, rule := range added {
+		ok, err := set.AddRule(rule)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || ok
+	}
+	return changed, nil
+}
+
+// applyUpdates replaces each rule of oldRules with the rule of newRules at the same index.
+// The updates are applied in order, so later updates can build on earlier ones.
+func (set *RuleSet) applyUpdates(oldRules, newRules [][]string) (bool, error) {
+	if len(oldRules) != len(newRules) {
+		return false, fmt.Errorf(str.ERR_UPDATE_LENGTH, len(oldRules), len(newRules))
+	}
+	changed := false
+	for i := range oldRules {
+		at, expires := set.GetExpiry(oldRules[i])
+		ok, err := set.applyChanges(newRules[i:i+1], oldRules[i:i+1])
+		if err != nil {
+			return false, err
+		}
+		if expires {
+			_ = set.SetExpiry(newRules[i], at)
+		}
+		changed = changed || ok
+	}
+	return changed, nil
+}
+
+// NewFileAdapter creates a FileAdapter, which stores rules as CSV lines.
+// Saves are atomic: the rules are written to a temporary file, which replaces the policy file afterwards.
+//
+//  NewFileAdapter("policy.csv", FileOptionAppend(true), FileOptionLock(true))
+func NewFileAdapter(path string, options ...FileAdapterOption) *FileAdapter {
+	a := &FileAdapter{path: path}
+	for _, option := range options {
+		option(a)
+	}
+	return a
+}
+
+// withLock calls fn while holding the file lock, if locking is enabled
+func (a *FileAdapter) withLock(exclusive bool, fn func() error) (err error) {
+	if !a.locking {
+		return fn()
+	}
+	l, err := lockFile(a.path+".lock", exclusive)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if uErr := l.Unlock(); err == nil {
+			err = uErr
+		}
+	}()
+	return fn()
 }
 
 func (a *FileAdapter) LoadPolicy(model api.IAddRuleBool) error {
+	return a.withLock(false, func() error {
+		return a.loadPolicy(model)
+	})
+}
+
+func (a *FileAdapter) loadPolicy(model api.IAddRuleBool) error {
 	file, err := os.Open(a.path)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
+	expiries, _ := model.(api.ISetExpiry)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		if err := LoadPolicyLine(scanner.Text(), model); err != nil {
+		line := scanner.Text()
+		if strings.HasPrefix(line, expiryPrefix) {
+			if expiries == nil {
+				continue
+			}
+			if err := loadExpiryLine(line, expiries); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := LoadPolicyLine(line, model); err != nil {
 			return err
 		}
 	}
@@ -95,98 +266,141 @@ func (a *FileAdapter) LoadPolicy(model api.IAddRuleBool) error {
 	return scanner.Err()
 }
 
-func getWriter(path string) (*bufio.Writer, error) {
-	if exists, err := util.FileExists(path); err != nil {
-		return nil, err
-	} else if exists {
-		if err := os.Remove(path); err != nil {
-			return nil, err
-		}
-	}
-	if _, err := os.Create(path); err != nil {
-		return nil, err
-	}
-	f, err := os.OpenFile(path, os.O_WRONLY, 0600)
-	if err != nil {
-		return nil, err
-	}
-	return bufio.NewWriter(f), nil
+func ruleToLine(rule []string) string {
+	return strings.Join(rule, ", ") + "\n"
 }
 
 func (a *FileAdapter) SavePolicy(model api.IRangeRules) error {
-	writer, err := getWriter(a.path)
-	if err != nil {
-		return err
-	}
-	model.RangeRules(func(rule []string) bool {
-		if _, err = writer.WriteString(strings.Join(rule, ", ") + "\n"); err != nil {
-			return false
+	return a.withLock(true, func() error {
+		return a.savePolicy(model)
+	})
+}
+
+func (a *FileAdapter) savePolicy(model api.IRangeRules) error {
+	return writeFileAtomic(a.path, func(w io.Writer) error {
+		writer := bufio.NewWriter(w)
+		expiries, _ := model.(api.IGetExpiry)
+		written := map[string]bool{}
+		var err error
+		model.RangeRules(func(rule []string) bool {
+			if _, err = writer.WriteString(ruleToLine(rule)); err != nil {
+				return false
+			}
+			if expiries == nil {
+				return true
+			}
+			if at, ok := expiries.GetExpiry(rule); ok {
+				written[util.Hash(rule)] = true
+				_, err = writer.WriteString(expiryToLine(rule, at))
+			}
+			return err == nil
+		})
+		if err != nil {
+			return err
 		}
-		return true
+
+		//expiries of rules, which are not stored yet
+		if rs, ok := model.(*RuleSet); ok {
+			keys := []string{}
+			for key := range rs.expiries {
+				if !written[key] {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				entry := rs.expiries[key]
+				if _, err := writer.WriteString(expiryToLine(entry.rule, entry.at)); err != nil {
+					return err
+				}
+			}
+		}
+		return writer.Flush()
 	})
-	if err != nil {
-		return err
-	}
+}
 
-	return writer.Flush()
+// modify loads the stored rules into a RuleSet and applies fn while holding the lock.
+// The file is only rewritten, if fn reports a change.
+func (a *FileAdapter) modify(fn func(rs *RuleSet) (bool, error)) error {
+	return a.withLock(true, func() error {
+		rs := NewRuleSet()
+		if err := a.loadPolicy(rs); err != nil {
+			return err
+		}
+		changed, err := fn(rs)
+		if err != nil || !changed {
+			return err
+		}
+		return a.savePolicy(rs)
+	})
 }
 
-func (a *FileAdapter) AddRule(rule []string) error {
-	rs := NewRuleSet()
-	if err := a.LoadPolicy(rs); err != nil {
-		return err
-	}
-	if _, err := rs.AddRule(rule); err != nil {
-		return err
+// appendRules appends rules to the end of the policy file
+func (a *FileAdapter) appendRules(rules [][]string) error {
+	var sb strings.Builder
+	for _, rule := range rules {
+		sb.WriteString(ruleToLine(rule))
 	}
-	if err := a.SavePolicy(rs); err != nil {
-		return err
+	return a.appendLines(sb.String())
+}
+
+func (a *FileAdapter) appendLines(lines string) error {
+	return a.withLock(true, func() error {
+		return appendFile(a.path, []byte(lines))
+	})
+}
+
+// SetExpiry stores the expiry time of rule in the policy file, a zero time removes the expiry.
+// The expiry is stored in a comment line, which is removed together with the rule.
+//
+//  p, alice, data1, read
+//  #expires, 2022-06-01T08:00:00Z, p, alice, data1, read
+func (a *FileAdapter) SetExpiry(rule []string, at time.Time) error {
+	if a.appendOnly {
+		return a.appendLines(expiryToLine(rule, at))
 	}
-	return nil
+	return a.modify(func(rs *RuleSet) (bool, error) {
+		old, ok := rs.GetExpiry(rule)
+		if ok == !at.IsZero() && old.Equal(at) {
+			return false, nil
+		}
+		return true, rs.SetExpiry(rule, at)
+	})
+}
+
+func (a *FileAdapter) AddRule(rule []string) error {
+	return a.AddRules([][]string{rule})
 }
 
 func (a *FileAdapter) RemoveRule(rule []string) error {
-	rs := NewRuleSet()
-	if err := a.LoadPolicy(rs); err != nil {
-		return err
-	}
-	if _, err := rs.RemoveRule(rule); err != nil {
-		return err
-	}
-	if err := a.SavePolicy(rs); err != nil {
-		return err
-	}
-	return nil
+	return a.RemoveRules([][]string{rule})
 }
 
 func (a *FileAdapter) AddRules(rules [][]string) error {
-	rs := NewRuleSet()
-	if err := a.LoadPolicy(rs); err != nil {
-		return err
+	if a.appendOnly {
+		return a.appendRules(rules)
 	}
-	for _, rule := range rules {
-		if _, err := rs.AddRule(rule); err != nil {
-			return err
-		}
-	}
-	if err := a.SavePolicy(rs); err != nil {
-		return err
-	}
-	return nil
+	return a.ApplyChanges(rules, nil)
 }
 
 func (a *FileAdapter) RemoveRules(rules [][]string) error {
-	rs := NewRuleSet()
-	if err := a.LoadPolicy(rs); err != nil {
-		return err
-	}
-	for _, rule := range rules {
-		if _, err := rs.RemoveRule(rule); err != nil {
-			return err
-		}
-	}
-	if err := a.SavePolicy(rs); err != nil {
-		return err
-	}
-	return nil
+	return a.ApplyChanges(nil, rules)
+}
+
+func (a *FileAdapter) UpdateRule(oldRule, newRule []string) error {
+	return a.UpdateRules([][]string{oldRule}, [][]string{newRule})
+}
+
+// UpdateRules replaces each rule of oldRules with the rule of newRules at the same index
+func (a *FileAdapter) UpdateRules(oldRules, newRules [][]string) error {
+	return a.modify(func(rs *RuleSet) (bool, error) {
+		return rs.applyUpdates(oldRules, newRules)
+	})
+}
+
+// ApplyChanges removes and adds rules with a single atomic save
+func (a *FileAdapter) ApplyChanges(added, removed [][]string) error {
+	return a.modify(func(rs *RuleSet) (bool, error) {
+		return rs.applyChanges(added, removed)
+	})
 }
@@ -0,0 +1,49 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"encoding/json"
+
+	"github.com/abichinger/fastac/model/defs"
+)
+
+// JSONAdapter stores policy rules in a JSON document.
+// The columns of a rule are named after the arguments of the policy definition.
+//
+//  {
+//    "p": [{"sub": "alice", "obj": "data1", "act": "read"}],
+//    "g": [{"user": "alice", "role": "admin"}]
+//  }
+type JSONAdapter struct {
+	*docAdapter
+}
+
+// NewJSONAdapter creates a JSONAdapter. Policy definitions are required for all p keys,
+// grouping rules without a definition use the columns user, role and domain.
+//
+//  pDef := defs.NewPolicyDef("p", "sub, obj, act")
+//  NewJSONAdapter("policy.json", pDef)
+func NewJSONAdapter(path string, pDefs ...*defs.PolicyDef) *JSONAdapter {
+	return &JSONAdapter{newDocAdapter(path, pDefs, marshalJSON, json.Unmarshal)}
+}
+
+func marshalJSON(v interface{}) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,459 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/abichinger/fastac/api"
+	"github.com/abichinger/fastac/log"
+	"github.com/abichinger/fastac/str"
+)
+
+const (
+	walSnapshotFile = "snapshot"
+	walLogFile      = "wal"
+
+	walHeaderSize  = 8 // payload size (4 bytes) + checksum (4 bytes)
+	walPayloadHead = 9 // opcode (1 byte) + sequence number (8 bytes)
+
+	DefaultCompactAfter = 1000
+)
+
+type walOp byte
+
+const (
+	walAdd      walOp = '+'
+	walRemove   walOp = '-'
+	walSnapshot walOp = 's'
+	walBatch    walOp = 'b' // the first value of each rule is the opcode (walAdd or walRemove)
+)
+
+// walRecord is a single entry of the log or the snapshot.
+//
+// Encoding: | size uint32 | crc32 uint32 | op byte | seq uint64 | rules as CSV |
+// size and crc32 refer to the payload, which starts at op.
+type walRecord struct {
+	op    walOp
+	seq   uint64
+	rules [][]string
+}
+
+func (rec *walRecord) encode() ([]byte, error) {
+	payload := &bytes.Buffer{}
+	payload.WriteByte(byte(rec.op))
+	_ = binary.Write(payload, binary.BigEndian, rec.seq)
+
+	w := csv.NewWriter(payload)
+	if err := w.WriteAll(rec.rules); err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, walHeaderSize, walHeaderSize+payload.Len())
+	binary.BigEndian.PutUint32(frame[0:4], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload.Bytes()))
+	return append(frame, payload.Bytes()...), nil
+}
+
+// decodeRecord decodes the first record of data and returns the number of bytes read
+func decodeRecord(data []byte) (*walRecord, int, error) {
+	if len(data) < walHeaderSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	size := int(binary.BigEndian.Uint32(data[0:4]))
+	sum := binary.BigEndian.Uint32(data[4:8])
+	if size < walPayloadHead {
+		return nil, 0, errors.New(str.ERR_WAL_INVALID_RECORD)
+	}
+	if len(data) < walHeaderSize+size {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	payload := data[walHeaderSize : walHeaderSize+size]
+	if crc32.ChecksumIEEE(payload) != sum {
+		return nil, 0, errors.New(str.ERR_WAL_CHECKSUM)
+	}
+
+	rec := &walRecord{
+		op:  walOp(payload[0]),
+		seq: binary.BigEndian.Uint64(payload[1:walPayloadHead]),
+	}
+	r := csv.NewReader(bytes.NewReader(payload[walPayloadHead:]))
+	r.FieldsPerRecord = -1
+	rules, err := r.ReadAll()
+	if err != nil {
+		return nil, 0, err
+	}
+	rec.rules = rules
+	return rec, walHeaderSize + size, nil
+}
+
+// WALAdapter is an embedded adapter, which persists rules in a directory without external dependencies.
+// Every change is appended to a write-ahead log (WAL) and synced to disk.
+// The log is compacted into a snapshot of all rules after a configurable number of changes.
+// Each record is protected by a checksum, on startup a partially written record at the end of the log is discarded.
+// Corrupted records before the end of the log are reported as error and the log is not modified.
+//
+// The directory must not be used by more than one WALAdapter at a time.
+type WALAdapter struct {
+	mu           sync.Mutex
+	dir          string
+	rules        *RuleSet
+	seq          uint64
+	log          *os.File
+	offset       int64
+	records      int // number of records since the last snapshot
+	compactAfter int
+	sync         bool
+	compactErr   error // error of the last automatic compaction
+}
+
+type WALOption func(a *WALAdapter)
+
+// WALOptionCompactAfter sets the number of log records after which a snapshot is created (default: DefaultCompactAfter)
+// A value <= 0 disables automatic compaction, Compact can be called manually.
+// A failed compaction does not fail the change, which triggered it. It is reported by LastCompactError and retried with the next change.
+func WALOptionCompactAfter(n int) WALOption {
+	return func(a *WALAdapter) {
+		a.compactAfter = n
+	}
+}
+
+// WALOptionSync enables/disables fsync after every log record (default: enabled)
+// Disabling sync improves the write performance, but the latest changes might be lost on power failure.
+func WALOptionSync(enable bool) WALOption {
+	return func(a *WALAdapter) {
+		a.sync = enable
+	}
+}
+
+// NewWALAdapter opens or creates a WALAdapter in dir and recovers the stored rules.
+//
+//  a, err := NewWALAdapter("data/policy")
+//  defer a.Close()
+//  e, err := fastac.NewEnforcer("model.conf", a, fastac.OptionAutosave(true))
+func NewWALAdapter(dir string, options ...WALOption) (*WALAdapter, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	a := &WALAdapter{
+		dir:          dir,
+		rules:        NewRuleSet(),
+		compactAfter: DefaultCompactAfter,
+		sync:         true,
+	}
+	for _, option := range options {
+		option(a)
+	}
+
+	if err := a.recover(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *WALAdapter) snapshotPath() string {
+	return filepath.Join(a.dir, walSnapshotFile)
+}
+
+func (a *WALAdapter) logPath() string {
+	return filepath.Join(a.dir, walLogFile)
+}
+
+// recover loads the snapshot and replays the log
+func (a *WALAdapter) recover() error {
+	path := a.snapshotPath()
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		rec, n, err := decodeRecord(data)
+		if err == nil && (rec.op != walSnapshot || n != len(data)) {
+			err = errors.New(str.ERR_WAL_INVALID_RECORD)
+		}
+		if err != nil {
+			return fmt.Errorf(str.ERR_WAL_CORRUPT, path, err)
+		}
+		a.apply(rec)
+	}
+
+	path = a.logPath()
+	data, err = ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	offset := 0
+	for offset < len(data) {
+		rec, n, err := decodeRecord(data[offset:])
+		if err != nil {
+			if !tornTail(data[offset:], err) {
+				//the log is left untouched, so it can be inspected or repaired
+				return fmt.Errorf(str.ERR_WAL_CORRUPT, path, err)
+			}
+			log.Logger().Warnf(str.ERR_WAL_CORRUPT+", discarding %d bytes", path, err, len(data)-offset)
+			break
+		}
+		offset += n
+		if rec.seq <= a.seq {
+			continue //record is already part of the snapshot
+		}
+		a.apply(rec)
+		a.records++
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	a.log = f
+	a.offset = int64(offset)
+	return a.resetLog(a.offset)
+}
+
+// tornTail reports whether the record at the start of data, which failed to decode with err, is the last one
+// and was only partially written, e.g. because of a crash. Only such a record may be discarded.
+func tornTail(data []byte, err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	//some file systems fill the unwritten end of a file with zeros
+	if len(bytes.Trim(data, "\x00")) == 0 {
+		return true
+	}
+	if err.Error() == str.ERR_WAL_CHECKSUM {
+		return walHeaderSize+int(binary.BigEndian.Uint32(data[0:4])) == len(data)
+	}
+	return false
+}
+
+// resetLog truncates the log to offset
+func (a *WALAdapter) resetLog(offset int64) error {
+	if err := a.log.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := a.log.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	a.offset = offset
+	return a.log.Sync()
+}
+
+func (a *WALAdapter) apply(rec *walRecord) {
+	if rec.op == walSnapshot {
+		a.rules = NewRuleSet()
+	}
+	for _, rule := range rec.rules {
+		op := rec.op
+		if op == walBatch {
+			if len(rule) == 0 || len(rule[0]) != 1 {
+				continue
+			}
+			op, rule = walOp(rule[0][0]), rule[1:]
+		}
+		switch op {
+		case walAdd, walSnapshot:
+			_, _ = a.rules.AddRule(append([]string{}, rule...))
+		case walRemove:
+			_, _ = a.rules.RemoveRule(rule)
+		}
+	}
+	a.seq = rec.seq
+}
+
+func (a *WALAdapter) append(op walOp, rules [][]string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.log == nil {
+		return errors.New(str.ERR_ADAPTER_CLOSED)
+	}
+
+	rec := &walRecord{op, a.seq + 1, rules}
+	frame, err := rec.encode()
+	if err != nil {
+		return err
+	}
+	if _, err := a.log.Write(frame); err != nil {
+		_ = a.resetLog(a.offset) //remove partially written record
+		return err
+	}
+	if a.sync {
+		if err := a.log.Sync(); err != nil {
+			_ = a.resetLog(a.offset)
+			return err
+		}
+	}
+	a.offset += int64(len(frame))
+	a.apply(rec)
+	a.records++
+
+	//the record is stored, a failed compaction is retried with the next record
+	if a.compactAfter > 0 && a.records >= a.compactAfter {
+		a.compactErr = a.compact()
+		if a.compactErr != nil {
+			log.Logger().Warnf(str.ERR_WAL_COMPACT, a.dir, a.compactErr)
+		}
+	}
+	return nil
+}
+
+// LastCompactError returns the error of the last automatic compaction or nil, if it succeeded
+func (a *WALAdapter) LastCompactError() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.compactErr
+}
+
+// compact writes a snapshot of all rules and truncates the log.
+// A crash between the two steps is safe, because records already contained in the snapshot are skipped during recovery.
+func (a *WALAdapter) compact() error {
+	if err := a.writeSnapshot(&walRecord{walSnapshot, a.seq, a.rules.Rules()}); err != nil {
+		return err
+	}
+	a.records = 0
+	return a.resetLog(0)
+}
+
+// writeSnapshot replaces the snapshot file with rec
+func (a *WALAdapter) writeSnapshot(rec *walRecord) error {
+	frame, err := rec.encode()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(a.snapshotPath(), func(w io.Writer) error {
+		_, err := w.Write(frame)
+		return err
+	})
+}
+
+// Compact writes a snapshot of all rules and truncates the log
+func (a *WALAdapter) Compact() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.log == nil {
+		return errors.New(str.ERR_ADAPTER_CLOSED)
+	}
+	return a.compact()
+}
+
+// Close closes the log file. The adapter can not be used afterwards.
+func (a *WALAdapter) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.log == nil {
+		return nil
+	}
+	err := a.log.Close()
+	a.log = nil
+	return err
+}
+
+func (a *WALAdapter) LoadPolicy(model api.IAddRuleBool) error {
+	a.mu.Lock()
+	rules := a.rules.Rules()
+	a.mu.Unlock()
+
+	for _, rule := range rules {
+		if _, err := model.AddRule(rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SavePolicy replaces all stored rules with the rules of model
+func (a *WALAdapter) SavePolicy(model api.IRangeRules) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.log == nil {
+		return errors.New(str.ERR_ADAPTER_CLOSED)
+	}
+
+	rules := [][]string{}
+	model.RangeRules(func(rule []string) bool {
+		rules = append(rules, rule)
+		return true
+	})
+
+	//the stored rules are only replaced, if the snapshot has been written
+	rec := &walRecord{walSnapshot, a.seq + 1, rules}
+	if err := a.writeSnapshot(rec); err != nil {
+		return err
+	}
+	a.apply(rec)
+	a.records = 0
+	return a.resetLog(0)
+}
+
+func (a *WALAdapter) AddRule(rule []string) error {
+	return a.append(walAdd, [][]string{rule})
+}
+
+func (a *WALAdapter) RemoveRule(rule []string) error {
+	return a.append(walRemove, [][]string{rule})
+}
+
+func (a *WALAdapter) AddRules(rules [][]string) error {
+	return a.append(walAdd, rules)
+}
+
+func (a *WALAdapter) RemoveRules(rules [][]string) error {
+	return a.append(walRemove, rules)
+}
+
+func (a *WALAdapter) UpdateRule(oldRule, newRule []string) error {
+	return a.UpdateRules([][]string{oldRule}, [][]string{newRule})
+}
+
+// UpdateRules replaces each rule of oldRules with the rule of newRules at the same index, using a single log record
+func (a *WALAdapter) UpdateRules(oldRules, newRules [][]string) error {
+	if len(oldRules) != len(newRules) {
+		return fmt.Errorf(str.ERR_UPDATE_LENGTH, len(oldRules), len(newRules))
+	}
+	rules := make([][]string, 0, 2*len(oldRules))
+	for i := range oldRules {
+		rules = append(rules, append([]string{string(walRemove)}, oldRules[i]...))
+		rules = append(rules, append([]string{string(walAdd)}, newRules[i]...))
+	}
+	return a.append(walBatch, rules)
+}
+
+// ApplyChanges removes and adds rules with a single log record
+func (a *WALAdapter) ApplyChanges(added, removed [][]string) error {
+	rules := make([][]string, 0, len(added)+len(removed))
+	for _, rule := range removed {
+		rules = append(rules, append([]string{string(walRemove)}, rule...))
+	}
+	for _, rule := range added {
+		rules = append(rules, append([]string{string(walAdd)}, rule...))
+	}
+	return a.append(walBatch, rules)
+}
@@ -0,0 +1,43 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"github.com/abichinger/fastac/model/defs"
+	"gopkg.in/yaml.v3"
+)
+
+// YAMLAdapter stores policy rules in a YAML document.
+// The columns of a rule are named after the arguments of the policy definition.
+//
+//  p:
+//    - sub: alice
+//      obj: data1
+//      act: read
+//  g:
+//    - user: alice
+//      role: admin
+type YAMLAdapter struct {
+	*docAdapter
+}
+
+// NewYAMLAdapter creates a YAMLAdapter. Policy definitions are required for all p keys,
+// grouping rules without a definition use the columns user, role and domain.
+//
+//  pDef := defs.NewPolicyDef("p", "sub, obj, act")
+//  NewYAMLAdapter("policy.yaml", pDef)
+func NewYAMLAdapter(path string, pDefs ...*defs.PolicyDef) *YAMLAdapter {
+	return &YAMLAdapter{newDocAdapter(path, pDefs, yaml.Marshal, yaml.Unmarshal)}
+}
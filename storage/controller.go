@@ -16,22 +16,62 @@ package storage
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/abichinger/fastac/api"
 	"github.com/abichinger/fastac/model"
+	"github.com/abichinger/fastac/str"
 	eventemitter "github.com/vansante/go-event-emitter"
 )
 
-type opcode int
+const (
+	// EVT_FLUSH_FAILED is emitted with a *FlushError, whenever Flush fails
+	EVT_FLUSH_FAILED eventemitter.EventType = "flush_failed"
+)
+
+type Opcode int
+
+const (
+	OpAdd Opcode = iota
+	OpRemove
+	OpUpdate
+	// OpSetExpiry stores the expiry time of Rule, it is only queued for adapters implementing ExpiryAdapter
+	OpSetExpiry
+)
+
+type Operation struct {
+	Opc  Opcode
+	Rule []string
+	// OldRule is the rule replaced by Rule, only set for OpUpdate
+	OldRule []string
+	// Expires is the expiry time of Rule, only set for OpSetExpiry
+	Expires time.Time
+}
+
+// FlushErrorPolicy determines how the StorageController handles operations, which could not be stored
+type FlushErrorPolicy int
 
 const (
-	add opcode = iota
-	remove
+	// FlushRollback removes failed operations from the queue, the Enforcer reverts them in the model (default)
+	FlushRollback FlushErrorPolicy = iota
+	// FlushKeep keeps failed operations in the queue, they are sent again with the next flush
+	FlushKeep
 )
 
-type operation struct {
-	opc  opcode
-	rule []string
+// FlushError is returned by Flush, if the adapter fails to store an operation
+type FlushError struct {
+	Err error
+	// Ops contains all operations, which have not been stored, in the order they were queued
+	Ops []Operation
+}
+
+func (e *FlushError) Error() string {
+	return fmt.Sprintf(str.ERR_FLUSH_FAILED, len(e.Ops), e.Err)
+}
+
+func (e *FlushError) Unwrap() error {
+	return e.Err
 }
 
 type listener struct {
@@ -40,20 +80,26 @@ type listener struct {
 }
 
 type StorageController struct {
-	autosave  bool
-	em        api.IAddRemoveListener
-	adapter   Adapter
-	q         []operation
-	wait      int
-	listeners []listener
+	autosave    bool
+	em          api.IAddRemoveListener
+	adapter     Adapter
+	q           []Operation
+	wait        int
+	listeners   []listener
+	errorPolicy FlushErrorPolicy
+	retries     int
+	backoff     time.Duration
+
+	*eventemitter.Emitter
 }
 
-func NewStorageController(eventemitter api.IAddRemoveListener, adapter Adapter, autosave bool) *StorageController {
+func NewStorageController(emitter api.IAddRemoveListener, adapter Adapter, autosave bool) *StorageController {
 	sc := &StorageController{
-		em:        eventemitter,
+		em:        emitter,
 		adapter:   adapter,
 		autosave:  autosave,
 		listeners: []listener{},
+		Emitter:   eventemitter.NewEmitter(false),
 	}
 
 	sc.Enable()
@@ -61,10 +107,13 @@ func NewStorageController(eventemitter api.IAddRemoveListener, adapter Adapter,
 	return sc
 }
 
-func (sc *StorageController) addListener(event eventemitter.EventType, opc opcode) {
+func (sc *StorageController) addListener(event eventemitter.EventType, opc Opcode) {
 	l := sc.em.AddListener(event, func(arguments ...interface{}) {
-		rule := arguments[0].([]string)
-		sc.addOp(opc, rule)
+		op := Operation{Opc: opc, Rule: arguments[0].([]string)}
+		if opc == OpUpdate {
+			op.OldRule, op.Rule = op.Rule, arguments[1].([]string)
+		}
+		sc.addOp(op)
 	})
 
 	sc.listeners = append(sc.listeners, listener{event, l})
@@ -82,10 +131,11 @@ func (sc *StorageController) Enable() {
 
 	listenerParams := []struct {
 		evt eventemitter.EventType
-		opc opcode
+		opc Opcode
 	}{
-		{model.RULE_ADDED, add},
-		{model.RULE_REMOVED, remove},
+		{model.RULE_ADDED, OpAdd},
+		{model.RULE_REMOVED, OpRemove},
+		{model.RULE_UPDATED, OpUpdate},
 	}
 
 	for _, params := range listenerParams {
@@ -100,19 +150,27 @@ func (sc *StorageController) Disable() {
 	sc.listeners = []listener{}
 }
 
-func (sc *StorageController) addOp(opc opcode, rule []string) {
-	sc.q = append(sc.q, operation{opc, rule})
+// addOp queues an operation. If autosave is enabled, the queue is flushed.
+// A failure is reported by EVT_FLUSH_FAILED, callers, which need the error, disable autosave and call Flush.
+func (sc *StorageController) addOp(op Operation) {
+	sc.q = append(sc.q, op)
 	if sc.autosave {
 		sc.wait--
 		if sc.wait <= 0 {
-			err := sc.Flush()
-			if err != nil {
-				panic(err)
-			}
+			_ = sc.Flush()
 		}
 	}
 }
 
+// SetExpiry queues the expiry time of rule, it is stored after the queued operations, which precede it.
+// Nothing is queued, if the adapter does not implement ExpiryAdapter or the controller is disabled.
+func (sc *StorageController) SetExpiry(rule []string, expires time.Time) {
+	if _, ok := sc.adapter.(ExpiryAdapter); !ok || !sc.Enabled() {
+		return
+	}
+	sc.addOp(Operation{Opc: OpSetExpiry, Rule: rule, Expires: expires})
+}
+
 func (sc *StorageController) EnableAutosave() {
 	sc.autosave = true
 }
@@ -125,50 +183,103 @@ func (sc *StorageController) AutosaveEnabled() bool {
 	return sc.autosave
 }
 
+func (sc *StorageController) SetErrorPolicy(policy FlushErrorPolicy) {
+	sc.errorPolicy = policy
+}
+
+func (sc *StorageController) ErrorPolicy() FlushErrorPolicy {
+	return sc.errorPolicy
+}
+
+// SetRetry sets the number of retries for failed adapter calls.
+// The delay between two attempts starts at backoff and is doubled after each attempt.
+func (sc *StorageController) SetRetry(retries int, backoff time.Duration) {
+	sc.retries = retries
+	sc.backoff = backoff
+}
+
+func (sc *StorageController) Retry() (retries int, backoff time.Duration) {
+	return sc.retries, sc.backoff
+}
+
+// Pending returns the operations, which have not been flushed yet
+func (sc *StorageController) Pending() []Operation {
+	return append([]Operation{}, sc.q...)
+}
+
+// Discard removes all pending operations without sending them to the adapter
+func (sc *StorageController) Discard() []Operation {
+	q := sc.q
+	sc.q = []Operation{}
+	return q
+}
+
+func (sc *StorageController) retry(fn func() error) error {
+	err := fn()
+	backoff := sc.backoff
+	for i := 0; i < sc.retries && err != nil; i++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		err = fn()
+	}
+	return err
+}
+
 func (sc *StorageController) flush() error {
 	for len(sc.q) > 0 {
 		operation := sc.q[0]
-		sc.q = sc.q[1:]
-		if err := sc.run(operation.opc, operation.rule); err != nil {
+		err := sc.retry(func() error {
+			return sc.runOp(operation)
+		})
+		if err != nil {
 			return err
 		}
+		sc.q = sc.q[1:]
 	}
 	return nil
 }
 
 func (sc *StorageController) batchFlush() error {
-
-	rules := [][]string{}
-
-	var currentOpc opcode
-	if len(sc.q) > 0 {
-		currentOpc = sc.q[0].opc
-	}
-
 	for len(sc.q) > 0 {
+		//consecutive operations of the same type are sent in one batch
+		opc := sc.q[0].Opc
+		n := 1
+		for n < len(sc.q) && sc.q[n].Opc == opc {
+			n++
+		}
 
-		operation := sc.q[0]
-		sc.q = sc.q[1:]
-
-		if currentOpc == operation.opc {
-			rules = append(rules, operation.rule)
-		} else {
-			if err := sc.runBatch(currentOpc, rules); err != nil {
-				return err
-			}
-			currentOpc = operation.opc
-			rules = [][]string{operation.rule}
+		rules := make([][]string, n)
+		oldRules := make([][]string, n)
+		for i := range rules {
+			rules[i] = sc.q[i].Rule
+			oldRules[i] = sc.q[i].OldRule
 		}
-	}
 
-	if len(rules) > 0 {
-		if err := sc.runBatch(currentOpc, rules); err != nil {
+		err := sc.retry(func() error {
+			switch opc {
+			case OpUpdate:
+				return sc.runBatchUpdate(oldRules, rules)
+			case OpSetExpiry:
+				//expiry times are stored one by one, a retry stores all of them again
+				for _, op := range sc.q[:n] {
+					if err := sc.runOp(op); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			return sc.runBatch(opc, rules)
+		})
+		if err != nil {
 			return err
 		}
+		sc.q = sc.q[n:]
 	}
 	return nil
 }
 
+// Flush sends all queued operations to the adapter.
+// If the adapter fails, a *FlushError is returned and the remaining operations are handled according to the FlushErrorPolicy.
 func (sc *StorageController) Flush() error {
 	var err error
 
@@ -178,34 +289,145 @@ func (sc *StorageController) Flush() error {
 	case SimpleAdapter:
 		err = sc.flush()
 	default:
-		err = errors.New("invalid adapter")
+		err = errors.New(str.ERR_INVALID_ADAPTER)
 	}
 
 	sc.wait = 0
-	return err
+	if err != nil {
+		return sc.handleError(err)
+	}
+	return nil
 }
 
-func (sc *StorageController) run(opc opcode, rule []string) error {
+func (sc *StorageController) handleError(err error) error {
+	fErr := &FlushError{Err: err, Ops: sc.Pending()}
+	if sc.errorPolicy == FlushRollback {
+		sc.q = nil
+	}
+	sc.Emitter.EmitEvent(EVT_FLUSH_FAILED, fErr)
+	return fErr
+}
+
+// Commit sends a set of changes directly to the adapter, the queue is not affected.
+// If the adapter implements TransactionalAdapter, the changes are stored atomically.
+// Otherwise removed and added rules are sent separately and on failure the already stored changes are reverted (best effort).
+func (sc *StorageController) Commit(added, removed [][]string) error {
+	var err error
+	switch a := sc.adapter.(type) {
+	case TransactionalAdapter:
+		err = sc.retry(func() error {
+			return a.ApplyChanges(added, removed)
+		})
+	case BatchAdapter, SimpleAdapter:
+		err = sc.commit(added, removed)
+	default:
+		err = errors.New(str.ERR_INVALID_ADAPTER)
+	}
+
+	if err != nil {
+		ops := make([]Operation, 0, len(added)+len(removed))
+		for _, rule := range removed {
+			ops = append(ops, Operation{Opc: OpRemove, Rule: rule})
+		}
+		for _, rule := range added {
+			ops = append(ops, Operation{Opc: OpAdd, Rule: rule})
+		}
+		fErr := &FlushError{Err: err, Ops: ops}
+		sc.Emitter.EmitEvent(EVT_FLUSH_FAILED, fErr)
+		return fErr
+	}
+	return nil
+}
+
+func (sc *StorageController) commit(added, removed [][]string) error {
+	if err := sc.runAll(OpRemove, removed); err != nil {
+		return err
+	}
+	if err := sc.runAll(OpAdd, added); err != nil {
+		_ = sc.runAll(OpAdd, removed)
+		return err
+	}
+	return nil
+}
+
+// runAll sends rules to the adapter, already stored rules are reverted on failure
+func (sc *StorageController) runAll(opc Opcode, rules [][]string) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	if _, ok := sc.adapter.(BatchAdapter); ok {
+		return sc.retry(func() error {
+			return sc.runBatch(opc, rules)
+		})
+	}
+
+	inverse := OpAdd
+	if opc == OpAdd {
+		inverse = OpRemove
+	}
+	for i, rule := range rules {
+		err := sc.retry(func() error {
+			return sc.run(opc, rule)
+		})
+		if err != nil {
+			for _, rule := range rules[:i] {
+				_ = sc.run(inverse, rule)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (sc *StorageController) run(opc Opcode, rule []string) error {
 	adapter := sc.adapter.(SimpleAdapter)
 	var err error
 
 	switch opc {
-	case add:
+	case OpAdd:
 		err = adapter.AddRule(rule)
-	case remove:
+	case OpRemove:
 		err = adapter.RemoveRule(rule)
 	}
 	return err
 }
 
-func (sc *StorageController) runBatch(opc opcode, rules [][]string) error {
+// runOp sends a single operation, updates are split into remove and add if the adapter is not an UpdatableAdapter
+func (sc *StorageController) runOp(op Operation) error {
+	if op.Opc == OpSetExpiry {
+		return sc.adapter.(ExpiryAdapter).SetExpiry(op.Rule, op.Expires)
+	}
+	if op.Opc != OpUpdate {
+		return sc.run(op.Opc, op.Rule)
+	}
+	if adapter, ok := sc.adapter.(UpdatableAdapter); ok {
+		return adapter.UpdateRule(op.OldRule, op.Rule)
+	}
+	if err := sc.run(OpRemove, op.OldRule); err != nil {
+		return err
+	}
+	return sc.run(OpAdd, op.Rule)
+}
+
+// runBatchUpdate sends a batch of updates, updates are split into remove and add if the adapter is not an UpdatableAdapter
+func (sc *StorageController) runBatchUpdate(oldRules, newRules [][]string) error {
+	if adapter, ok := sc.adapter.(UpdatableAdapter); ok {
+		return adapter.UpdateRules(oldRules, newRules)
+	}
+	if err := sc.runBatch(OpRemove, oldRules); err != nil {
+		return err
+	}
+	return sc.runBatch(OpAdd, newRules)
+}
+
+func (sc *StorageController) runBatch(opc Opcode, rules [][]string) error {
 	adapter := sc.adapter.(BatchAdapter)
 	var err error
 
 	switch opc {
-	case add:
+	case OpAdd:
 		err = adapter.AddRules(rules)
-	case remove:
+	case OpRemove:
 		err = adapter.RemoveRules(rules)
 	}
 	return err
@@ -15,7 +15,9 @@
 package storage
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/abichinger/fastac/api"
 	"github.com/abichinger/fastac/model"
@@ -107,6 +109,47 @@ func TestFlush(t *testing.T) {
 
 }
 
+type UpdatableAdapterMock struct {
+	BatchAdapterMock
+	updateCalls int
+}
+
+func (a *UpdatableAdapterMock) UpdateRule(oldRule, newRule []string) error {
+	a.updateCalls++
+	return nil
+}
+func (a *UpdatableAdapterMock) UpdateRules(oldRules, newRules [][]string) error {
+	a.updateCalls++
+	return nil
+}
+
+func TestUpdate(t *testing.T) {
+	e := NewEmitterMock()
+
+	simple := &SimpleAdapterMock{}
+	batch := &BatchAdapterMock{}
+	updatable := &UpdatableAdapterMock{}
+
+	for _, adapter := range []Adapter{simple, batch, updatable} {
+		sc := NewStorageController(e, adapter, false)
+		e.handlers[model.RULE_UPDATED]([]string{"p", "alice", "data1", "read"}, []string{"p", "alice", "data1", "write"})
+		e.handlers[model.RULE_UPDATED]([]string{"p", "bob", "data1", "read"}, []string{"p", "bob", "data1", "write"})
+		assert.Equal(t, []Operation{
+			{Opc: OpUpdate, Rule: []string{"p", "alice", "data1", "write"}, OldRule: []string{"p", "alice", "data1", "read"}},
+			{Opc: OpUpdate, Rule: []string{"p", "bob", "data1", "write"}, OldRule: []string{"p", "bob", "data1", "read"}},
+		}, sc.Pending())
+		assert.NoError(t, sc.Flush())
+	}
+
+	//updates are split into remove and add, if the adapter does not support updates
+	assert.Equal(t, 2, simple.RemoveCalls())
+	assert.Equal(t, 2, simple.AddCalls())
+	assert.Equal(t, 1, batch.RemoveCalls())
+	assert.Equal(t, 1, batch.AddCalls())
+	assert.Equal(t, 0, updatable.AddCalls())
+	assert.Equal(t, 1, updatable.updateCalls)
+}
+
 func TestAutosave(t *testing.T) {
 	e := NewEmitterMock()
 
@@ -193,3 +236,113 @@ func TestEnableDisable(t *testing.T) {
 	}
 
 }
+
+type FailingAdapterMock struct {
+	BatchAdapterMock
+	failures int
+}
+
+func (a *FailingAdapterMock) AddRules(rules [][]string) error {
+	if a.failures > 0 {
+		a.failures--
+		return errors.New("adapter unavailable")
+	}
+	return a.BatchAdapterMock.AddRules(rules)
+}
+
+func TestFlushError(t *testing.T) {
+	e := NewEmitterMock()
+
+	tests := []struct {
+		name            string
+		policy          FlushErrorPolicy
+		retries         int
+		failures        int
+		expectedErr     bool
+		expectedPending int
+	}{
+		{"rollback", FlushRollback, 0, 1, true, 0},
+		{"keep", FlushKeep, 0, 1, true, 2},
+		{"retry", FlushRollback, 2, 2, false, 0},
+		{"retry exhausted", FlushKeep, 2, 3, true, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := &FailingAdapterMock{failures: test.failures}
+			sc := NewStorageController(e, a, false)
+			sc.SetErrorPolicy(test.policy)
+			sc.SetRetry(test.retries, time.Millisecond)
+
+			events := 0
+			sc.AddListener(EVT_FLUSH_FAILED, func(arguments ...interface{}) {
+				events++
+				assert.IsType(t, &FlushError{}, arguments[0])
+			})
+
+			e.handlers[model.RULE_REMOVED]([]string{"p", "alice", "data1", "read"})
+			e.handlers[model.RULE_ADDED]([]string{"p", "alice", "data2", "read"})
+			e.handlers[model.RULE_ADDED]([]string{"p", "bob", "data2", "read"})
+
+			err := sc.Flush()
+			assert.Len(t, sc.Pending(), test.expectedPending)
+			assert.Equal(t, 1, a.RemoveCalls())
+			if !test.expectedErr {
+				assert.NoError(t, err)
+				assert.Equal(t, 0, events)
+				assert.Equal(t, 1, a.AddCalls())
+				return
+			}
+
+			var fErr *FlushError
+			assert.True(t, errors.As(err, &fErr))
+			assert.Equal(t, []Operation{
+				{Opc: OpAdd, Rule: []string{"p", "alice", "data2", "read"}},
+				{Opc: OpAdd, Rule: []string{"p", "bob", "data2", "read"}},
+			}, fErr.Ops)
+			assert.Equal(t, 1, events)
+			assert.Equal(t, 0, a.AddCalls())
+		})
+	}
+}
+
+func TestAutosaveError(t *testing.T) {
+	e := NewEmitterMock()
+	a := &FailingAdapterMock{failures: 1}
+	sc := NewStorageController(e, a, true)
+
+	events := 0
+	sc.AddListener(EVT_FLUSH_FAILED, func(arguments ...interface{}) {
+		events++
+	})
+
+	e.handlers[model.RULE_ADDED]([]string{"p", "alice", "data1", "read"})
+	assert.Equal(t, 1, events)
+	assert.Empty(t, sc.Pending())
+
+	e.handlers[model.RULE_ADDED]([]string{"p", "alice", "data1", "read"})
+	assert.Equal(t, 1, events)
+	assert.Equal(t, 1, a.AddCalls())
+}
+
+func TestCommit(t *testing.T) {
+	e := NewEmitterMock()
+	a := &FailingAdapterMock{failures: 1}
+	sc := NewStorageController(e, a, false)
+
+	added := [][]string{{"p", "bob", "data2", "read"}}
+	removed := [][]string{{"p", "alice", "data1", "read"}}
+
+	//the removed rules are restored after the batch of added rules failed
+	err := sc.Commit(added, removed)
+	var fErr *FlushError
+	assert.True(t, errors.As(err, &fErr))
+	assert.Equal(t, []Operation{{Opc: OpRemove, Rule: removed[0]}, {Opc: OpAdd, Rule: added[0]}}, fErr.Ops)
+	assert.Equal(t, 1, a.RemoveCalls())
+	assert.Equal(t, 1, a.AddCalls())
+
+	assert.NoError(t, sc.Commit(added, removed))
+	assert.Equal(t, 2, a.RemoveCalls())
+	assert.Equal(t, 2, a.AddCalls())
+	assert.Empty(t, sc.Pending())
+}
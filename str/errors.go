@@ -15,12 +15,60 @@
 package str
 
 const (
-	ERR_INVALID_SEC          = "error: invalid sec %c"
-	ERR_INVALID_KEY_PREFIX   = "error: key of %s must start with '%c'"
-	ERR_MATCHER_NOT_FOUND    = "error: matcher %s not found"
-	ERR_POLICY_NOT_FOUND     = "error: policy %s not found"
-	ERR_RM_NOT_FOUND         = "error: role manager %s not found"
-	ERR_REQUESTDEF_NOT_FOUND = "error: request definition %s not found"
-	ERR_EFFECTOR_NOT_FOUND   = "error: effect definition %s not found"
-	ERR_INVALID_MODEL        = "invalid model"
+	ERR_INVALID_SEC           = "error: invalid sec %c"
+	ERR_INVALID_KEY_PREFIX    = "error: key of %s must start with '%c'"
+	ERR_MATCHER_NOT_FOUND     = "error: matcher %s not found"
+	ERR_POLICY_NOT_FOUND      = "error: policy %s not found"
+	ERR_RM_NOT_FOUND          = "error: role manager %s not found"
+	ERR_REQUESTDEF_NOT_FOUND  = "error: request definition %s not found"
+	ERR_EFFECTOR_NOT_FOUND    = "error: effect definition %s not found"
+	ERR_INVALID_MODEL         = "invalid model"
+	ERR_UNKNOWN_COLUMN        = "error: unknown column %s in policy %s"
+	ERR_TOO_MANY_VALUES       = "error: rule %v has more values than the definition of %s"
+	ERR_ADAPTER_CLOSED        = "error: adapter is closed"
+	ERR_WAL_CORRUPT           = "error: corrupted record in %s: %s"
+	ERR_WAL_COMPACT           = "error: failed to compact %s: %s"
+	ERR_WAL_CHECKSUM          = "checksum mismatch"
+	ERR_WAL_INVALID_RECORD    = "invalid record"
+	ERR_INVALID_ADAPTER       = "invalid adapter"
+	ERR_FLUSH_FAILED          = "error: failed to flush %d operation(s): %s"
+	ERR_UPDATE_KEY_MISMATCH   = "error: can not update rule %v to %v, the policy keys differ"
+	ERR_UPDATE_LENGTH         = "error: number of old rules (%d) and new rules (%d) differ"
+	ERR_INVALID_SYNC          = "error: invalid sync direction %d"
+	ERR_INVALID_TIME          = "error: invalid time %v"
+	ERR_NOT_CONDITIONAL       = "error: role manager %s does not support link conditions"
+	ERR_NO_PROVIDER_SUPPORT   = "error: role manager %s does not support role providers"
+	ERR_UNKNOWN_ROLE_OPTION   = "error: unknown role option %s"
+	ERR_INVALID_ROLE_OPTION   = "error: invalid value %s of role option %s"
+	ERR_ROLE_MATCHER          = "error: role matcher %s not found"
+	ERR_IN_DOMAIN             = "%s in domain %s"
+	ERR_ROLE_CYCLE            = "error: role cycle %s"
+	ERR_ROLE_DEPTH            = "error: %s inherits %s through %d links, but only %d links are followed"
+	ERR_DANGLING_ROLE         = "error: role %s is not used by any policy and does not inherit other roles"
+	ERR_SOD_VIOLATION         = "error: constraint %s violated, %s must not hold more than one of the roles %s"
+	ERR_CARDINALITY_VIOLATION = "error: constraint %s violated, role %s must not have more than %d users"
+	ERR_DSD_VIOLATION         = "error: constraint %s violated, the roles %s must not be activated in the same session"
+	ERR_INVALID_FILTER        = "error: invalid directory filter %s"
+	ERR_GRAPH_FORMAT          = "error: unknown graph format %s"
+	ERR_MINING_ROLE_DEF       = "error: role mining requires a role definition %s with two arguments"
+	ERR_DECISION_CHANGED      = "error: the decision of request %s changed from %t to %t"
+	ERR_INVALID_CONSTRAINT    = "error: invalid constraint %s = %s"
+	ERR_UNKNOWN_SECTION       = "error: unknown section %s"
+	ERR_MISSING_SECTION       = "error: missing section %s"
+	ERR_UNSUPPORTED_EFFECT    = "error: unsupported effect %s = %s"
+	ERR_INVALID_MATCHER       = "error: invalid matcher %s: %s"
+	ERR_UNDEFINED_ARG         = "error: matcher %s references undefined argument %s"
+	ERR_MIXED_POLICY_KEYS     = "error: matcher %s references multiple policies %s"
+	ERR_ROLE_ARITY            = "error: matcher %s calls %s with %d arguments, but %s requires %s"
+	ERR_INVALID_EXPECTATION   = "error: invalid expectation %s of test %s, expected allow or deny"
+	ERR_MISSING_REQUEST_ARG   = "error: request argument %s is missing"
+	ERR_UNKNOWN_REQUEST_ARG   = "error: unknown request argument %s"
+	ERR_EMPTY_RULE            = "error: empty rule"
+	ERR_METHOD_NOT_ALLOWED    = "error: method %s is not allowed for %s"
+	ERR_NO_MODEL_FILE         = "error: no model file to reload"
+	ERR_NOT_READY             = "error: server is not ready"
+	ERR_EXPIRY_IN_PAST        = "error: expiry time %s is not in the future"
+	ERR_READ_ONLY             = "error: method %s is not allowed for %s, the server is read-only"
+	ERR_BATCH_TOO_LARGE       = "error: batch contains %d requests, the limit is %d"
+	ERR_INVALID_TOKEN         = "error: missing or invalid bearer token"
 )
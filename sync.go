@@ -0,0 +1,85 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fastac
+
+import (
+	"fmt"
+
+	m "github.com/abichinger/fastac/model"
+	a "github.com/abichinger/fastac/storage/adapter"
+	"github.com/abichinger/fastac/str"
+)
+
+type SyncDirection int
+
+const (
+	// SyncFromAdapter changes the model to match the rules of the adapter
+	SyncFromAdapter SyncDirection = iota
+	// SyncToAdapter changes the stored rules to match the model
+	SyncToAdapter
+)
+
+// DiffAdapter compares the model with the rules stored by the adapter.
+// added contains the rules, which are only stored by the adapter and removed the rules, which are only present in the model.
+func (e *Enforcer) DiffAdapter() (added, removed [][]string, err error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.diffAdapter()
+}
+
+func (e *Enforcer) diffAdapter() (added, removed [][]string, err error) {
+	rs := a.NewRuleSet()
+	if err := e.adapter.LoadPolicy(rs); err != nil {
+		return nil, nil, err
+	}
+	added, removed = m.Diff(e.model, rs)
+	return added, removed, nil
+}
+
+// Sync applies the minimal set of changes to make the model and the adapter contain the same rules.
+// Unlike LoadPolicy and SavePolicy, rules are also removed and unchanged rules are not touched.
+// Pending operations of the storage controller are discarded, because they are covered by the sync.
+//
+// Reload the policy without clearing the model:
+//  e.Sync(SyncFromAdapter)
+func (e *Enforcer) Sync(direction SyncDirection) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	added, removed, err := e.diffAdapter()
+	if err != nil {
+		return err
+	}
+
+	switch direction {
+	case SyncFromAdapter:
+		if e.sc.Enabled() {
+			e.sc.Disable()
+			defer e.sc.Enable()
+		}
+		err = e.applyChanges(added, removed)
+	case SyncToAdapter:
+		//the diff is inverted, because the adapter is modified
+		err = e.sc.Commit(removed, added)
+	default:
+		return fmt.Errorf(str.ERR_INVALID_SYNC, direction)
+	}
+
+	if err != nil {
+		return err
+	}
+	e.sc.Discard()
+	return nil
+}
@@ -0,0 +1,227 @@
+// Copyright 2022 The FastAC Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fastac
+
+import (
+	"fmt"
+
+	m "github.com/abichinger/fastac/model"
+	"github.com/abichinger/fastac/storage"
+	"github.com/abichinger/fastac/str"
+	"github.com/abichinger/fastac/util"
+)
+
+// Tx collects rule changes, which are applied by Enforcer.Transaction.
+// Changes are visible in the model immediately, but no RULE_ADDED/RULE_REMOVED events are emitted until the transaction is committed.
+type Tx struct {
+	e   *Enforcer
+	ops []storage.Operation
+	//locked is true, if the caller of the transaction holds e.mu
+	locked bool
+}
+
+// Transaction calls fn and applies all changes made through tx atomically.
+// If fn returns an error or the adapter fails to store the changes, the model is rolled back to its prior state.
+// Listeners of the model are only notified about the net changes of a successful transaction.
+//
+//  err := e.Transaction(func(tx *Tx) error {
+//  	if _, err := tx.RemoveRule([]string{"g", "alice", "admin"}); err != nil {
+//  		return err
+//  	}
+//  	_, err := tx.AddRule([]string{"g", "bob", "admin"})
+//  	return err
+//  })
+//
+// Each change locks the Enforcer on its own, so fn can call other methods of the Enforcer, e.g. Enforce.
+func (e *Enforcer) Transaction(fn func(tx *Tx) error) error {
+	return e.transaction(false, fn)
+}
+
+func (e *Enforcer) transaction(locked bool, fn func(tx *Tx) error) error {
+	tx := &Tx{e: e, locked: locked}
+	if err := fn(tx); err != nil {
+		tx.rollback()
+		return err
+	}
+	return tx.commit()
+}
+
+// lock locks the Enforcer, unless the caller of the transaction holds the lock already
+func (tx *Tx) lock() func() {
+	if tx.locked {
+		return func() {}
+	}
+	tx.e.mu.Lock()
+	return tx.e.mu.Unlock
+}
+
+// apply modifies the policy directly to bypass the events of the model
+func (tx *Tx) apply(opc storage.Opcode, rule []string) (bool, error) {
+	defer tx.lock()()
+	if len(rule) == 0 {
+		return false, fmt.Errorf(str.ERR_POLICY_NOT_FOUND, "")
+	}
+	p, ok := tx.e.model.GetPolicy(rule[0])
+	if !ok {
+		return false, fmt.Errorf(str.ERR_POLICY_NOT_FOUND, rule[0])
+	}
+
+	var changed bool
+	var err error
+	switch opc {
+	case storage.OpAdd:
+		changed, err = p.AddRule(rule[1:])
+	case storage.OpRemove:
+		changed, err = p.RemoveRule(rule[1:])
+	}
+	if changed {
+		tx.ops = append(tx.ops, storage.Operation{Opc: opc, Rule: rule})
+	}
+	return changed, err
+}
+
+// AddRule adds a rule to the model, returns false if the rule was already present
+func (tx *Tx) AddRule(rule []string) (bool, error) {
+	return tx.apply(storage.OpAdd, rule)
+}
+
+// RemoveRule removes a rule from the model, returns false if the rule was not present
+func (tx *Tx) RemoveRule(rule []string) (bool, error) {
+	return tx.apply(storage.OpRemove, rule)
+}
+
+// UpdateRule replaces oldRule with newRule, returns false if oldRule was not present or newRule is already present
+func (tx *Tx) UpdateRule(oldRule, newRule []string) (bool, error) {
+	if len(oldRule) == 0 || len(newRule) == 0 || oldRule[0] != newRule[0] {
+		return false, fmt.Errorf(str.ERR_UPDATE_KEY_MISMATCH, oldRule, newRule)
+	}
+	if exists, err := tx.hasRule(newRule); exists || err != nil {
+		return false, err
+	}
+	removed, err := tx.RemoveRule(oldRule)
+	if !removed || err != nil {
+		return false, err
+	}
+	_, err = tx.AddRule(newRule)
+	return true, err
+}
+
+func (tx *Tx) hasRule(rule []string) (bool, error) {
+	defer tx.lock()()
+	p, ok := tx.e.model.GetPolicy(rule[0])
+	if !ok {
+		return false, fmt.Errorf(str.ERR_POLICY_NOT_FOUND, rule[0])
+	}
+	return p.HasRule(rule[1:]), nil
+}
+
+// AddRules adds multiple rules to the model
+func (tx *Tx) AddRules(rules [][]string) error {
+	for _, rule := range rules {
+		if _, err := tx.AddRule(rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveRules removes multiple rules from the model
+func (tx *Tx) RemoveRules(rules [][]string) error {
+	for _, rule := range rules {
+		if _, err := tx.RemoveRule(rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollback reverts all changes in reverse order
+func (tx *Tx) rollback() {
+	defer tx.lock()()
+	tx.undo()
+}
+
+func (tx *Tx) undo() {
+	for i := len(tx.ops) - 1; i >= 0; i-- {
+		op := tx.ops[i]
+		p, _ := tx.e.model.GetPolicy(op.Rule[0])
+		switch op.Opc {
+		case storage.OpAdd:
+			_, _ = p.RemoveRule(op.Rule[1:])
+		case storage.OpRemove:
+			_, _ = p.AddRule(op.Rule[1:])
+		}
+	}
+	tx.ops = nil
+}
+
+// changes returns the net changes of the transaction, a rule which is added and removed again is omitted
+func (tx *Tx) changes() (added, removed [][]string) {
+	net := map[string]int{}
+	order := []storage.Operation{}
+	for _, op := range tx.ops {
+		key := util.Hash(op.Rule)
+		if _, ok := net[key]; !ok {
+			order = append(order, op)
+		}
+		if op.Opc == storage.OpAdd {
+			net[key]++
+		} else {
+			net[key]--
+		}
+	}
+
+	for _, op := range order {
+		switch net[util.Hash(op.Rule)] {
+		case 1:
+			added = append(added, op.Rule)
+		case -1:
+			removed = append(removed, op.Rule)
+		}
+	}
+	return added, removed
+}
+
+func (tx *Tx) commit() error {
+	added, removed := tx.changes()
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	defer tx.lock()()
+	sc := tx.e.sc
+	if sc.Enabled() && sc.AutosaveEnabled() {
+		//store pending operations of earlier changes first
+		if err := tx.e.flush(); err != nil {
+			tx.undo()
+			return err
+		}
+		if err := sc.Commit(added, removed); err != nil {
+			tx.undo()
+			return err
+		}
+		//the changes are already stored
+		sc.Disable()
+		defer sc.Enable()
+	}
+
+	for _, rule := range removed {
+		tx.e.model.EmitEvent(m.RULE_REMOVED, rule)
+	}
+	for _, rule := range added {
+		tx.e.model.EmitEvent(m.RULE_ADDED, rule)
+	}
+	return nil
+}
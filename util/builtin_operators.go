@@ -208,6 +208,11 @@ func IsPathPatternHelper(pattern, sep string, prefix, suffix byte) bool {
 	return false
 }
 
+// IsGlobPattern returns true, if pattern contains any of the special characters *?[
+func IsGlobPattern(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
 func PathMatch(path, pattern string) bool {
 	return PathMatchHelper(path, pattern, "/", ':', 0)
 }
@@ -234,3 +239,7 @@ const defaultPrefix = "p'"
 var PathMatcher = NewMatcher(IsPathPattern, PathMatch)
 var PathMatcher2 = NewMatcher(IsPathPattern2, PathMatch2)
 var RegexMatcher = NewPrefixMatcher(defaultPrefix, RegexMatch)
+var GlobMatcher = NewMatcher(IsGlobPattern, func(str, pattern string) bool {
+	matched, _ := GlobMatch(str, pattern)
+	return matched
+})